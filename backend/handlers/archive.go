@@ -0,0 +1,1039 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ArchiveResult struct {
+	Path    string   `json:"path"`
+	Entries int      `json:"entries"`
+	Bytes   int64    `json:"bytes"`
+	Skipped []string `json:"skipped"` // "name: reason"
+}
+
+type archiveFormat struct {
+	ext      string
+	mimeType string
+}
+
+var archiveFormats = map[string]archiveFormat{
+	"zip":     {".zip", "application/zip"},
+	"tar":     {".tar", "application/x-tar"},
+	"tar.gz":  {".tar.gz", "application/gzip"},
+	"tar.zst": {".tar.zst", "application/zstd"},
+}
+
+const archiveStagingPrefix = ".webos-archive-"
+
+var (
+	errUnsafeEntry = errors.New("entry would be written outside the destination")
+	errUnsafeLink  = errors.New("link points outside the destination")
+)
+
+// archiveFormatOf detects the archive format from a file name
+func archiveFormatOf(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tzst"):
+		return "tar.zst"
+	}
+	return ""
+}
+
+// CreateArchive compresses the selected paths. Without a destination the
+// archive is streamed to the client; with one it is written to disk by a
+// file job whose progress is available under /files/jobs.
+func CreateArchive(c *gin.Context) {
+	var request struct {
+		Paths       []string `json:"paths"`
+		Format      string   `json:"format"`
+		Destination string   `json:"destination"`
+		Overwrite   bool     `json:"overwrite"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	if request.Format == "" {
+		request.Format = "zip"
+	}
+	format, ok := archiveFormats[request.Format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported archive format: " + request.Format})
+		return
+	}
+	if len(request.Paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No paths selected"})
+		return
+	}
+
+	// エントリ名は各パスの親ディレクトリからの相対パスになるため、名前の重複は不可
+	sources := make([]string, 0, len(request.Paths))
+	names := map[string]bool{}
+	for _, p := range request.Paths {
+		source, err := jail.resolve(p, false)
+		if err != nil {
+			respondFileError(c, err, "Invalid path")
+			return
+		}
+		if _, err := os.Lstat(source); err != nil {
+			respondFileError(c, err, "File not found: "+p)
+			return
+		}
+		if names[filepath.Base(source)] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Selected paths must have distinct names"})
+			return
+		}
+		names[filepath.Base(source)] = true
+		sources = append(sources, source)
+	}
+
+	if request.Destination == "" {
+		name := "archive"
+		if len(sources) == 1 && sources[0] != "/" {
+			name = filepath.Base(sources[0])
+		}
+		c.Header("Content-Type", format.mimeType)
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + format.ext}))
+		c.Status(http.StatusOK)
+
+		// ヘッダー送信後はエラーを返せないので、失敗時は終端を書かずに切り上げる
+		if _, err := writeArchive(c.Request.Context(), jail, sources, request.Format, c.Writer, nil); err != nil {
+			log.Printf("Failed to stream archive: %v", err)
+		}
+		return
+	}
+
+	dest, err := jail.resolve(request.Destination, false)
+	if err != nil {
+		respondFileError(c, err, "Invalid destination")
+		return
+	}
+	if info, err := os.Lstat(dest); err == nil && (info.IsDir() || !request.Overwrite) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Destination already exists"})
+		return
+	}
+	for _, source := range sources {
+		if isWithinPath(source, dest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Destination is inside a selected directory"})
+			return
+		}
+	}
+	if err := jail.checkPermission(filepath.Dir(dest), 2|1, dest); err != nil {
+		respondFileError(c, err, "Destination directory does not exist")
+		return
+	}
+
+	job, err := startFileJob(jail.username, "archive", func(job *FileJob) (any, error) {
+		result, err := writeArchiveFile(job, jail, sources, request.Format, dest)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job.snapshot())
+}
+
+// writeArchiveFile writes the archive through a temporary file next to dest,
+// so a failed or cancelled job leaves nothing behind
+func writeArchiveFile(job *FileJob, jail *fileJail, sources []string, format, dest string) (*ArchiveResult, error) {
+	job.setTotals(measureTree(job.ctx, jail, sources))
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), archiveStagingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	result, err := writeArchive(job.ctx, jail, sources, format, tmp, job)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return nil, err
+	}
+	jail.chownToAccount(dest)
+
+	result.Path = dest
+	return result, nil
+}
+
+// measureTree counts the bytes and entries below sources for progress reporting
+func measureTree(ctx context.Context, jail *fileJail, sources []string) (int64, int) {
+	var size int64
+	entries := 0
+	for _, source := range sources {
+		filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil || jail.check(path) != nil {
+				return nil
+			}
+			entries++
+			if d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					size += info.Size()
+				}
+			}
+			return nil
+		})
+	}
+	return size, entries
+}
+
+// writeArchive walks sources without following symlinks and writes them to w.
+// Symlinks are stored as links, special files and entries the user may not
+// read are skipped. On error the archive is left unterminated.
+func writeArchive(ctx context.Context, jail *fileJail, sources []string, format string, w io.Writer, job *FileJob) (*ArchiveResult, error) {
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ArchiveResult{Skipped: []string{}}
+	skip := func(name string, err error) {
+		result.Skipped = append(result.Skipped, name+": "+err.Error())
+	}
+
+	for _, source := range sources {
+		base := filepath.Dir(source)
+		err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			name, _ := filepath.Rel(base, path)
+			name = filepath.ToSlash(name)
+			if err != nil {
+				skip(name, err)
+				return nil
+			}
+			if err := jail.check(path); err != nil {
+				skip(name, err)
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				skip(name, err)
+				return nil
+			}
+
+			switch mode := info.Mode(); {
+			case mode.IsDir():
+				if err := jail.canRead(path, true); err != nil {
+					skip(name, err)
+					return filepath.SkipDir
+				}
+				err = aw.writeEntry(name, info, "", nil)
+			case mode&fs.ModeSymlink != 0:
+				target, readErr := os.Readlink(path)
+				if readErr != nil {
+					skip(name, readErr)
+					return nil
+				}
+				err = aw.writeEntry(name, info, target, nil)
+			case mode.IsRegular():
+				if err := jail.canRead(path, false); err != nil {
+					skip(name, err)
+					return nil
+				}
+				file, openErr := jail.openFile(path, os.O_RDONLY, 0)
+				if openErr != nil {
+					skip(name, openErr)
+					return nil
+				}
+				var body io.Reader = file
+				if job != nil {
+					body = io.TeeReader(file, progressWriter{job})
+				}
+				err = aw.writeEntry(name, info, "", body)
+				file.Close()
+				result.Bytes += info.Size()
+			default:
+				skip(name, errUnsupportedEntry)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			result.Entries++
+			if job != nil {
+				job.advance(0, 1, name)
+			}
+			return nil
+		})
+		if err != nil {
+			aw.abort()
+			return nil, err
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// archiveWriter hides the differences between zip and the tar variants
+type archiveWriter interface {
+	// writeEntry adds a directory, a symlink (link is its target) or a regular
+	// file whose content is read from body
+	writeEntry(name string, info fs.FileInfo, link string, body io.Reader) error
+	// Close terminates the archive
+	Close() error
+	// abort releases resources without terminating the archive
+	abort()
+}
+
+func newArchiveWriter(w io.Writer, format string) (archiveWriter, error) {
+	switch format {
+	case "zip":
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case "tar":
+		return &tarArchiveWriter{tw: tar.NewWriter(w)}, nil
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case "tar.zst":
+		zw, err := newZstdWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format: %s", format)
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchiveWriter) writeEntry(name string, info fs.FileInfo, link string, body io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	switch {
+	case info.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+	case link != "":
+		// zipではリンク先をエントリの内容として保存する
+		body = strings.NewReader(link)
+		header.Method = zip.Store
+	default:
+		header.Method = zip.Deflate
+	}
+
+	w, err := z.zw.CreateHeader(header)
+	if err != nil || body == nil {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.zw.Close()
+}
+
+func (z *zipArchiveWriter) abort() {}
+
+type tarArchiveWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser // nil for plain tar
+}
+
+func (t *tarArchiveWriter) writeEntry(name string, info fs.FileInfo, link string, body io.Reader) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	// The header fixes the size; a file that shrank meanwhile fails here
+	_, err = io.CopyN(t.tw, body, header.Size)
+	return err
+}
+
+func (t *tarArchiveWriter) Close() error {
+	err := t.tw.Close()
+	if t.compressor != nil {
+		if closeErr := t.compressor.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (t *tarArchiveWriter) abort() {
+	if zw, ok := t.compressor.(*zstdWriter); ok {
+		zw.kill()
+	}
+}
+
+// zstdWriter compresses through the zstd binary; the standard library has no
+// zstd implementation
+type zstdWriter struct {
+	io.WriteCloser // stdin of zstd
+	cmd            *exec.Cmd
+}
+
+func newZstdWriter(w io.Writer) (*zstdWriter, error) {
+	cmd := exec.Command("zstd", "-q", "-c", "-T0")
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("zstd is not available: %w", err)
+	}
+	return &zstdWriter{WriteCloser: stdin, cmd: cmd}, nil
+}
+
+func (z *zstdWriter) Close() error {
+	err := z.WriteCloser.Close()
+	if waitErr := z.cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("zstd: %w", waitErr)
+	}
+	return err
+}
+
+func (z *zstdWriter) kill() {
+	z.cmd.Process.Kill()
+	z.WriteCloser.Close()
+	z.cmd.Wait()
+}
+
+// zstdReader decompresses through the zstd binary
+type zstdReader struct {
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	cmd    *exec.Cmd
+	done   bool
+}
+
+func newZstdReader(r io.Reader) (*zstdReader, error) {
+	z := &zstdReader{cmd: exec.Command("zstd", "-q", "-d", "-c")}
+	z.cmd.Stdin = r
+	z.cmd.Stderr = &z.stderr
+	stdout, err := z.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := z.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("zstd is not available: %w", err)
+	}
+	z.stdout = stdout
+	return z, nil
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.stdout.Read(p)
+	if err == io.EOF && !z.done {
+		z.done = true
+		if waitErr := z.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("zstd: %s", strings.TrimSpace(z.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (z *zstdReader) Close() error {
+	if !z.done {
+		z.done = true
+		z.cmd.Process.Kill()
+		z.cmd.Wait()
+	}
+	return nil
+}
+
+// ExtractArchive unpacks an archive into a directory. The archive is either an
+// existing file ({"path", "destination", "overwrite"}) or uploaded as
+// multipart/form-data with ?destination= and ?overwrite= in the query.
+// Extraction runs as a file job.
+func ExtractArchive(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Path        string `json:"path"`
+		Destination string `json:"destination"`
+		Overwrite   bool   `json:"overwrite"`
+	}
+	uploaded := strings.HasPrefix(c.ContentType(), "multipart/")
+	if uploaded {
+		request.Destination = c.Query("destination")
+		request.Overwrite = c.Query("overwrite") == "true"
+	} else if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	dest, err := jail.resolve(request.Destination, true)
+	if err != nil {
+		respondFileError(c, err, "Invalid destination")
+		return
+	}
+	// 展開先がなければ作成する(親ディレクトリは存在している必要がある)
+	if _, err := os.Lstat(dest); os.IsNotExist(err) {
+		if err := jail.canWrite(dest); err != nil {
+			respondFileError(c, err, "Destination directory does not exist")
+			return
+		}
+		if err := os.Mkdir(dest, 0755); err != nil {
+			respondFileError(c, err, "Failed to create destination")
+			return
+		}
+		jail.chownToAccount(dest)
+	}
+	if info, err := os.Stat(dest); err != nil || !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Destination is not a directory"})
+		return
+	}
+	if err := jail.checkPermission(dest, 2|1, ""); err != nil {
+		respondFileError(c, err, "Failed to extract")
+		return
+	}
+
+	var archivePath, format string
+	if uploaded {
+		archivePath, format, ok = receiveArchiveUpload(c, jail, dest)
+		if !ok {
+			return
+		}
+	} else {
+		archivePath, err = jail.resolve(request.Path, true)
+		if err != nil {
+			respondFileError(c, err, "Invalid path")
+			return
+		}
+		if err := jail.canRead(archivePath, false); err != nil {
+			respondFileError(c, err, "Archive not found")
+			return
+		}
+		format = archiveFormatOf(archivePath)
+		if format == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported archive format"})
+			return
+		}
+	}
+
+	job, err := startFileJob(jail.username, "extract", func(job *FileJob) (any, error) {
+		if uploaded {
+			defer os.Remove(archivePath)
+		}
+		x := &extractor{
+			job:       job,
+			jail:      jail,
+			dest:      dest,
+			overwrite: request.Overwrite,
+			result:    &ArchiveResult{Path: dest, Skipped: []string{}},
+		}
+		if err := x.run(archivePath, format); err != nil {
+			return nil, err
+		}
+		return x.result, nil
+	})
+	if err != nil {
+		if uploaded {
+			os.Remove(archivePath)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job.snapshot())
+}
+
+// receiveArchiveUpload stores the first file of a multipart request as a
+// hidden temporary file in dest and returns its path and format
+func receiveArchiveUpload(c *gin.Context, jail *fileJail, dest string) (string, string, bool) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart/form-data request"})
+		return "", "", false
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No archive in request"})
+			return "", "", false
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		format := archiveFormatOf(part.FileName())
+		if format == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported archive format"})
+			return "", "", false
+		}
+
+		tmp, err := os.CreateTemp(dest, archiveStagingPrefix)
+		if err != nil {
+			respondFileError(c, err, "Failed to store archive")
+			return "", "", false
+		}
+		n, err := io.Copy(tmp, io.LimitReader(part, jail.maxUploadBytes+1))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && n > jail.maxUploadBytes {
+			os.Remove(tmp.Name())
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive exceeds the upload size limit"})
+			return "", "", false
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to receive archive: " + err.Error()})
+			return "", "", false
+		}
+		return tmp.Name(), format, true
+	}
+}
+
+// extractor writes archive entries below dest. Every entry is mapped through
+// target, which refuses names and parent symlinks leading outside dest, and
+// files are created with O_EXCL|O_NOFOLLOW so an existing symlink is never
+// written through.
+type extractor struct {
+	job       *FileJob
+	jail      *fileJail
+	dest      string
+	overwrite bool
+	result    *ArchiveResult
+
+	spaceLeft  int64
+	countBytes bool // count written bytes as progress (zip); tar counts bytes read
+}
+
+func (x *extractor) run(archivePath, format string) error {
+	x.spaceLeft = math.MaxInt64
+	var stat syscall.Statfs_t
+	if syscall.Statfs(x.dest, &stat) == nil {
+		x.spaceLeft = int64(stat.Bavail) * int64(stat.Bsize)
+	}
+
+	if format == "zip" {
+		return x.extractZip(archivePath)
+	}
+	return x.extractTar(archivePath, format)
+}
+
+func (x *extractor) extractZip(archivePath string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	total := int64(0)
+	for _, f := range r.File {
+		if f.Mode().IsRegular() {
+			total += int64(f.UncompressedSize64)
+		}
+	}
+	if total > x.spaceLeft {
+		return errNoSpace
+	}
+	x.job.setTotals(total, len(r.File))
+	x.countBytes = true
+
+	for _, f := range r.File {
+		if err := x.job.ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			err = x.dir(f.Name, mode)
+		case mode&fs.ModeSymlink != 0:
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				var link []byte
+				link, err = io.ReadAll(io.LimitReader(rc, 4096))
+				rc.Close()
+				if err == nil {
+					err = x.symlink(f.Name, string(link))
+				}
+			}
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				// zip.Reader fails when an entry is larger than its header claims
+				err = x.file(f.Name, mode, f.Modified, rc, int64(f.UncompressedSize64))
+				rc.Close()
+			}
+		default:
+			err = errUnsupportedEntry
+		}
+		if err := x.entryDone(f.Name, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) extractTar(archivePath, format string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 展開後のサイズは事前に分からないため、アーカイブの読み込み量で進捗を示す
+	if info, err := file.Stat(); err == nil {
+		x.job.setTotals(info.Size(), 0)
+	}
+	var r io.Reader = io.TeeReader(file, progressWriter{x.job})
+
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.zst":
+		zr, err := newZstdReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := x.job.ctx.Err(); err != nil {
+			return err
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(header.Name, header.FileInfo().Mode())
+		case tar.TypeReg:
+			err = x.file(header.Name, header.FileInfo().Mode(), header.ModTime, tr, header.Size)
+		case tar.TypeSymlink:
+			err = x.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = x.hardlink(header.Name, header.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			err = errUnsupportedEntry
+		}
+		if err := x.entryDone(header.Name, err); err != nil {
+			return err
+		}
+	}
+}
+
+// entryDone records entries that were refused and returns errors that should
+// abort the whole extraction
+func (x *extractor) entryDone(name string, err error) error {
+	switch {
+	case err == nil:
+	case errors.Is(err, errUnsafeEntry), errors.Is(err, errUnsafeLink), isSkippableFileError(err):
+		x.result.Skipped = append(x.result.Skipped, name+": "+err.Error())
+	default:
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	x.job.advance(0, 1, name)
+	return nil
+}
+
+// target maps an entry name to a path below dest. Absolute names, ".."
+// components and parents that resolve outside dest, for example through a
+// symlink extracted earlier, are refused.
+func (x *extractor) target(name string) (string, error) {
+	if path.IsAbs(name) {
+		return "", errUnsafeEntry
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errUnsafeEntry
+		}
+	}
+
+	joined := filepath.Join(x.dest, filepath.FromSlash(path.Clean(name)))
+	if joined == x.dest {
+		return joined, nil
+	}
+	parent, err := evalSymlinksPartial(filepath.Dir(joined))
+	if err != nil {
+		return "", err
+	}
+	if !isWithinPath(x.dest, parent) {
+		return "", errUnsafeEntry
+	}
+
+	target := filepath.Join(parent, filepath.Base(joined))
+	if err := x.jail.check(target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// prepare creates the parent directories of target and clears the way for a
+// new entry. Existing entries are removed only when overwriting, and
+// directories are never replaced.
+func (x *extractor) prepare(target string) error {
+	if err := x.mkdirs(filepath.Dir(target)); err != nil {
+		return err
+	}
+	if err := x.jail.checkPermission(filepath.Dir(target), 2|1, target); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(target)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() || !x.overwrite {
+		return errEntryExists
+	}
+	// 既存のシンボリックリンクを辿らないよう、削除してから作り直す
+	return os.Remove(target)
+}
+
+// mkdirs creates the missing directories down to dir, which target has
+// already confirmed to be inside dest
+func (x *extractor) mkdirs(dir string) error {
+	if _, err := os.Lstat(dir); err == nil {
+		return nil
+	}
+	if err := x.mkdirs(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := x.jail.checkPermission(filepath.Dir(dir), 2|1, ""); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	x.jail.chownToAccount(dir)
+	return nil
+}
+
+func (x *extractor) dir(name string, mode fs.FileMode) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return nil
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+
+	// The owner keeps access so that the entries below can be extracted
+	if err := os.Mkdir(target, 0700); err != nil {
+		return err
+	}
+	os.Chmod(target, mode.Perm()|0700)
+	x.jail.chownToAccount(target)
+	x.result.Entries++
+	return nil
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, modTime time.Time, r io.Reader, size int64) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if size > x.spaceLeft {
+		return errNoSpace
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+
+	file, err := x.jail.openFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = file
+	if x.countBytes {
+		w = io.MultiWriter(file, progressWriter{x.job})
+	}
+	n, err := io.Copy(w, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	x.spaceLeft -= n
+
+	// setuid/setgid/stickyは引き継がない
+	os.Chmod(target, mode.Perm())
+	x.jail.chownToAccount(target)
+	if !modTime.IsZero() {
+		os.Chtimes(target, modTime, modTime)
+	}
+	x.result.Entries++
+	x.result.Bytes += n
+	return nil
+}
+
+func (x *extractor) symlink(name, link string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if !x.linkStaysInside(filepath.Dir(target), link) {
+		return errUnsafeLink
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	if err := os.Symlink(link, target); err != nil {
+		return err
+	}
+	x.jail.chownToAccount(target)
+	x.result.Entries++
+	return nil
+}
+
+// linkStaysInside follows link from dir the way the kernel would and reports
+// whether every step stays inside dest. ".." after a component that does not
+// exist yet is refused, because a later entry could make it a symlink.
+func (x *extractor) linkStaysInside(dir, link string) bool {
+	if link == "" || filepath.IsAbs(link) {
+		return false
+	}
+
+	current := dir
+	missing := false
+	for _, part := range strings.Split(link, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if missing {
+				return false
+			}
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+			if !missing {
+				if real, err := filepath.EvalSymlinks(current); err == nil {
+					current = real
+				} else {
+					missing = true
+				}
+			}
+		}
+		if !isWithinPath(x.dest, current) {
+			return false
+		}
+	}
+	return true
+}
+
+// hardlink links name to a file that is already inside dest
+func (x *extractor) hardlink(name, link string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	source, err := x.target(link)
+	if err != nil {
+		return errUnsafeLink
+	}
+	info, err := os.Lstat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		return errUnsupportedEntry
+	}
+	// rootで動いているため、ユーザーが読み書きできないファイルへのリンクは作らない
+	if err := x.jail.checkPermission(source, 4|2, ""); err != nil {
+		return err
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	if err := os.Link(source, target); err != nil {
+		return err
+	}
+	x.result.Entries++
+	return nil
+}
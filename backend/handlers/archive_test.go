@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestExtractor returns an extractor for a fresh destination containing
+// sub/, inner -> sub and escape -> /
+func newTestExtractor(t *testing.T) *extractor {
+	t.Helper()
+	dest, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dest, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dest, "inner")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(dest, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	return &extractor{jail: &fileJail{roots: []string{"/"}}, dest: dest}
+}
+
+func TestLinkStaysInside(t *testing.T) {
+	x := newTestExtractor(t)
+	sub := filepath.Join(x.dest, "sub")
+
+	tests := []struct {
+		dir  string
+		link string
+		want bool
+	}{
+		{sub, "file", true},
+		{sub, "./a/./b", true},
+		{sub, "../file", true},
+		{sub, "../inner/file", true},
+		{sub, "../sub/../sub/file", true},
+		{x.dest, "sub//file", true},
+		{sub, "", false},
+		{sub, "/etc/passwd", false},
+		{sub, "../../etc/passwd", false},
+		{x.dest, "..", false},
+		{x.dest, "../" + filepath.Base(x.dest) + "/file", false},
+		{sub, "../escape", false},
+		{sub, "../escape/etc/passwd", false},
+		// a later entry could turn "missing" into a symlink
+		{sub, "missing/../file", false},
+		{sub, "missing/../../file", false},
+	}
+	for _, tt := range tests {
+		rel, _ := filepath.Rel(x.dest, tt.dir)
+		if got := x.linkStaysInside(tt.dir, tt.link); got != tt.want {
+			t.Errorf("linkStaysInside(%q, %q) = %v, want %v", rel, tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestExtractorTarget(t *testing.T) {
+	x := newTestExtractor(t)
+
+	tests := []struct {
+		name    string
+		want    string // relative to dest
+		wantErr error
+	}{
+		{name: "file", want: "file"},
+		{name: "sub/file", want: "sub/file"},
+		{name: "./sub/./file", want: "sub/file"},
+		{name: "inner/file", want: "sub/file"},
+		{name: ".", want: "."},
+		{name: "/etc/passwd", wantErr: errUnsafeEntry},
+		{name: "../file", wantErr: errUnsafeEntry},
+		{name: "sub/../../file", wantErr: errUnsafeEntry},
+		{name: "escape/etc/passwd", wantErr: errUnsafeEntry},
+	}
+	for _, tt := range tests {
+		got, err := x.target(tt.name)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("target(%q) = %q, %v; want error %v", tt.name, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("target(%q) failed: %v", tt.name, err)
+			continue
+		}
+		if want := filepath.Join(x.dest, tt.want); got != want {
+			t.Errorf("target(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BootTime struct {
+	Phases           []BootPhase `json:"phases"`
+	TotalSec         float64     `json:"total_sec"`
+	Target           string      `json:"target"`
+	TargetReachedSec *float64    `json:"target_reached_sec"`
+	Raw              string      `json:"raw"`
+}
+
+type BootPhase struct {
+	Name    string  `json:"name"` // firmware, loader, kernel, initrd, userspace
+	Seconds float64 `json:"seconds"`
+}
+
+type BlameEntry struct {
+	Unit    string  `json:"unit"`
+	Seconds float64 `json:"seconds"`
+}
+
+type CriticalChainEntry struct {
+	Unit        string   `json:"unit"`
+	Depth       int      `json:"depth"`
+	ActivatedAt *float64 `json:"activated_at_sec"` // time after boot at which the unit became active
+	StartupSec  *float64 `json:"startup_sec"`      // time the unit took to start
+}
+
+type DependencyGraph struct {
+	Root      string           `json:"root"`
+	Nodes     []DependencyNode `json:"nodes"`
+	Edges     []DependencyEdge `json:"edges"`
+	Truncated bool             `json:"truncated"`
+}
+
+type DependencyNode struct {
+	Unit        string `json:"unit"`
+	Depth       int    `json:"depth"`
+	LoadState   string `json:"load_state"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state"`
+}
+
+type DependencyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"` // requires, wants, after, before
+}
+
+// dependencyProperties maps edge types to the systemd properties they come from
+var dependencyProperties = map[string]string{
+	"requires": "Requires",
+	"wants":    "Wants",
+	"after":    "After",
+	"before":   "Before",
+}
+
+// dependencyColors follow `systemd-analyze dot`, which has no color for Before=
+var dependencyColors = map[string]string{
+	"requires": "black",
+	"wants":    "grey66",
+	"after":    "green",
+	"before":   "blue",
+}
+
+const (
+	maxDependencyDepth = 3
+	maxDependencyNodes = 300
+)
+
+var (
+	// "Startup finished in 2.1s (firmware) + 1.5s (loader) + 3.4s (kernel) + 20.1s (userspace) = 27.1s"
+	bootPhasePattern = regexp.MustCompile(`^(.+) \((\w+)\)$`)
+	// "graphical.target reached after 19.8s in userspace."
+	bootTargetPattern = regexp.MustCompile(`^(\S+) reached after (.+) in userspace`)
+	// "docker.service @1min 2.3s +4.5s"
+	chainTimePattern = regexp.MustCompile(`^(\S+)(?: @([^+]+?))?(?: \+(.+))?$`)
+)
+
+// GetBootTime returns the time spent in each boot phase (`systemd-analyze time`)
+func GetBootTime(c *gin.Context) {
+	output, err := systemdAnalyze("time")
+	if err != nil {
+		// e.g. "Bootup is not yet finished"
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": strings.TrimSpace(output)})
+		return
+	}
+
+	c.JSON(http.StatusOK, parseBootTime(output))
+}
+
+// GetBootBlame returns units ordered by the time they took to start (`systemd-analyze blame`)
+func GetBootBlame(c *gin.Context) {
+	output, err := systemdAnalyze("blame")
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": strings.TrimSpace(output)})
+		return
+	}
+
+	entries := parseBootBlame(output)
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v < len(entries) {
+		entries = entries[:v]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"units": entries,
+		"total": len(entries),
+	})
+}
+
+// GetCriticalChain returns the chain of units that delayed ?unit= (default.target
+// when omitted) as reported by `systemd-analyze critical-chain`
+func GetCriticalChain(c *gin.Context) {
+	args := []string{"critical-chain"}
+	if unit := c.Query("unit"); unit != "" {
+		if !validUnitName(unit) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unit name"})
+			return
+		}
+		args = append(args, "--", unit)
+	}
+
+	output, err := systemdAnalyze(args...)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": strings.TrimSpace(output)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chain": parseCriticalChain(output),
+	})
+}
+
+// GetUnitDependencies returns the dependency graph around a unit. ?depth= controls
+// how far it is expanded, ?types= limits the edge types and ?format=dot returns
+// Graphviz DOT instead of JSON.
+func GetUnitDependencies(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	depth := 1
+	if v, err := strconv.Atoi(c.Query("depth")); err == nil && v > 0 {
+		depth = min(v, maxDependencyDepth)
+	}
+
+	types := []string{"requires", "wants", "after", "before"}
+	if t := c.Query("types"); t != "" {
+		types = nil
+		for _, name := range strings.Split(t, ",") {
+			if _, ok := dependencyProperties[name]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown dependency type: " + name})
+				return
+			}
+			types = append(types, name)
+		}
+	}
+
+	graph, err := buildDependencyGraph(unit, depth, types)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "dot" {
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(graph.dot()))
+		return
+	}
+	c.JSON(http.StatusOK, graph)
+}
+
+// buildDependencyGraph expands the dependencies of root breadth first, one
+// `systemctl show` call per level
+func buildDependencyGraph(root string, depth int, types []string) (DependencyGraph, error) {
+	graph := DependencyGraph{
+		Root:  root,
+		Nodes: []DependencyNode{},
+		Edges: []DependencyEdge{},
+	}
+
+	properties := []string{"Id", "Names", "LoadState", "ActiveState", "SubState"}
+	for _, t := range types {
+		properties = append(properties, dependencyProperties[t])
+	}
+
+	seen := map[string]bool{root: true}
+	// aliases maps every name of a listed unit to its Id, which names the node
+	aliases := map[string]string{}
+	level := []string{root}
+	for d := 0; len(level) > 0; d++ {
+		results, err := systemctlShow(level, properties)
+		if err != nil {
+			return graph, err
+		}
+
+		var next []string
+		for _, props := range results {
+			name := props["Id"]
+			aliases[name] = name
+			for _, alias := range strings.Fields(props["Names"]) {
+				aliases[alias] = name
+				seen[alias] = true
+			}
+			graph.Nodes = append(graph.Nodes, DependencyNode{
+				Unit:        name,
+				Depth:       d,
+				LoadState:   props["LoadState"],
+				ActiveState: props["ActiveState"],
+				SubState:    props["SubState"],
+			})
+
+			// Units at the last level are shown but not expanded
+			if d == depth {
+				continue
+			}
+			for _, t := range types {
+				for _, dep := range strings.Fields(props[dependencyProperties[t]]) {
+					if !seen[dep] {
+						if len(seen) >= maxDependencyNodes {
+							graph.Truncated = true
+							continue
+						}
+						seen[dep] = true
+						next = append(next, dep)
+					}
+					graph.Edges = append(graph.Edges, DependencyEdge{From: name, To: dep, Type: t})
+				}
+			}
+		}
+		level = next
+	}
+
+	// Edges may name a unit by an alias, or one systemctl did not report;
+	// every edge has to end at a node
+	edges := graph.Edges[:0]
+	for _, edge := range graph.Edges {
+		if to, ok := aliases[edge.To]; ok {
+			edge.To = to
+			edges = append(edges, edge)
+		}
+	}
+	graph.Edges = edges
+	if id, ok := aliases[root]; ok {
+		graph.Root = id
+	}
+
+	return graph, nil
+}
+
+// dot renders the graph in Graphviz DOT format
+func (g DependencyGraph) dot() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "digraph systemd {")
+	fmt.Fprintln(&b, "\trankdir=LR;")
+	fmt.Fprintln(&b, "\tnode [shape=box];")
+
+	for _, node := range g.Nodes {
+		attrs := []string{"label=" + strconv.Quote(node.Unit)}
+		if node.Unit == g.Root {
+			attrs = append(attrs, "style=bold")
+		}
+		if node.ActiveState == "failed" {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", strconv.Quote(node.Unit), strings.Join(attrs, ", "))
+	}
+
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s [color=%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), dependencyColors[edge.Type])
+	}
+
+	fmt.Fprintln(&b, "}")
+	return b.String()
+}
+
+func parseBootTime(output string) BootTime {
+	result := BootTime{
+		Phases: []BootPhase{},
+		Raw:    strings.TrimSpace(output),
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if rest, ok := strings.CutPrefix(line, "Startup finished in "); ok {
+			phases, total, _ := strings.Cut(rest, " = ")
+			for _, phase := range strings.Split(phases, " + ") {
+				m := bootPhasePattern.FindStringSubmatch(phase)
+				if m == nil {
+					continue
+				}
+				if d, ok := parseSystemdTimespan(m[1]); ok {
+					result.Phases = append(result.Phases, BootPhase{Name: m[2], Seconds: d.Seconds()})
+				}
+			}
+			if d, ok := parseSystemdTimespan(total); ok {
+				result.TotalSec = d.Seconds()
+			}
+			continue
+		}
+
+		if m := bootTargetPattern.FindStringSubmatch(line); m != nil {
+			result.Target = m[1]
+			if d, ok := parseSystemdTimespan(m[2]); ok {
+				secs := d.Seconds()
+				result.TargetReachedSec = &secs
+			}
+		}
+	}
+
+	return result
+}
+
+// parseBootBlame parses lines like "  1min 2.345s docker.service"
+func parseBootBlame(output string) []BlameEntry {
+	entries := []BlameEntry{}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		d, ok := parseSystemdTimespan(strings.Join(fields[:len(fields)-1], " "))
+		if !ok {
+			continue
+		}
+		entries = append(entries, BlameEntry{Unit: fields[len(fields)-1], Seconds: d.Seconds()})
+	}
+
+	return entries
+}
+
+// parseCriticalChain parses the tree printed by `systemd-analyze critical-chain`:
+//
+//	graphical.target @45.123s
+//	└─multi-user.target @45.120s
+//	  └─docker.service @30.1s +15.0s
+func parseCriticalChain(output string) []CriticalChainEntry {
+	chain := []CriticalChainEntry{}
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimLeft(line, " │├└─")
+		if trimmed == "" || !strings.Contains(trimmed, ".") || strings.HasPrefix(trimmed, "The time ") {
+			continue
+		}
+
+		m := chainTimePattern.FindStringSubmatch(trimmed)
+		if m == nil || !validUnitName(m[1]) {
+			continue
+		}
+
+		// Each level of the tree is indented by two columns
+		prefix := []rune(line[:len(line)-len(trimmed)])
+		entry := CriticalChainEntry{Unit: m[1], Depth: len(prefix) / 2}
+		if d, ok := parseSystemdTimespan(m[2]); ok {
+			secs := d.Seconds()
+			entry.ActivatedAt = &secs
+		}
+		if d, ok := parseSystemdTimespan(m[3]); ok {
+			secs := d.Seconds()
+			entry.StartupSec = &secs
+		}
+		chain = append(chain, entry)
+	}
+
+	return chain
+}
+
+// systemdAnalyze runs systemd-analyze without colors or pager
+func systemdAnalyze(args ...string) (string, error) {
+	cmd := exec.Command("systemd-analyze", append([]string{"--no-pager"}, args...)...)
+	cmd.Env = append(os.Environ(), "SYSTEMD_COLORS=0")
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
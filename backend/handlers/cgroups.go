@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PressureLine struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+type PressureStats struct {
+	Some *PressureLine `json:"some"`
+	Full *PressureLine `json:"full"`
+}
+
+type CgroupNode struct {
+	Path          string                   `json:"path"`
+	Name          string                   `json:"name"`
+	Type          string                   `json:"type"`
+	Container     string                   `json:"container,omitempty"`
+	CPUUsageUsec  uint64                   `json:"cpu_usage_usec"`
+	CPUUserUsec   uint64                   `json:"cpu_user_usec"`
+	CPUSystemUsec uint64                   `json:"cpu_system_usec"`
+	CPUPercent    float64                  `json:"cpu_percent"`
+	MemoryCurrent uint64                   `json:"memory_current"`
+	MemoryMax     *uint64                  `json:"memory_max"`
+	SwapCurrent   uint64                   `json:"swap_current"`
+	IOReadBytes   uint64                   `json:"io_read_bytes"`
+	IOWriteBytes  uint64                   `json:"io_write_bytes"`
+	Tasks         uint64                   `json:"tasks"`
+	Pressure      map[string]PressureStats `json:"pressure"`
+	Children      []CgroupNode             `json:"children"`
+}
+
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// cgroupCPUSampler tracks usage_usec per cgroup to derive CPU percentages
+var cgroupCPUSampler = newCounterSampler(readCgroupCPUUsage)
+
+// GetResourceBreakdown returns system-wide pressure stall information and the
+// cgroup v2 hierarchy with per-slice, service and container accounting
+func GetResourceBreakdown(c *gin.Context) {
+	root, err := cgroupV2Root()
+	if err != nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+
+	maxDepth := -1
+	if d := c.Query("depth"); d != "" {
+		if v, err := strconv.Atoi(d); err == nil {
+			maxDepth = v
+		}
+	}
+
+	prev, cur, elapsed := cgroupCPUSampler.sample()
+	tree := readCgroupNode(root, "/", 0, maxDepth)
+	fillCgroupCPUPercent(&tree, prev, cur, elapsed)
+
+	c.JSON(http.StatusOK, gin.H{
+		"pressure": getSystemPressure(),
+		"cgroups":  tree,
+	})
+}
+
+// cgroupV2Root returns the cgroup v2 mount, which is /sys/fs/cgroup on unified
+// systems and /sys/fs/cgroup/unified on hybrid ones
+func cgroupV2Root() (string, error) {
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		if fileExists(filepath.Join(root, "cgroup.procs")) && !fileExists(filepath.Join(root, "tasks")) {
+			return root, nil
+		}
+	}
+	return "", errors.New("cgroup v2 hierarchy not found")
+}
+
+func getSystemPressure() map[string]PressureStats {
+	pressure := make(map[string]PressureStats)
+	for _, resource := range pressureResources {
+		if stats, ok := readPressureFile(filepath.Join("/proc/pressure", resource)); ok {
+			pressure[resource] = stats
+		}
+	}
+	return pressure
+}
+
+// readPressureFile parses a PSI file such as /proc/pressure/io or <cgroup>/io.pressure
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressureFile(path string) (PressureStats, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PressureStats{}, false
+	}
+
+	var stats PressureStats
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+
+		var p PressureLine
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "avg10":
+				p.Avg10, _ = strconv.ParseFloat(kv[1], 64)
+			case "avg60":
+				p.Avg60, _ = strconv.ParseFloat(kv[1], 64)
+			case "avg300":
+				p.Avg300, _ = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				p.Total, _ = strconv.ParseUint(kv[1], 10, 64)
+			}
+		}
+
+		switch fields[0] {
+		case "some":
+			stats.Some = &p
+		case "full":
+			stats.Full = &p
+		}
+	}
+
+	return stats, true
+}
+
+func readCgroupNode(dir, path string, depth, maxDepth int) CgroupNode {
+	node := CgroupNode{
+		Path:     path,
+		Name:     filepath.Base(path),
+		Type:     cgroupType(path),
+		Pressure: make(map[string]PressureStats),
+		Children: []CgroupNode{},
+	}
+	if container := containerIDFromCgroup(path); container != "" {
+		node.Container = container
+	}
+
+	cpuStat := readFlatKeyed(filepath.Join(dir, "cpu.stat"))
+	node.CPUUsageUsec = cpuStat["usage_usec"]
+	node.CPUUserUsec = cpuStat["user_usec"]
+	node.CPUSystemUsec = cpuStat["system_usec"]
+
+	node.MemoryCurrent = readSysUint(filepath.Join(dir, "memory.current"))
+	node.SwapCurrent = readSysUint(filepath.Join(dir, "memory.swap.current"))
+	if memMax := readSysString(filepath.Join(dir, "memory.max")); memMax != "" && memMax != "max" {
+		if v, err := strconv.ParseUint(memMax, 10, 64); err == nil {
+			node.MemoryMax = &v
+		}
+	}
+
+	node.IOReadBytes, node.IOWriteBytes = readCgroupIOStat(filepath.Join(dir, "io.stat"))
+	node.Tasks = readSysUint(filepath.Join(dir, "pids.current"))
+
+	for _, resource := range pressureResources {
+		if stats, ok := readPressureFile(filepath.Join(dir, resource+".pressure")); ok {
+			node.Pressure[resource] = stats
+		}
+	}
+
+	if maxDepth >= 0 && depth >= maxDepth {
+		return node
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return node
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		child := readCgroupNode(filepath.Join(dir, entry.Name()), filepath.Join(path, entry.Name()), depth+1, maxDepth)
+		node.Children = append(node.Children, child)
+	}
+
+	return node
+}
+
+func cgroupType(path string) string {
+	switch {
+	case path == "/":
+		return "root"
+	case containerIDFromCgroup(path) != "":
+		return "container"
+	case strings.HasSuffix(path, ".slice"):
+		return "slice"
+	case strings.HasSuffix(path, ".service"):
+		return "service"
+	case strings.HasSuffix(path, ".scope"):
+		return "scope"
+	}
+	return "cgroup"
+}
+
+// readFlatKeyed parses cgroup files in "key value" per line format (cpu.stat, memory.stat)
+func readFlatKeyed(path string) map[string]uint64 {
+	values := make(map[string]uint64)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return values
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values
+}
+
+// readCgroupIOStat sums rbytes/wbytes over all devices in io.stat
+//
+//	8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func readCgroupIOStat(path string) (uint64, uint64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+
+	var read, write uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				read += v
+			case "wbytes":
+				write += v
+			}
+		}
+	}
+	return read, write
+}
+
+func readCgroupCPUUsage() map[string]uint64 {
+	usage := make(map[string]uint64)
+
+	root, err := cgroupV2Root()
+	if err != nil {
+		return usage
+	}
+
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		usage[filepath.Join("/", rel)] = readFlatKeyed(filepath.Join(path, "cpu.stat"))["usage_usec"]
+		return nil
+	})
+
+	return usage
+}
+
+func fillCgroupCPUPercent(node *CgroupNode, prev, cur map[string]uint64, elapsed float64) {
+	if p, ok := prev[node.Path]; ok {
+		// usage_usec is CPU time, so 100% means one full core
+		node.CPUPercent = counterRate(p, cur[node.Path], elapsed) / 1e6 * 100.0
+	}
+	for i := range node.Children {
+		fillCgroupCPUPercent(&node.Children[i], prev, cur, elapsed)
+	}
+}
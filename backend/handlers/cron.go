@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CronJob struct {
+	Source   string      `json:"source"` // system, cron.d or user
+	File     string      `json:"file"`
+	Line     int         `json:"line"`
+	User     string      `json:"user"`
+	Schedule string      `json:"schedule"`
+	Command  string      `json:"command"`
+	NextRuns []time.Time `json:"next_runs"`
+	Error    string      `json:"error,omitempty"`
+}
+
+const (
+	systemCrontab  = "/etc/crontab"
+	cronDDir       = "/etc/cron.d"
+	userCrontabDir = "/var/spool/cron/crontabs"
+)
+
+// cronSchedule is a parsed five-field cron expression. Each field is a bitmask
+// of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Vixie cron matches day-of-month OR day-of-week when both are restricted
+	domStar, dowStar bool
+	reboot           bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ListCronJobs returns the jobs in /etc/crontab, /etc/cron.d and all user crontabs
+// with their next run times
+func ListCronJobs(c *gin.Context) {
+	count := 5
+	if v, err := strconv.Atoi(c.Query("next")); err == nil && v >= 0 && v <= 100 {
+		count = v
+	}
+
+	jobs := []CronJob{}
+	jobs = append(jobs, readCrontab(systemCrontab, "system", "", count)...)
+
+	if entries, err := os.ReadDir(cronDDir); err == nil {
+		for _, entry := range entries {
+			// cron ignores files with dots in their name (package backups like foo.dpkg-old)
+			if entry.IsDir() || strings.Contains(entry.Name(), ".") {
+				continue
+			}
+			jobs = append(jobs, readCrontab(filepath.Join(cronDDir, entry.Name()), "cron.d", "", count)...)
+		}
+	}
+
+	if entries, err := os.ReadDir(userCrontabDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			jobs = append(jobs, readCrontab(filepath.Join(userCrontabDir, entry.Name()), "user", entry.Name(), count)...)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  jobs,
+		"total": len(jobs),
+	})
+}
+
+// readCrontab parses a crontab file. System crontabs have a user column;
+// user crontabs (username != "") do not.
+func readCrontab(path, source, username string, count int) []CronJob {
+	var jobs []CronJob
+
+	file, err := os.Open(path)
+	if err != nil {
+		return jobs
+	}
+	defer file.Close()
+
+	now := time.Now()
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || isCronEnvLine(line) {
+			continue
+		}
+
+		job := CronJob{
+			Source:   source,
+			File:     path,
+			Line:     lineNumber,
+			User:     username,
+			NextRuns: []time.Time{},
+		}
+
+		fields := strings.Fields(line)
+		scheduleFields := 5
+		if strings.HasPrefix(fields[0], "@") {
+			scheduleFields = 1
+		}
+		columns := scheduleFields + 1
+		if username == "" {
+			columns++
+		}
+		if len(fields) < columns {
+			job.Schedule = line
+			job.Error = "incomplete line"
+			jobs = append(jobs, job)
+			continue
+		}
+
+		job.Schedule = strings.Join(fields[:scheduleFields], " ")
+		if username == "" {
+			job.User = fields[scheduleFields]
+		}
+		job.Command = skipFields(line, columns-1)
+
+		schedule, err := parseCronSchedule(job.Schedule)
+		if err != nil {
+			job.Error = err.Error()
+		} else {
+			job.NextRuns = schedule.nextRuns(now, count)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
+
+// skipFields removes the first n whitespace separated fields from line,
+// keeping the spacing of the remainder
+func skipFields(line string, n int) string {
+	for i := 0; i < n; i++ {
+		line = strings.TrimLeft(line, " \t")
+		if idx := strings.IndexAny(line, " \t"); idx >= 0 {
+			line = line[idx:]
+		} else {
+			return ""
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// isCronEnvLine reports whether line is a variable assignment such as SHELL=/bin/sh
+func isCronEnvLine(line string) bool {
+	name, _, ok := strings.Cut(line, "=")
+	return ok && envKeyPattern.MatchString(strings.TrimSpace(name))
+}
+
+// parseCronSchedule parses "min hour dom month dow" or an @macro
+func parseCronSchedule(expression string) (cronSchedule, error) {
+	var s cronSchedule
+
+	if strings.HasPrefix(expression, "@") {
+		if expression == "@reboot" {
+			s.reboot = true
+			return s, nil
+		}
+		expanded, ok := cronMacros[expression]
+		if !ok {
+			return s, fmt.Errorf("unknown macro %s", expression)
+		}
+		expression = expanded
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return s, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return s, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return s, fmt.Errorf("hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return s, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return s, fmt.Errorf("month: %v", err)
+	}
+	// 0 and 7 are both Sunday
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return s, fmt.Errorf("day of week: %v", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (a-b) and steps (*/n, a-b/n)
+func parseCronField(field string, low, high int, names []string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			v, err := strconv.Atoi(stepPart)
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = v
+		}
+
+		start, end := low, high
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = cronValue(from, low, high, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = cronValue(to, low, high, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end of the range
+				end = high
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func cronValue(value string, low, high int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			// Month names start at 1, day names at 0
+			return i + low, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < low || v > high {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return v, nil
+}
+
+func (s cronSchedule) matchesDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// nextRuns returns up to count run times after from, in local time
+func (s cronSchedule) nextRuns(from time.Time, count int) []time.Time {
+	runs := []time.Time{}
+	if s.reboot {
+		return runs
+	}
+
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	// Impossible schedules like "0 0 31 2 *" never match, so give up after a few years
+	limit := from.AddDate(5, 0, 0)
+	for len(runs) < count && t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			runs = append(runs, t)
+			t = t.Add(time.Minute)
+		}
+	}
+
+	return runs
+}
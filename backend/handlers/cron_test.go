@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cronBits returns a field bitmask with the given values set
+func cronBits(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+// cronSpan returns a field bitmask with low..high set
+func cronSpan(low, high, step int) uint64 {
+	var bits uint64
+	for v := low; v <= high; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field     string
+		low, high int
+		names     []string
+		want      uint64
+	}{
+		{"*", 0, 59, nil, cronSpan(0, 59, 1)},
+		{"5", 0, 59, nil, cronBits(5)},
+		{"1,2,3", 0, 59, nil, cronBits(1, 2, 3)},
+		{"10-12", 0, 23, nil, cronBits(10, 11, 12)},
+		{"*/15", 0, 59, nil, cronBits(0, 15, 30, 45)},
+		{"5/20", 0, 59, nil, cronBits(5, 25, 45)},
+		{"1-10/3", 1, 31, nil, cronBits(1, 4, 7, 10)},
+		{"0-4,22-23", 0, 23, nil, cronBits(0, 1, 2, 3, 4, 22, 23)},
+		{"jan", 1, 12, cronMonthNames, cronBits(1)},
+		{"MAR-May", 1, 12, cronMonthNames, cronBits(3, 4, 5)},
+		{"mon-fri", 0, 7, cronDayNames, cronBits(1, 2, 3, 4, 5)},
+		{"sun,sat", 0, 7, cronDayNames, cronBits(0, 6)},
+		{"7", 0, 7, cronDayNames, cronBits(7)},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.low, tt.high, tt.names)
+		if err != nil {
+			t.Errorf("parseCronField(%q) failed: %v", tt.field, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       cronSchedule
+	}{
+		{"@reboot", cronSchedule{reboot: true}},
+		{"@hourly", cronSchedule{
+			minute: cronBits(0), hour: cronSpan(0, 23, 1), dom: cronSpan(1, 31, 1),
+			month: cronSpan(1, 12, 1), dow: cronSpan(0, 6, 1), domStar: true, dowStar: true,
+		}},
+		{"@yearly", cronSchedule{
+			minute: cronBits(0), hour: cronBits(0), dom: cronBits(1),
+			month: cronBits(1), dow: cronSpan(0, 6, 1), dowStar: true,
+		}},
+		{"*/30 9-17 * * mon-fri", cronSchedule{
+			minute: cronBits(0, 30), hour: cronSpan(9, 17, 1), dom: cronSpan(1, 31, 1),
+			month: cronSpan(1, 12, 1), dow: cronSpan(1, 5, 1), domStar: true,
+		}},
+		// 7 is another name for Sunday
+		{"0 0 1,15 * 7", cronSchedule{
+			minute: cronBits(0), hour: cronBits(0), dom: cronBits(1, 15),
+			month: cronSpan(1, 12, 1), dow: cronBits(0, 7),
+		}},
+		{"  0   12  */2 dec  *  ", cronSchedule{
+			minute: cronBits(0), hour: cronBits(12), dom: cronSpan(1, 31, 2),
+			month: cronBits(12), dow: cronSpan(0, 6, 1), domStar: true, dowStar: true,
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseCronSchedule(tt.expression)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) failed: %v", tt.expression, err)
+			continue
+		}
+		// bit 7 is Sunday again and already folded into bit 0
+		got.dow &^= 1 << 7
+		tt.want.dow &^= 1 << 7
+		if got != tt.want {
+			t.Errorf("parseCronSchedule(%q) = %+v, want %+v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"", "@often", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * 32 * *",
+		"* * * 0 *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "*/x * * * *", "5-1 * * * *",
+		"-1 * * * *", "a * * * *", "* * * foo *", "1,,2 * * * *", "* * * * mon-",
+	} {
+		if s, err := parseCronSchedule(expression); err == nil {
+			t.Errorf("parseCronSchedule(%q) = %+v, want an error", expression, s)
+		}
+	}
+}
+
+func TestCronNextRuns(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expression string
+		from       time.Time
+		count      int
+		want       []time.Time
+	}{
+		{"*/15 * * * *", at(2024, 1, 1, 10, 7), 3, []time.Time{
+			at(2024, 1, 1, 10, 15), at(2024, 1, 1, 10, 30), at(2024, 1, 1, 10, 45),
+		}},
+		// runs strictly after from, even when from is on a matching minute
+		{"*/15 * * * *", at(2024, 1, 1, 10, 15).Add(30 * time.Second), 1, []time.Time{
+			at(2024, 1, 1, 10, 30),
+		}},
+		{"59 23 31 12 *", at(2024, 12, 31, 23, 59), 1, []time.Time{
+			at(2025, 12, 31, 23, 59),
+		}},
+		{"@yearly", at(2024, 6, 1, 0, 0), 2, []time.Time{
+			at(2025, 1, 1, 0, 0), at(2026, 1, 1, 0, 0),
+		}},
+		{"0 0 29 2 *", at(2023, 3, 1, 0, 0), 2, []time.Time{
+			at(2024, 2, 29, 0, 0), at(2028, 2, 29, 0, 0),
+		}},
+		// 2024-09-07 is a Saturday
+		{"0 9 * * mon-fri", at(2024, 9, 7, 12, 0), 2, []time.Time{
+			at(2024, 9, 9, 9, 0), at(2024, 9, 10, 9, 0),
+		}},
+		{"30 8 * * 7", at(2024, 9, 1, 0, 0), 2, []time.Time{
+			at(2024, 9, 1, 8, 30), at(2024, 9, 8, 8, 30),
+		}},
+		// both day fields restricted: the 13th OR any Friday
+		{"0 12 13 * fri", at(2024, 9, 1, 0, 0), 4, []time.Time{
+			at(2024, 9, 6, 12, 0), at(2024, 9, 13, 12, 0), at(2024, 9, 20, 12, 0), at(2024, 9, 27, 12, 0),
+		}},
+		{"0 12 13 * fri", at(2024, 10, 1, 0, 0), 2, []time.Time{
+			at(2024, 10, 4, 12, 0), at(2024, 10, 11, 12, 0),
+		}},
+		{"0 12 13 * fri", at(2024, 10, 11, 13, 0), 2, []time.Time{
+			at(2024, 10, 13, 12, 0), at(2024, 10, 18, 12, 0),
+		}},
+		// day of week is a star, so only the 13th
+		{"0 12 13 * *", at(2024, 9, 1, 0, 0), 2, []time.Time{
+			at(2024, 9, 13, 12, 0), at(2024, 10, 13, 12, 0),
+		}},
+		{"0 0 31 2 *", at(2024, 1, 1, 0, 0), 3, []time.Time{}},
+		{"@reboot", at(2024, 1, 1, 0, 0), 3, []time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := parseCronSchedule(tt.expression)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) failed: %v", tt.expression, err)
+			continue
+		}
+		if got := s.nextRuns(tt.from, tt.count); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nextRuns(%q, %v, %d) = %v, want %v", tt.expression, tt.from, tt.count, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/unix"
+)
+
+// CustomServiceSpec describes a command to be run as a systemd service
+type CustomServiceSpec struct {
+	Name             string              `json:"name"`
+	Description      string              `json:"description"`
+	Command          string              `json:"command"`
+	Shell            bool                `json:"shell"`
+	WorkingDirectory string              `json:"working_directory"`
+	User             string              `json:"user"`
+	Scope            string              `json:"scope"` // system or user
+	Environment      map[string]string   `json:"environment"`
+	Restart          string              `json:"restart"`
+	RestartSec       int                 `json:"restart_sec"`
+	Limits           CustomServiceLimits `json:"limits"`
+	After            []string            `json:"after"`
+}
+
+type CustomServiceLimits struct {
+	MemoryMax string `json:"memory_max"` // e.g. 4G
+	CPUQuota  string `json:"cpu_quota"`  // e.g. 200%
+	TasksMax  int    `json:"tasks_max"`
+	NoFile    int    `json:"nofile"`
+	Nice      int    `json:"nice"`
+}
+
+type CustomService struct {
+	Spec  CustomServiceSpec `json:"spec"`
+	Unit  string            `json:"unit"`
+	Path  string            `json:"path"`
+	State *SystemdUnit      `json:"state"`
+}
+
+// webOSUnitMarker is written at the top of generated units; the spec line
+// after it allows the unit to be loaded back into the editor
+const (
+	webOSUnitMarker   = "# Managed by Ubuntu Web OS"
+	webOSUnitSpecLine = "# X-WebOS-Spec: "
+)
+
+var (
+	customServiceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,99}$`)
+	envKeyPattern            = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	memoryLimitPattern       = regexp.MustCompile(`^(\d+[KMGT]?|\d+%|infinity)$`)
+	cpuQuotaPattern          = regexp.MustCompile(`^\d+%$`)
+	restartPolicies          = map[string]bool{"no": true, "on-success": true, "on-failure": true, "on-abnormal": true, "on-abort": true, "always": true}
+)
+
+// ListCustomServices returns units generated by this application
+func ListCustomServices(c *gin.Context) {
+	services := []CustomService{}
+
+	scopes := []CustomServiceSpec{{Scope: "system"}}
+	for _, username := range passwdUsernames() {
+		scopes = append(scopes, CustomServiceSpec{Scope: "user", User: username})
+	}
+
+	for _, probe := range scopes {
+		dir, err := openCustomServiceDir(probe, false)
+		if err != nil {
+			continue
+		}
+		names, _ := dir.Readdirnames(-1)
+		sort.Strings(names)
+		for _, name := range names {
+			unit, ok := strings.CutSuffix(name, ".service")
+			if !ok {
+				continue
+			}
+			probe.Name = unit
+			if spec, ok := readCustomServiceSpecAt(dir, probe); ok {
+				services = append(services, customServiceInfo(spec))
+			}
+		}
+		dir.Close()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"services": services,
+		"total":    len(services),
+	})
+}
+
+// GetCustomService returns the spec of a generated unit for editing
+func GetCustomService(c *gin.Context) {
+	spec, ok := lookupCustomService(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, customServiceInfo(spec))
+}
+
+// CreateCustomService writes a new unit, then enables and starts it
+func CreateCustomService(c *gin.Context) {
+	var spec CustomServiceSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := normalizeCustomServiceSpec(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	path, err := customServicePath(spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if fileExists(path) || customServiceUnitExists(spec) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A unit with this name already exists"})
+		return
+	}
+
+	if err := writeCustomServiceUnit(spec, path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write unit: " + err.Error()})
+		return
+	}
+
+	output, err := customServiceSystemctl(spec, "enable", "--now")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Unit created but failed to start: " + err.Error(),
+			"output":  output,
+			"service": customServiceInfo(spec),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Service created and started",
+		"service": customServiceInfo(spec),
+	})
+}
+
+// UpdateCustomService rewrites a generated unit and restarts it
+func UpdateCustomService(c *gin.Context) {
+	existing, ok := lookupCustomService(c)
+	if !ok {
+		return
+	}
+
+	var spec CustomServiceSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 名前とスコープは変更不可（ファイルの場所が変わるため）
+	spec.Name = existing.Name
+	spec.Scope = existing.Scope
+	if spec.Scope == "user" {
+		spec.User = existing.User
+	}
+
+	if err := normalizeCustomServiceSpec(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	path, err := customServicePath(spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := writeCustomServiceUnit(spec, path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write unit: " + err.Error()})
+		return
+	}
+
+	output, err := customServiceSystemctl(spec, "restart")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Unit updated but failed to restart: " + err.Error(),
+			"output":  output,
+			"service": customServiceInfo(spec),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Service updated and restarted",
+		"service": customServiceInfo(spec),
+	})
+}
+
+// DeleteCustomService stops, disables and removes a generated unit
+func DeleteCustomService(c *gin.Context) {
+	spec, ok := lookupCustomService(c)
+	if !ok {
+		return
+	}
+
+	path, err := customServicePath(spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 停止に失敗しても削除は続行する
+	customServiceSystemctl(spec, "disable", "--now")
+
+	if err := removeCustomServiceUnit(spec); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove unit: " + err.Error()})
+		return
+	}
+
+	customServiceSystemctl(spec, "daemon-reload")
+	customServiceSystemctl(spec, "reset-failed")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Service removed",
+		"path":    path,
+	})
+}
+
+// lookupCustomService finds a generated unit by the :name parameter. System
+// units are looked up unless ?user= selects that user's units.
+func lookupCustomService(c *gin.Context) (CustomServiceSpec, bool) {
+	name := strings.TrimSuffix(c.Param("name"), ".service")
+	if !customServiceNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service name"})
+		return CustomServiceSpec{}, false
+	}
+
+	probe := CustomServiceSpec{Name: name, Scope: "system"}
+	if username := c.Query("user"); username != "" {
+		probe = CustomServiceSpec{Name: name, Scope: "user", User: username}
+	}
+
+	if _, err := customServicePath(probe); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return CustomServiceSpec{}, false
+	}
+
+	spec, ok := readCustomServiceSpec(probe)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found or not managed by Web OS"})
+		return CustomServiceSpec{}, false
+	}
+	return spec, true
+}
+
+func normalizeCustomServiceSpec(spec *CustomServiceSpec) error {
+	spec.Name = strings.TrimSuffix(spec.Name, ".service")
+	if !customServiceNamePattern.MatchString(spec.Name) {
+		return fmt.Errorf("invalid service name")
+	}
+	if strings.TrimSpace(spec.Command) == "" || strings.ContainsAny(spec.Command, "\r\n") {
+		return fmt.Errorf("command is required and must be a single line")
+	}
+	// 改行があると任意のディレクティブを差し込めてしまう
+	if strings.ContainsAny(spec.Description, "\r\n") {
+		return fmt.Errorf("description must be a single line")
+	}
+	if strings.ContainsAny(spec.WorkingDirectory, "\r\n") {
+		return fmt.Errorf("working directory must be a single line")
+	}
+	if spec.Scope == "" {
+		spec.Scope = "system"
+	}
+	if spec.Scope != "system" && spec.Scope != "user" {
+		return fmt.Errorf("scope must be system or user")
+	}
+	if spec.Scope == "user" && spec.User == "" {
+		return fmt.Errorf("user is required for user units")
+	}
+	if spec.User != "" {
+		if _, err := user.Lookup(spec.User); err != nil {
+			return fmt.Errorf("unknown user: %s", spec.User)
+		}
+	}
+	if spec.WorkingDirectory != "" && !filepath.IsAbs(spec.WorkingDirectory) {
+		return fmt.Errorf("working directory must be an absolute path")
+	}
+	if spec.Restart == "" {
+		spec.Restart = "on-failure"
+	}
+	if !restartPolicies[spec.Restart] {
+		return fmt.Errorf("invalid restart policy: %s", spec.Restart)
+	}
+	if spec.RestartSec < 0 {
+		return fmt.Errorf("restart_sec must not be negative")
+	}
+	for key, value := range spec.Environment {
+		if !envKeyPattern.MatchString(key) || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid environment variable: %s", key)
+		}
+	}
+	if spec.Limits.MemoryMax != "" && !memoryLimitPattern.MatchString(spec.Limits.MemoryMax) {
+		return fmt.Errorf("invalid memory limit: %s", spec.Limits.MemoryMax)
+	}
+	if spec.Limits.CPUQuota != "" && !cpuQuotaPattern.MatchString(spec.Limits.CPUQuota) {
+		return fmt.Errorf("invalid CPU quota: %s", spec.Limits.CPUQuota)
+	}
+	if spec.Limits.Nice < -20 || spec.Limits.Nice > 19 {
+		return fmt.Errorf("nice must be between -20 and 19")
+	}
+	for _, after := range spec.After {
+		if !validUnitName(after) {
+			return fmt.Errorf("invalid unit name in after: %s", after)
+		}
+	}
+	return nil
+}
+
+// customServiceUnitExists reports whether the manager of spec's scope already
+// has a unit of that name, including vendor units a new one would override
+func customServiceUnitExists(spec CustomServiceSpec) bool {
+	unit := spec.Name + ".service"
+	if spec.Scope != "user" {
+		return unitExists([]string{"--no-ask-password"}, unit, systemUnitDirs)
+	}
+	return unitExists(userSystemctlArgs(spec.User), unit, userUnitDirs)
+}
+
+// customServicePath returns where the unit file for spec lives
+func customServicePath(spec CustomServiceSpec) (string, error) {
+	unit := spec.Name + ".service"
+	if spec.Scope != "user" {
+		return filepath.Join(systemdAdminDir, unit), nil
+	}
+
+	u, err := user.Lookup(spec.User)
+	if err != nil {
+		return "", fmt.Errorf("unknown user: %s", spec.User)
+	}
+	return filepath.Join(u.HomeDir, ".config", "systemd", "user", unit), nil
+}
+
+// renderCustomServiceUnit generates the unit file content for spec
+func renderCustomServiceUnit(spec CustomServiceSpec) (string, error) {
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, webOSUnitMarker)
+	fmt.Fprintln(&b, webOSUnitSpecLine+string(specJSON))
+	fmt.Fprintln(&b, "")
+
+	fmt.Fprintln(&b, "[Unit]")
+	description := spec.Description
+	if description == "" {
+		description = spec.Name
+	}
+	fmt.Fprintf(&b, "Description=%s\n", systemdEscapeSpecifiers(description))
+	after := append([]string{"network.target"}, spec.After...)
+	fmt.Fprintf(&b, "After=%s\n", strings.Join(after, " "))
+	fmt.Fprintln(&b, "X-WebOS-Managed=yes")
+	fmt.Fprintln(&b, "")
+
+	fmt.Fprintln(&b, "[Service]")
+	fmt.Fprintln(&b, "Type=simple")
+	if spec.Shell {
+		fmt.Fprintf(&b, "ExecStart=/bin/bash -c %s\n", systemdExecQuote(spec.Command))
+	} else {
+		fmt.Fprintf(&b, "ExecStart=%s\n", systemdEscapeSpecifiers(spec.Command))
+	}
+	if spec.WorkingDirectory != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdEscapeSpecifiers(spec.WorkingDirectory))
+	}
+	// User= is implicit for user units
+	if spec.Scope == "system" && spec.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", spec.User)
+	}
+	for _, key := range slices.Sorted(maps.Keys(spec.Environment)) {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(key+"="+spec.Environment[key]))
+	}
+	fmt.Fprintf(&b, "Restart=%s\n", spec.Restart)
+	if spec.RestartSec > 0 {
+		fmt.Fprintf(&b, "RestartSec=%d\n", spec.RestartSec)
+	}
+	if spec.Limits.MemoryMax != "" {
+		fmt.Fprintf(&b, "MemoryMax=%s\n", spec.Limits.MemoryMax)
+	}
+	if spec.Limits.CPUQuota != "" {
+		fmt.Fprintf(&b, "CPUQuota=%s\n", spec.Limits.CPUQuota)
+	}
+	if spec.Limits.TasksMax > 0 {
+		fmt.Fprintf(&b, "TasksMax=%d\n", spec.Limits.TasksMax)
+	}
+	if spec.Limits.NoFile > 0 {
+		fmt.Fprintf(&b, "LimitNOFILE=%d\n", spec.Limits.NoFile)
+	}
+	if spec.Limits.Nice != 0 {
+		fmt.Fprintf(&b, "Nice=%d\n", spec.Limits.Nice)
+	}
+	fmt.Fprintln(&b, "")
+
+	fmt.Fprintln(&b, "[Install]")
+	if spec.Scope == "user" {
+		fmt.Fprintln(&b, "WantedBy=default.target")
+	} else {
+		fmt.Fprintln(&b, "WantedBy=multi-user.target")
+	}
+
+	return b.String(), nil
+}
+
+// openCustomServiceDir opens the directory that holds spec's unit. User units
+// live below the user's home directory, which the user controls: the path is
+// opened without following symlinks and created as the user, so a symlinked
+// ~/.config cannot make the server write or remove units elsewhere or chown
+// directories it finds there.
+func openCustomServiceDir(spec CustomServiceSpec, create bool) (*os.File, error) {
+	if spec.Scope != "user" {
+		return openDirNoFollow(systemdAdminDir)
+	}
+
+	u, err := user.Lookup(spec.User)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user: %s", spec.User)
+	}
+	home, err := filepath.EvalSymlinks(u.HomeDir)
+	if err != nil {
+		return nil, err
+	}
+	base, err := openDirNoFollow(home)
+	if err != nil {
+		return nil, err
+	}
+	defer base.Close()
+
+	rel := filepath.Join(".config", "systemd", "user")
+	var dir *os.File
+	if create {
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		dir, err = mkdirAllAt(base, rel, 0755, uid, gid)
+	} else {
+		dir, err = openDirAt(base, rel, false, 0, -1, -1)
+	}
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, fmt.Errorf("%s must not contain symlinks", filepath.Join(u.HomeDir, rel))
+	}
+	return dir, err
+}
+
+func writeCustomServiceUnit(spec CustomServiceSpec, path string) error {
+	content, err := renderCustomServiceUnit(spec)
+	if err != nil {
+		return err
+	}
+
+	dir, err := openCustomServiceDir(spec, true)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	// User units must be owned by the user for systemd --user to accept them
+	uid, gid := -1, -1
+	if spec.Scope == "user" {
+		var st syscall.Stat_t
+		if err := syscall.Fstat(int(dir.Fd()), &st); err != nil {
+			return err
+		}
+		uid, gid = int(st.Uid), int(st.Gid)
+	}
+	if err := writeFileAt(dir, filepath.Base(path), []byte(content), 0644, uid, gid); err != nil {
+		return err
+	}
+
+	_, err = customServiceSystemctl(spec, "daemon-reload")
+	return err
+}
+
+// removeCustomServiceUnit deletes spec's unit file if it is still there
+func removeCustomServiceUnit(spec CustomServiceSpec) error {
+	dir, err := openCustomServiceDir(spec, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := unix.Unlinkat(int(dir.Fd()), spec.Name+".service", 0); err != nil && !errors.Is(err, unix.ENOENT) {
+		return &os.PathError{Op: "remove", Path: filepath.Join(dir.Name(), spec.Name+".service"), Err: err}
+	}
+	return nil
+}
+
+// readCustomServiceSpec reads the spec embedded in the generated unit named
+// by probe's name and scope
+func readCustomServiceSpec(probe CustomServiceSpec) (CustomServiceSpec, bool) {
+	dir, err := openCustomServiceDir(probe, false)
+	if err != nil {
+		return CustomServiceSpec{}, false
+	}
+	defer dir.Close()
+	return readCustomServiceSpecAt(dir, probe)
+}
+
+// readCustomServiceSpecAt reads probe's unit below dir. The name, scope and
+// owner come from where the unit was found, not from its content, which the
+// owner of a user unit can edit.
+func readCustomServiceSpecAt(dir *os.File, probe CustomServiceSpec) (CustomServiceSpec, bool) {
+	file, err := openAt(dir, probe.Name+".service")
+	if err != nil {
+		return CustomServiceSpec{}, false
+	}
+	defer file.Close()
+
+	var spec CustomServiceSpec
+	if !decodeWebOSUnitSpec(file, webOSUnitSpecLine, &spec) {
+		return CustomServiceSpec{}, false
+	}
+	spec.Name, spec.Scope = probe.Name, probe.Scope
+	if probe.Scope == "user" {
+		spec.User = probe.User
+	}
+	return spec, true
+}
+
+// passwdUsernames returns the accounts in /etc/passwd that have a home
+// directory, for finding their user units wherever the home directory is
+func passwdUsernames() []string {
+	data, err := os.ReadFile("/etc/passwd")
+	if err != nil {
+		return nil
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 || fields[5] == "" || fields[5] == "/" || seen[fields[5]] {
+			continue
+		}
+		seen[fields[5]] = true
+		names = append(names, fields[0])
+	}
+	return names
+}
+
+// readWebOSUnitSpec decodes the JSON after specPrefix on the second line of a
+// generated unit file into v
+func readWebOSUnitSpec(path, specPrefix string, v any) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return decodeWebOSUnitSpec(file, specPrefix, v)
+}
+
+func decodeWebOSUnitSpec(r io.Reader, specPrefix string, v any) bool {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() || scanner.Text() != webOSUnitMarker {
+		return false
+	}
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), specPrefix) {
+		return false
+	}
+	return json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), specPrefix)), v) == nil
+}
+
+// isWebOSManagedUnit reports whether a unit file was generated by this application
+func isWebOSManagedUnit(path string) bool {
+	if path == "" {
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	return scanner.Scan() && scanner.Text() == webOSUnitMarker
+}
+
+func customServiceInfo(spec CustomServiceSpec) CustomService {
+	path, _ := customServicePath(spec)
+	info := CustomService{
+		Spec: spec,
+		Unit: spec.Name + ".service",
+		Path: path,
+	}
+
+	if spec.Scope == "system" {
+		if state, err := showUnit(info.Unit); err == nil {
+			info.State = &state
+		}
+	} else {
+		output, err := exec.Command("systemctl", append(userSystemctlArgs(spec.User), "show", "--timestamp=unix",
+			"-p", strings.Join(systemdUnitProperties, ","), "--", info.Unit)...).Output()
+		if props := parseSystemctlShow(output); err == nil && len(props) > 0 {
+			state := unitFromProperties(props[0])
+			info.State = &state
+		}
+	}
+
+	return info
+}
+
+// customServiceSystemctl runs systemctl against the system or the user's manager
+func customServiceSystemctl(spec CustomServiceSpec, args ...string) (string, error) {
+	var cmdArgs []string
+	if spec.Scope == "user" {
+		cmdArgs = userSystemctlArgs(spec.User)
+	} else {
+		cmdArgs = []string{"--no-ask-password"}
+	}
+	cmdArgs = append(cmdArgs, args...)
+	if args[0] != "daemon-reload" {
+		cmdArgs = append(cmdArgs, "--", spec.Name+".service")
+	}
+
+	output, err := exec.Command("systemctl", cmdArgs...).CombinedOutput()
+	return string(output), err
+}
+
+// userSystemctlArgs targets the per-user service manager of username
+func userSystemctlArgs(username string) []string {
+	return []string{"--user", "--machine=" + username + "@.host"}
+}
+
+// systemdQuote quotes a value as a single word for systemd unit files
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + systemdEscapeSpecifiers(s) + `"`
+}
+
+// systemdExecQuote quotes an argument of ExecStart=, where systemd also
+// expands $VAR; Environment= does not, so it uses systemdQuote
+func systemdExecQuote(s string) string {
+	return systemdQuote(strings.ReplaceAll(s, "$", "$$"))
+}
+
+// systemdEscapeSpecifiers escapes % so that systemd does not expand specifiers like %h
+func systemdEscapeSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
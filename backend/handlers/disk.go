@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bufio"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type DiskIOStats struct {
+	Device         string  `json:"device"`
+	ReadBytes      uint64  `json:"read_bytes"`
+	WriteBytes     uint64  `json:"write_bytes"`
+	ReadBytesSec   float64 `json:"read_bytes_sec"`
+	WriteBytesSec  float64 `json:"write_bytes_sec"`
+	ReadIOPS       float64 `json:"read_iops"`
+	WriteIOPS      float64 `json:"write_iops"`
+	ReadLatencyMs  float64 `json:"read_latency_ms"`
+	WriteLatencyMs float64 `json:"write_latency_ms"`
+	Utilization    float64 `json:"utilization"`
+	InFlight       uint64  `json:"in_flight"`
+}
+
+type mountEntry struct {
+	Device     string
+	Mountpoint string
+	FSType     string
+	Options    string
+}
+
+// diskCounters holds the cumulative counters of one line of /proc/diskstats
+type diskCounters struct {
+	Reads        uint64
+	ReadSectors  uint64
+	ReadTicks    uint64
+	Writes       uint64
+	WriteSectors uint64
+	WriteTicks   uint64
+	InFlight     uint64
+	IOTicks      uint64
+}
+
+// /proc/diskstats always counts in 512-byte sectors regardless of the device
+const diskSectorSize = 512
+
+var diskIOSampler = newCounterSampler(readDiskCounters)
+
+func getDiskStats() []DiskStats {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return []DiskStats{}
+	}
+
+	diskStats := []DiskStats{}
+	seen := make(map[string]int)
+
+	for _, m := range mounts {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m.Mountpoint, &st); err != nil {
+			continue
+		}
+
+		// Pseudo filesystems (proc, sysfs, cgroup, ...) report no blocks
+		if st.Blocks == 0 {
+			continue
+		}
+
+		bsize := uint64(st.Bsize)
+		total := st.Blocks * bsize
+		free := st.Bfree * bsize
+		available := st.Bavail * bsize
+		used := total - free
+
+		// Same calculation as df: used / (used + available for unprivileged users)
+		usage := 0.0
+		if used+available > 0 {
+			usage = float64(used) / float64(used+available) * 100.0
+		}
+
+		inodeUsage := 0.0
+		inodesUsed := st.Files - st.Ffree
+		if st.Files > 0 {
+			inodeUsage = float64(inodesUsed) / float64(st.Files) * 100.0
+		}
+
+		stat := DiskStats{
+			Device:      m.Device,
+			Mountpoint:  m.Mountpoint,
+			Filesystem:  m.FSType,
+			Total:       total,
+			Used:        used,
+			Available:   available,
+			Usage:       usage,
+			InodesTotal: st.Files,
+			InodesUsed:  inodesUsed,
+			InodesFree:  st.Ffree,
+			InodeUsage:  inodeUsage,
+			ReadOnly:    hasMountOption(m.Options, "ro"),
+		}
+
+		// A later mount on the same mountpoint hides the earlier one
+		if idx, ok := seen[m.Mountpoint]; ok {
+			diskStats[idx] = stat
+			continue
+		}
+		seen[m.Mountpoint] = len(diskStats)
+		diskStats = append(diskStats, stat)
+	}
+
+	return diskStats
+}
+
+// readMountInfo parses /proc/self/mountinfo
+func readMountInfo() ([]mountEntry, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 6 || len(fields) < sep+3 {
+			continue
+		}
+
+		mounts = append(mounts, mountEntry{
+			Device:     unescapeMountField(fields[sep+2]),
+			Mountpoint: unescapeMountField(fields[4]),
+			FSType:     fields[sep+1],
+			Options:    fields[5],
+		})
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes (\040 etc.) the kernel uses
+// for whitespace and backslashes in mountinfo
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func hasMountOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func getDiskIOStats() []DiskIOStats {
+	prev, cur, elapsed := diskIOSampler.sample()
+
+	stats := []DiskIOStats{}
+	for _, name := range slices.Sorted(maps.Keys(cur)) {
+		c := cur[name]
+		p, ok := prev[name]
+		if !ok {
+			p = c
+		}
+
+		stat := DiskIOStats{
+			Device:        name,
+			ReadBytes:     c.ReadSectors * diskSectorSize,
+			WriteBytes:    c.WriteSectors * diskSectorSize,
+			ReadBytesSec:  counterRate(p.ReadSectors, c.ReadSectors, elapsed) * diskSectorSize,
+			WriteBytesSec: counterRate(p.WriteSectors, c.WriteSectors, elapsed) * diskSectorSize,
+			ReadIOPS:      counterRate(p.Reads, c.Reads, elapsed),
+			WriteIOPS:     counterRate(p.Writes, c.Writes, elapsed),
+			InFlight:      c.InFlight,
+		}
+
+		// Average time per completed request during the interval
+		if c.Reads > p.Reads {
+			stat.ReadLatencyMs = float64(c.ReadTicks-p.ReadTicks) / float64(c.Reads-p.Reads)
+		}
+		if c.Writes > p.Writes {
+			stat.WriteLatencyMs = float64(c.WriteTicks-p.WriteTicks) / float64(c.Writes-p.Writes)
+		}
+
+		// io_ticks is the number of milliseconds the device had I/O in flight
+		if elapsed > 0 && c.IOTicks >= p.IOTicks {
+			stat.Utilization = float64(c.IOTicks-p.IOTicks) / (elapsed * 1000) * 100.0
+			if stat.Utilization > 100 {
+				stat.Utilization = 100
+			}
+		}
+
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+// readDiskCounters parses /proc/diskstats, skipping devices that never did any I/O
+func readDiskCounters() map[string]diskCounters {
+	counters := make(map[string]diskCounters)
+
+	data, err := os.ReadFile("/proc/diskstats")
+	if err != nil {
+		return counters
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 14 {
+			continue
+		}
+
+		values := make([]uint64, 11)
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i+3], 10, 64)
+		}
+
+		c := diskCounters{
+			Reads:        values[0],
+			ReadSectors:  values[2],
+			ReadTicks:    values[3],
+			Writes:       values[4],
+			WriteSectors: values[6],
+			WriteTicks:   values[7],
+			InFlight:     values[8],
+			IOTicks:      values[9],
+		}
+		if c.Reads == 0 && c.Writes == 0 {
+			continue
+		}
+
+		counters[fields[2]] = c
+	}
+
+	return counters
+}
@@ -0,0 +1,541 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TransferResult struct {
+	Items   []TransferItem `json:"items"`
+	Entries int            `json:"entries"`
+	Bytes   int64          `json:"bytes"`
+	Skipped []string       `json:"skipped"` // "path: reason"
+}
+
+type TransferItem struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Status      string `json:"status"` // copied, moved, skipped
+}
+
+// Conflict policies for entries that already exist at the destination.
+// Directories are always merged; "rename" picks a free name for the selected
+// items themselves, so nothing below them can conflict.
+var conflictPolicies = map[string]bool{
+	"skip":      true,
+	"overwrite": true,
+	"rename":    true,
+}
+
+// CopyFiles copies {"sources"} into the directory {"destination"} as a file job
+func CopyFiles(c *gin.Context) {
+	startTransfer(c, false)
+}
+
+// MoveFiles moves {"sources"} into the directory {"destination"} as a file job.
+// Moves within a filesystem are renames; across filesystems the tree is copied
+// and the source removed afterwards.
+func MoveFiles(c *gin.Context) {
+	startTransfer(c, true)
+}
+
+func startTransfer(c *gin.Context, move bool) {
+	var request struct {
+		Sources     []string `json:"sources"`
+		Destination string   `json:"destination"`
+		Conflict    string   `json:"conflict"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	if request.Conflict == "" {
+		request.Conflict = "skip"
+	}
+	if !conflictPolicies[request.Conflict] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown conflict policy: " + request.Conflict})
+		return
+	}
+	if len(request.Sources) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No paths selected"})
+		return
+	}
+
+	dest, err := jail.resolve(request.Destination, true)
+	if err != nil {
+		respondFileError(c, err, "Invalid destination")
+		return
+	}
+	if info, err := os.Stat(dest); err != nil || !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Destination is not a directory"})
+		return
+	}
+	if err := jail.checkPermission(dest, 2|1, ""); err != nil {
+		respondFileError(c, err, "Failed to write to destination")
+		return
+	}
+
+	sources := make([]string, 0, len(request.Sources))
+	for _, p := range request.Sources {
+		source, err := jail.resolve(p, false)
+		if err != nil {
+			respondFileError(c, err, "Invalid path")
+			return
+		}
+		info, err := os.Lstat(source)
+		if err != nil {
+			respondFileError(c, err, "File not found: "+p)
+			return
+		}
+		// 移動元は削除と同じ扱い（保護パスは移動できない）
+		if move {
+			err = jail.canDelete(source)
+		} else {
+			err = jail.canRead(source, info.IsDir())
+		}
+		if err != nil {
+			respondFileError(c, err, "Cannot access "+p)
+			return
+		}
+		if info.IsDir() && isWithinPath(source, dest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot copy a directory into itself: " + p})
+			return
+		}
+		sources = append(sources, source)
+	}
+
+	jobType := "copy"
+	if move {
+		jobType = "move"
+	}
+	job, err := startFileJob(jail.username, jobType, func(job *FileJob) (any, error) {
+		t := &transfer{
+			job:      job,
+			jail:     jail,
+			dest:     dest,
+			move:     move,
+			conflict: request.Conflict,
+			result:   &TransferResult{Items: []TransferItem{}, Skipped: []string{}},
+		}
+		if err := t.run(sources); err != nil {
+			return nil, err
+		}
+		return t.result, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job.snapshot())
+}
+
+// RenameFile renames {"path"} to {"name"} within the same directory
+func RenameFile(c *gin.Context) {
+	var request struct {
+		Path string `json:"path"`
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if request.Name == "" || strings.Contains(request.Name, "/") || request.Name == "." || request.Name == ".." {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	source, err := jail.resolve(request.Path, false)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	if _, err := os.Lstat(source); err != nil {
+		respondFileError(c, err, "File not found")
+		return
+	}
+	if err := jail.canDelete(source); err != nil {
+		respondFileError(c, err, "Failed to rename")
+		return
+	}
+
+	target := filepath.Join(filepath.Dir(source), request.Name)
+	if err := jail.check(target); err != nil {
+		respondFileError(c, err, "Invalid name")
+		return
+	}
+	if _, err := os.Lstat(target); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A file with that name already exists"})
+		return
+	}
+
+	if err := os.Rename(source, target); err != nil {
+		respondFileError(c, err, "Failed to rename")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Successfully renamed",
+		"path":    target,
+	})
+}
+
+// transfer copies or moves the selected paths into dest. Entries are created
+// with O_EXCL|O_NOFOLLOW after clearing the way, so a symlink at the
+// destination is replaced rather than written through.
+type transfer struct {
+	job      *FileJob
+	jail     *fileJail
+	dest     string
+	move     bool
+	conflict string
+	result   *TransferResult
+}
+
+func (t *transfer) run(sources []string) error {
+	var destStat syscall.Stat_t
+	if err := syscall.Stat(t.dest, &destStat); err != nil {
+		return err
+	}
+
+	sizes := make([]int64, len(sources))
+	counts := make([]int, len(sources))
+	totalBytes, totalEntries, needed := int64(0), 0, int64(0)
+	for i, source := range sources {
+		sizes[i], counts[i] = measureTree(t.job.ctx, t.jail, []string{source})
+		totalBytes += sizes[i]
+		totalEntries += counts[i]
+
+		// 同じファイルシステム内の移動は容量を消費しない
+		var st syscall.Stat_t
+		if !t.move || syscall.Lstat(source, &st) != nil || st.Dev != destStat.Dev {
+			needed += sizes[i]
+		}
+	}
+	t.job.setTotals(totalBytes, totalEntries)
+
+	var stat syscall.Statfs_t
+	if syscall.Statfs(t.dest, &stat) == nil && int64(stat.Bavail)*int64(stat.Bsize) < needed {
+		return errNoSpace
+	}
+
+	for i, source := range sources {
+		if err := t.job.ctx.Err(); err != nil {
+			return err
+		}
+		if err := t.transferOne(source, sizes[i], counts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferOne handles one selected path and its conflict at the destination
+func (t *transfer) transferOne(source string, size int64, count int) error {
+	sourceInfo, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(t.dest, filepath.Base(source))
+	item := TransferItem{Source: source, Destination: target, Status: "skipped"}
+	skip := func(err error) error {
+		t.skip(source, err)
+		t.job.advance(size, count, source)
+		t.result.Items = append(t.result.Items, item)
+		return nil
+	}
+
+	targetInfo, err := os.Lstat(target)
+	exists := err == nil
+	if exists && t.conflict == "rename" && !(t.move && target == source) {
+		target = availableName(target, sourceInfo.IsDir())
+		item.Destination = target
+		exists = false
+	}
+	if target == source {
+		return skip(errors.New("source and destination are the same"))
+	}
+	if err := t.jail.check(target); err != nil {
+		return skip(err)
+	}
+	merge := exists && targetInfo.IsDir() && sourceInfo.IsDir()
+	if exists && !merge && (t.conflict != "overwrite" || targetInfo.IsDir()) {
+		return skip(errEntryExists)
+	}
+	if exists && !merge {
+		if err := t.jail.canDelete(target); err != nil {
+			return skip(err)
+		}
+	}
+
+	if t.move && !merge {
+		if err := t.jail.checkPermission(t.dest, 2|1, target); err != nil {
+			return skip(err)
+		}
+		// ディレクトリでファイルを置き換える場合はrenameできないので先に削除する
+		if exists && sourceInfo.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		err := os.Rename(source, target)
+		if err == nil {
+			t.job.advance(size, count, source)
+			t.result.Entries += count
+			t.result.Bytes += size
+			item.Status = "moved"
+			t.result.Items = append(t.result.Items, item)
+			return nil
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+
+	skipped := len(t.result.Skipped)
+	if err := t.copyTree(source, target); err != nil {
+		return err
+	}
+	item.Status = "copied"
+
+	if t.move {
+		// 一部でもスキップした場合は移動元を残す
+		if len(t.result.Skipped) > skipped {
+			t.skip(source, errors.New("source kept because some entries were not copied"))
+		} else if err := t.jail.removeAll(source); err != nil {
+			if !isSkippableFileError(err) {
+				return err
+			}
+			t.skip(source, fmt.Errorf("source kept: %w", err))
+		} else {
+			item.Status = "moved"
+		}
+	}
+	t.result.Items = append(t.result.Items, item)
+	return nil
+}
+
+func (t *transfer) skip(path string, err error) {
+	t.result.Skipped = append(t.result.Skipped, path+": "+err.Error())
+}
+
+type copiedDir struct {
+	path string
+	info fs.FileInfo
+}
+
+// copyTree copies source to target without following symlinks. Directory
+// modes and times are applied last, as copying their contents changes them;
+// directories that were merged into keep their own.
+func (t *transfer) copyTree(source, target string) error {
+	var dirs []copiedDir
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := t.job.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			t.skip(path, err)
+			return nil
+		}
+		rel, _ := filepath.Rel(source, path)
+		to := filepath.Join(target, rel)
+
+		checkErr := t.jail.check(path)
+		if checkErr == nil {
+			checkErr = t.jail.check(to)
+		}
+		// 移動では移動元の各エントリを削除できる必要がある
+		if checkErr == nil && t.move {
+			checkErr = t.jail.canDelete(path)
+		}
+		if checkErr != nil {
+			t.skip(path, checkErr)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			t.skip(path, err)
+			return nil
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			var created bool
+			created, err = t.copyDir(path, to)
+			if created {
+				dirs = append(dirs, copiedDir{to, info})
+			}
+		case mode&fs.ModeSymlink != 0:
+			err = t.copySymlink(path, to)
+		case mode.IsRegular():
+			err = t.copyFile(path, to, info)
+		default:
+			err = errUnsupportedEntry
+		}
+		if err != nil {
+			if !isSkippableFileError(err) {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			t.skip(path, err)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		t.result.Entries++
+		t.job.advance(0, 1, path)
+		return nil
+	})
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirs[i].path, t.jail.preservedMode(dirs[i].info.Mode()))
+		os.Chtimes(dirs[i].path, fileAtime(dirs[i].info), dirs[i].info.ModTime())
+	}
+	return err
+}
+
+// clear makes room for a new entry at to according to the conflict policy.
+// Replacing an entry needs the same rights as deleting it.
+func (t *transfer) clear(to string) error {
+	info, err := os.Lstat(to)
+	if err != nil {
+		return t.jail.checkPermission(filepath.Dir(to), 2|1, "")
+	}
+	if info.IsDir() || t.conflict != "overwrite" {
+		return errEntryExists
+	}
+	if err := t.jail.canDelete(to); err != nil {
+		return err
+	}
+	return os.Remove(to)
+}
+
+// copyDir creates to unless a directory is already there to merge into
+func (t *transfer) copyDir(from, to string) (bool, error) {
+	if err := t.jail.canRead(from, true); err != nil {
+		return false, err
+	}
+	if info, err := os.Lstat(to); err == nil && info.IsDir() {
+		return false, nil
+	}
+	if err := t.clear(to); err != nil {
+		return false, err
+	}
+	// 中身をコピーし終えるまでは所有者が書き込めるようにしておく
+	if err := os.Mkdir(to, 0700); err != nil {
+		return false, err
+	}
+	t.jail.chownToAccount(to)
+	return true, nil
+}
+
+func (t *transfer) copySymlink(from, to string) error {
+	link, err := os.Readlink(from)
+	if err != nil {
+		return err
+	}
+	if err := t.clear(to); err != nil {
+		return err
+	}
+	if err := os.Symlink(link, to); err != nil {
+		return err
+	}
+	t.jail.chownToAccount(to)
+	return nil
+}
+
+func (t *transfer) copyFile(from, to string, info fs.FileInfo) error {
+	if err := t.jail.canRead(from, false); err != nil {
+		return err
+	}
+	if err := t.clear(to); err != nil {
+		return err
+	}
+
+	src, err := t.jail.openFile(from, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := t.jail.openFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(io.MultiWriter(dst, progressWriter{t.job}), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	t.jail.chownToAccount(to)
+	os.Chmod(to, t.jail.preservedMode(info.Mode()))
+	os.Chtimes(to, fileAtime(info), info.ModTime())
+	t.result.Bytes += n
+	return nil
+}
+
+// preservedMode keeps permission and sticky bits. setuid/setgid are kept only
+// for accounts without a Unix user (admins), like `cp -p` run by root.
+func (j *fileJail) preservedMode(mode fs.FileMode) fs.FileMode {
+	keep := fs.ModePerm | fs.ModeSticky
+	if j.account == nil {
+		keep |= fs.ModeSetuid | fs.ModeSetgid
+	}
+	return mode & keep
+}
+
+// availableName returns the first "name (n).ext" beside target that does not
+// exist yet. Directories are numbered after the whole name.
+func availableName(target string, dir bool) string {
+	parent, base := filepath.Split(target)
+	stem, ext := base, ""
+	if !dir && strings.LastIndex(base, ".") > 0 {
+		ext = filepath.Ext(base)
+		stem = strings.TrimSuffix(base, ext)
+	}
+
+	for i := 1; ; i++ {
+		candidate := filepath.Join(parent, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// fileAtime returns the access time of info, or its mtime when unavailable
+func fileAtime(info fs.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Sec, st.Atim.Nsec)
+	}
+	return info.ModTime()
+}
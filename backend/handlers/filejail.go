@@ -0,0 +1,710 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/unix"
+)
+
+// FileAccessConfig controls what the file manager may touch. It is read from
+// $WEBOS_FILES_CONFIG (default /etc/ubuntu-web-os/files.json) and reloaded when
+// the file changes; built-in defaults apply when it does not exist.
+type FileAccessConfig struct {
+	Roles          map[string]FileAccessRule `json:"roles"`
+	Users          map[string]FileAccessRule `json:"users"`
+	ProtectedPaths []string                  `json:"protected_paths"`
+	DeniedPaths    []string                  `json:"denied_paths"`
+}
+
+type FileAccessRule struct {
+	// Role selects the rule from Roles; only used in Users entries
+	Role string `json:"role,omitempty"`
+	// Roots are the directories the user may access. "~" is the user's home directory.
+	Roots []string `json:"roots,omitempty"`
+	// EnforceUnixPermissions checks access against the user's Unix account when the server runs as root
+	EnforceUnixPermissions *bool `json:"enforce_unix_permissions,omitempty"`
+	// Transfer limits in bytes; 0 means the role's value or the built-in default
+	MaxUploadBytes   int64 `json:"max_upload_bytes,omitempty"`
+	MaxDownloadBytes int64 `json:"max_download_bytes,omitempty"`
+	// UploadQuotaBytes caps the total size of a user's unfinished uploads
+	UploadQuotaBytes int64 `json:"upload_quota_bytes,omitempty"`
+	// Items are purged from the trash after TrashMaxAgeDays, oldest first when
+	// the trash grows beyond TrashMaxBytes
+	TrashMaxAgeDays int   `json:"trash_max_age_days,omitempty"`
+	TrashMaxBytes   int64 `json:"trash_max_bytes,omitempty"`
+}
+
+var (
+	errOutsideRoots  = errors.New("path is outside the allowed directories")
+	errDeniedPath    = errors.New("access to this path is denied")
+	errProtectedPath = errors.New("path is protected and cannot be removed")
+)
+
+// adminGroups are the Unix groups whose members get the admin role by default
+var adminGroups = []string{"sudo", "admin", "wheel"}
+
+var defaultFileAccessConfig = FileAccessConfig{
+	Roles: map[string]FileAccessRule{
+		"admin": {Roots: []string{"/"}, EnforceUnixPermissions: boolPtr(false)},
+		"user":  {Roots: []string{"~"}, EnforceUnixPermissions: boolPtr(true)},
+	},
+	Users: map[string]FileAccessRule{},
+	ProtectedPaths: []string{
+		"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib32", "/lib64", "/opt",
+		"/proc", "/root", "/run", "/sbin", "/srv", "/sys", "/tmp", "/usr", "/var",
+		"/etc/passwd", "/etc/group", "/etc/shadow", "/etc/sudoers", "/etc/fstab",
+	},
+	DeniedPaths: []string{
+		"/etc/shadow", "/etc/shadow-", "/etc/gshadow", "/etc/gshadow-",
+		"/etc/ssh/ssh_host_*_key", "/proc/kcore",
+	},
+}
+
+// Built-in transfer limits used when neither the user nor the role sets one
+const (
+	defaultMaxUploadBytes   = 64 << 30
+	defaultUploadQuotaBytes = 128 << 30
+	defaultTrashMaxAgeDays  = 30
+	defaultTrashMaxBytes    = 20 << 30
+)
+
+var fileAccessConfigCache struct {
+	sync.Mutex
+	config  FileAccessConfig
+	modTime time.Time
+	loaded  bool
+}
+
+// fileJail resolves and checks paths on behalf of one user
+type fileJail struct {
+	username  string
+	role      string
+	roots     []string
+	denied    []string
+	protected []string
+	// account is set when access has to be checked against the user's Unix permissions
+	account *unixAccount
+
+	maxUploadBytes   int64
+	maxDownloadBytes int64 // 0 is unlimited
+	uploadQuotaBytes int64
+	trashMaxAge      time.Duration
+	trashMaxBytes    int64
+}
+
+type unixAccount struct {
+	uid  uint32
+	gid  uint32
+	gids map[uint32]bool
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func firstPositive(values ...int64) int64 {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// webOSStateDir is where the application keeps its own data (upload sessions, caches)
+func webOSStateDir() string {
+	if dir := os.Getenv("WEBOS_STATE_DIR"); dir != "" {
+		return dir
+	}
+	return "/var/lib/ubuntu-web-os"
+}
+
+func fileAccessConfigPath() string {
+	if path := os.Getenv("WEBOS_FILES_CONFIG"); path != "" {
+		return path
+	}
+	return "/etc/ubuntu-web-os/files.json"
+}
+
+// loadFileAccessConfig returns the current configuration, re-reading the file when it changed
+func loadFileAccessConfig() (FileAccessConfig, error) {
+	cache := &fileAccessConfigCache
+	cache.Lock()
+	defer cache.Unlock()
+
+	path := fileAccessConfigPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultFileAccessConfig, nil
+		}
+		return FileAccessConfig{}, err
+	}
+	if cache.loaded && info.ModTime().Equal(cache.modTime) {
+		return cache.config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FileAccessConfig{}, err
+	}
+	config := FileAccessConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return FileAccessConfig{}, err
+	}
+
+	// 設定ファイルで省略された項目はデフォルト値を使う
+	if config.Roles == nil {
+		config.Roles = defaultFileAccessConfig.Roles
+	}
+	if config.Users == nil {
+		config.Users = map[string]FileAccessRule{}
+	}
+	if config.ProtectedPaths == nil {
+		config.ProtectedPaths = defaultFileAccessConfig.ProtectedPaths
+	}
+	if config.DeniedPaths == nil {
+		config.DeniedPaths = defaultFileAccessConfig.DeniedPaths
+	}
+
+	cache.config = config
+	cache.modTime = info.ModTime()
+	cache.loaded = true
+	return config, nil
+}
+
+// fileJailFor builds the jail for the authenticated user, writing an error response on failure
+func fileJailFor(c *gin.Context) (*fileJail, bool) {
+	jail, err := newFileJail(c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return jail, true
+}
+
+func newFileJail(username string) (*fileJail, error) {
+	config, err := loadFileAccessConfig()
+	if err != nil {
+		return nil, errors.New("failed to load file access configuration: " + err.Error())
+	}
+
+	account, _ := user.Lookup(username)
+
+	rule, hasUserRule := config.Users[username]
+	role := rule.Role
+	if role == "" {
+		role = defaultFileRole(username, account)
+	}
+	roleRule := config.Roles[role]
+	if !hasUserRule || len(rule.Roots) == 0 {
+		rule.Roots = roleRule.Roots
+	}
+	if rule.EnforceUnixPermissions == nil {
+		rule.EnforceUnixPermissions = roleRule.EnforceUnixPermissions
+	}
+
+	jail := &fileJail{
+		username:         username,
+		role:             role,
+		maxUploadBytes:   firstPositive(rule.MaxUploadBytes, roleRule.MaxUploadBytes, defaultMaxUploadBytes),
+		maxDownloadBytes: firstPositive(rule.MaxDownloadBytes, roleRule.MaxDownloadBytes),
+		uploadQuotaBytes: firstPositive(rule.UploadQuotaBytes, roleRule.UploadQuotaBytes, defaultUploadQuotaBytes),
+		trashMaxAge:      time.Duration(firstPositive(int64(rule.TrashMaxAgeDays), int64(roleRule.TrashMaxAgeDays), defaultTrashMaxAgeDays)) * 24 * time.Hour,
+		trashMaxBytes:    firstPositive(rule.TrashMaxBytes, roleRule.TrashMaxBytes, defaultTrashMaxBytes),
+	}
+	for _, root := range rule.Roots {
+		if root == "~" || strings.HasPrefix(root, "~/") {
+			if account == nil {
+				continue
+			}
+			root = filepath.Join(account.HomeDir, strings.TrimPrefix(root, "~"))
+		}
+		// Roots are compared against resolved paths, so resolve them too
+		resolved, err := filepath.EvalSymlinks(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		jail.roots = append(jail.roots, resolved)
+	}
+	if len(jail.roots) == 0 {
+		return nil, errors.New("no file access is configured for this user")
+	}
+
+	for _, path := range config.DeniedPaths {
+		jail.denied = append(jail.denied, filepath.Clean(path))
+	}
+	for _, path := range config.ProtectedPaths {
+		jail.protected = append(jail.protected, filepath.Clean(path))
+	}
+	// Deleting a root would remove the user's whole jail
+	jail.protected = append(jail.protected, jail.roots...)
+
+	// When the server itself is not root the kernel already enforces its permissions
+	if rule.EnforceUnixPermissions != nil && *rule.EnforceUnixPermissions && os.Geteuid() == 0 && account != nil {
+		jail.account = lookupUnixAccount(account)
+	}
+
+	return jail, nil
+}
+
+func (j *fileJail) isAdmin() bool {
+	return j.role == "admin"
+}
+
+// defaultFileRole gives the admin role to the application admin and to sudoers
+func defaultFileRole(username string, account *user.User) string {
+	if username == "admin" {
+		return "admin"
+	}
+	if account != nil {
+		if gids, err := account.GroupIds(); err == nil {
+			for _, gid := range gids {
+				if group, err := user.LookupGroupId(gid); err == nil && slices.Contains(adminGroups, group.Name) {
+					return "admin"
+				}
+			}
+		}
+	}
+	return "user"
+}
+
+func lookupUnixAccount(account *user.User) *unixAccount {
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil || uid == 0 {
+		return nil
+	}
+
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return nil
+	}
+
+	result := &unixAccount{uid: uint32(uid), gid: uint32(gid), gids: map[uint32]bool{}}
+	gids, _ := account.GroupIds()
+	for _, gid := range append(gids, account.Gid) {
+		if v, err := strconv.ParseUint(gid, 10, 32); err == nil {
+			result.gids[uint32(v)] = true
+		}
+	}
+	return result
+}
+
+// resolve turns a user supplied path into an absolute path with all symlinks
+// resolved and checks it against the jail. When followFinal is false the last
+// component is kept as is, so that operations act on a symlink itself.
+// Components that do not exist yet are appended unresolved.
+func (j *fileJail) resolve(path string, followFinal bool) (string, error) {
+	if path == "" {
+		return "", errors.New("path is required")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var real string
+	if followFinal || abs == "/" {
+		real, err = evalSymlinksPartial(abs)
+	} else {
+		var dir string
+		dir, err = evalSymlinksPartial(filepath.Dir(abs))
+		real = filepath.Join(dir, filepath.Base(abs))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := j.check(real); err != nil {
+		return "", err
+	}
+	return real, nil
+}
+
+// evalSymlinksPartial resolves the longest existing prefix of path
+func evalSymlinksPartial(path string) (string, error) {
+	existing := path
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) || existing == "/" {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = filepath.Dir(existing)
+	}
+}
+
+// check verifies that a resolved path lies inside a root and is not denied
+func (j *fileJail) check(real string) error {
+	inside := false
+	for _, root := range j.roots {
+		if isWithinPath(root, real) {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return errOutsideRoots
+	}
+
+	for _, denied := range j.denied {
+		if isWithinPath(denied, real) {
+			return errDeniedPath
+		}
+		if matched, _ := filepath.Match(denied, real); matched {
+			return errDeniedPath
+		}
+	}
+	return nil
+}
+
+// isWithinPath reports whether path is dir or inside dir
+func isWithinPath(dir, path string) bool {
+	if dir == "/" || dir == path {
+		return true
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// openFile opens a resolved path without following a symlink in the last
+// component and checks where the kernel actually opened it, so a path swapped
+// for a symlink between resolve and open cannot escape the jail
+func (j *fileJail) openFile(real string, flag int, perm os.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(real, flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	opened, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(file.Fd())))
+	if err == nil {
+		if err := j.check(opened); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+const dirOpenFlags = unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+
+// openDirNoFollow opens the directory at an absolute path one component at a
+// time and refuses a symlink anywhere along it. Later operations relative to
+// the returned directory cannot be redirected by swapping a component.
+func openDirNoFollow(path string) (*os.File, error) {
+	return openDirAt(nil, path, false, 0, -1, -1)
+}
+
+// mkdirAllAt opens rel below dir like openDirNoFollow, creating missing
+// directories with perm and giving them to uid:gid (-1 leaves the owner)
+func mkdirAllAt(dir *os.File, rel string, perm uint32, uid, gid int) (*os.File, error) {
+	return openDirAt(dir, rel, true, perm, uid, gid)
+}
+
+func openDirAt(dir *os.File, rel string, create bool, perm uint32, uid, gid int) (*os.File, error) {
+	name := "/"
+	fd, err := unix.Open("/", dirOpenFlags, 0)
+	if dir != nil {
+		name = dir.Name()
+		fd, err = unix.Openat(int(dir.Fd()), ".", dirOpenFlags, 0)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		next := -1
+		if part == ".." {
+			err = unix.EINVAL
+		} else {
+			next, err = unix.Openat(fd, part, dirOpenFlags, 0)
+		}
+		// O_DIRECTORY reports a symlink as ENOTDIR; report it as a loop like O_NOFOLLOW does
+		var st unix.Stat_t
+		if errors.Is(err, unix.ENOTDIR) && unix.Fstatat(fd, part, &st, unix.AT_SYMLINK_NOFOLLOW) == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK {
+			err = unix.ELOOP
+		}
+		if errors.Is(err, unix.ENOENT) && create {
+			created := unix.Mkdirat(fd, part, perm) == nil
+			next, err = unix.Openat(fd, part, dirOpenFlags, 0)
+			if err == nil && created && uid >= 0 {
+				// chownToAccountと同様に、setgidの親からはグループを引き継ぐ
+				owner := gid
+				if unix.Fstat(fd, &st) == nil && st.Mode&unix.S_ISGID != 0 {
+					owner = -1
+				}
+				unix.Fchown(next, uid, owner)
+			}
+		}
+		unix.Close(fd)
+		name = filepath.Join(name, part)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		fd = next
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// openAt opens a regular file below dir for reading without following a symlink
+func openAt(dir *os.File, name string) (*os.File, error) {
+	fd, err := unix.Openat(int(dir.Fd()), name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	file := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name))
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, &os.PathError{Op: "open", Path: file.Name(), Err: syscall.EINVAL}
+	}
+	return file, nil
+}
+
+// removeAllAt removes name below dir and everything inside it, working only
+// through directory descriptors and never following a symlink
+func removeAllAt(dir *os.File, name string) error {
+	err := unix.Unlinkat(int(dir.Fd()), name, 0)
+	if err == nil || errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if !errors.Is(err, unix.EISDIR) {
+		return &os.PathError{Op: "unlinkat", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+
+	sub, err := openDirAt(dir, name, false, 0, -1, -1)
+	if err != nil {
+		return err
+	}
+	names, err := sub.Readdirnames(-1)
+	for _, child := range names {
+		if err == nil {
+			err = removeAllAt(sub, child)
+		}
+	}
+	sub.Close()
+	if err != nil {
+		return err
+	}
+	if err := unix.Unlinkat(int(dir.Fd()), name, unix.AT_REMOVEDIR); err != nil && !errors.Is(err, unix.ENOENT) {
+		return &os.PathError{Op: "unlinkat", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	return nil
+}
+
+// writeFileAt atomically replaces name below dir like writeFileAtomic, but
+// creates the temporary file and renames it relative to dir without
+// following symlinks. The file is given to uid:gid unless uid is -1.
+func writeFileAt(dir *os.File, name string, data []byte, perm uint32, uid, gid int) error {
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+	tmp := "." + name + ".tmp-" + id
+	fd, err := unix.Openat(int(dir.Fd()), tmp, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, perm)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: filepath.Join(dir.Name(), tmp), Err: err}
+	}
+	file := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), tmp))
+	defer unix.Unlinkat(int(dir.Fd()), tmp, 0)
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(os.FileMode(perm))
+	}
+	if err == nil && uid >= 0 {
+		err = file.Chown(uid, gid)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := unix.Renameat(int(dir.Fd()), tmp, int(dir.Fd()), name); err != nil {
+		return &os.PathError{Op: "rename", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	return nil
+}
+
+// canDelete rejects protected paths and anything containing one
+func (j *fileJail) canDelete(real string) error {
+	if j.containsProtected(real) {
+		return errProtectedPath
+	}
+	return j.checkPermission(filepath.Dir(real), 2|1, real)
+}
+
+// removeAll deletes real and everything below it on behalf of the user.
+// Every entry must pass canDelete before anything is removed, and removal
+// runs through no-follow directory descriptors so that a directory swapped
+// for a symlink in between cannot redirect it.
+func (j *fileJail) removeAll(real string) error {
+	err := filepath.WalkDir(real, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return j.canDelete(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	parent, err := openDirNoFollow(filepath.Dir(real))
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	return removeAllAt(parent, filepath.Base(real))
+}
+
+// containsProtected reports whether real is a protected path or contains one
+func (j *fileJail) containsProtected(real string) bool {
+	for _, protected := range j.protected {
+		if isWithinPath(real, protected) {
+			return true
+		}
+	}
+	return false
+}
+
+// canRead checks read permission on a file or read+search on a directory
+func (j *fileJail) canRead(real string, dir bool) error {
+	if dir {
+		return j.checkPermission(real, 4|1, "")
+	}
+	return j.checkPermission(real, 4, "")
+}
+
+// canWrite checks write permission on an existing file, or on the parent
+// directory when the file is about to be created
+func (j *fileJail) canWrite(real string) error {
+	if _, err := os.Lstat(real); err == nil {
+		return j.checkPermission(real, 2, "")
+	}
+	return j.checkPermission(filepath.Dir(real), 2|1, "")
+}
+
+// checkPermission emulates the kernel's permission check for the user's Unix
+// account: search permission on every ancestor and want (r=4, w=2, x=1) on
+// path itself. When child is set the sticky bit of path is honoured for it.
+// ACLs are not evaluated.
+func (j *fileJail) checkPermission(path string, want uint32, child string) error {
+	if j.account == nil {
+		return nil
+	}
+
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if err := j.account.access(dir, 1); err != nil {
+			return err
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	if err := j.account.access(path, want); err != nil {
+		return err
+	}
+
+	if child != "" {
+		var dirStat, childStat syscall.Stat_t
+		if syscall.Stat(path, &dirStat) == nil && dirStat.Mode&syscall.S_ISVTX != 0 &&
+			syscall.Lstat(child, &childStat) == nil &&
+			childStat.Uid != j.account.uid && dirStat.Uid != j.account.uid {
+			return os.ErrPermission
+		}
+	}
+	return nil
+}
+
+func (a *unixAccount) access(path string, want uint32) error {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return os.ErrNotExist
+		}
+		return err
+	}
+
+	if !a.permits(st.Uid, st.Gid, st.Mode, want) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// permits applies the owner, group and other bits of mode to the account
+func (a *unixAccount) permits(uid, gid, mode, want uint32) bool {
+	var bits uint32
+	switch {
+	case uid == a.uid:
+		bits = (mode >> 6) & 7
+	case a.gids[gid]:
+		bits = (mode >> 3) & 7
+	default:
+		bits = mode & 7
+	}
+	return bits&want == want
+}
+
+// chownToAccount gives files created on behalf of a Unix account to that account
+func (j *fileJail) chownToAccount(path string) {
+	if j.account == nil {
+		return
+	}
+	var st syscall.Stat_t
+	if syscall.Stat(filepath.Dir(path), &st) != nil {
+		return
+	}
+	// 親ディレクトリがsetgidの場合はそのグループを引き継ぐ
+	gid := -1
+	if st.Mode&syscall.S_ISGID == 0 {
+		gid = int(j.account.gid)
+	}
+	os.Lchown(path, int(j.account.uid), gid)
+}
+
+// accountOwner returns the uid and gid to give files created on behalf of
+// the user, or -1, -1 when the server acts as itself
+func (j *fileJail) accountOwner() (int, int) {
+	if j.account == nil {
+		return -1, -1
+	}
+	return int(j.account.uid), int(j.account.gid)
+}
+
+// respondFileError maps jail, permission and not-found errors to HTTP status codes
+func respondFileError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, errOutsideRoots), errors.Is(err, errDeniedPath), errors.Is(err, errProtectedPath):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, os.ErrPermission):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": message})
+	case errors.Is(err, syscall.ELOOP):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Path changed while it was being accessed"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
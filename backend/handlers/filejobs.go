@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileJob is a long running file operation (archive, extract, copy, move)
+// whose progress can be polled and which can be cancelled
+type FileJob struct {
+	ID           string     `json:"id"`
+	Type         string     `json:"type"`
+	Status       string     `json:"status"` // running, completed, failed, cancelled
+	TotalBytes   int64      `json:"total_bytes"`
+	DoneBytes    int64      `json:"done_bytes"`
+	TotalEntries int        `json:"total_entries"`
+	DoneEntries  int        `json:"done_entries"`
+	Progress     float64    `json:"progress"`
+	Current      string     `json:"current"`
+	Error        string     `json:"error,omitempty"`
+	Result       any        `json:"result,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+
+	username string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+}
+
+// Finished jobs are kept this long so that clients can read the result
+const fileJobRetention = time.Hour
+
+var fileJobs = struct {
+	sync.Mutex
+	byID map[string]*FileJob
+}{byID: map[string]*FileJob{}}
+
+// startFileJob registers a job and runs fn in the background. fn reports
+// progress through the job and should stop when job.ctx is cancelled.
+func startFileJob(username, jobType string, fn func(job *FileJob) (any, error)) (*FileJob, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &FileJob{
+		ID:        id,
+		Type:      jobType,
+		Status:    "running",
+		StartedAt: time.Now(),
+		username:  username,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	fileJobs.Lock()
+	for id, old := range fileJobs.byID {
+		// FinishedAtはジョブのgoroutineがold.muの下で書き込む
+		old.mu.Lock()
+		expired := old.FinishedAt != nil && time.Since(*old.FinishedAt) > fileJobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(fileJobs.byID, id)
+		}
+	}
+	fileJobs.byID[job.ID] = job
+	fileJobs.Unlock()
+
+	go func() {
+		result, err := fn(job)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Result = result
+		job.Current = ""
+		switch {
+		case ctx.Err() != nil:
+			job.Status = "cancelled"
+		case err != nil:
+			job.Status = "failed"
+			job.Error = err.Error()
+		default:
+			job.Status = "completed"
+			job.Progress = 100
+		}
+		cancel()
+	}()
+
+	return job, nil
+}
+
+// setTotals records the size of the work once it is known
+func (j *FileJob) setTotals(bytes int64, entries int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.TotalBytes = bytes
+	j.TotalEntries = entries
+	j.updateProgress()
+}
+
+// advance adds completed work and sets the entry currently being processed
+func (j *FileJob) advance(bytes int64, entries int, current string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.DoneBytes += bytes
+	j.DoneEntries += entries
+	if current != "" {
+		j.Current = current
+	}
+	j.updateProgress()
+}
+
+func (j *FileJob) updateProgress() {
+	switch {
+	case j.TotalBytes > 0:
+		j.Progress = min(float64(j.DoneBytes)*100/float64(j.TotalBytes), 100)
+	case j.TotalEntries > 0:
+		j.Progress = min(float64(j.DoneEntries)*100/float64(j.TotalEntries), 100)
+	}
+}
+
+// snapshot copies the exported fields for JSON encoding
+func (j *FileJob) snapshot() FileJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return FileJob{
+		ID:           j.ID,
+		Type:         j.Type,
+		Status:       j.Status,
+		TotalBytes:   j.TotalBytes,
+		DoneBytes:    j.DoneBytes,
+		TotalEntries: j.TotalEntries,
+		DoneEntries:  j.DoneEntries,
+		Progress:     j.Progress,
+		Current:      j.Current,
+		Error:        j.Error,
+		Result:       j.Result,
+		StartedAt:    j.StartedAt,
+		FinishedAt:   j.FinishedAt,
+	}
+}
+
+var (
+	errEntryExists      = errors.New("already exists")
+	errUnsupportedEntry = errors.New("unsupported entry type")
+	errNoSpace          = errors.New("not enough free space on the target filesystem")
+)
+
+// isSkippableFileError reports whether a job should record err for a single
+// entry and carry on, rather than fail as a whole
+func isSkippableFileError(err error) bool {
+	return errors.Is(err, errEntryExists) || errors.Is(err, errUnsupportedEntry) ||
+		errors.Is(err, errOutsideRoots) || errors.Is(err, errDeniedPath) || errors.Is(err, errProtectedPath) ||
+		errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// progressWriter counts bytes written through it towards a job
+type progressWriter struct {
+	job *FileJob
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	if err := w.job.ctx.Err(); err != nil {
+		return 0, err
+	}
+	w.job.advance(int64(len(p)), 0, "")
+	return len(p), nil
+}
+
+// ListFileJobs returns the current user's file jobs, newest first
+func ListFileJobs(c *gin.Context) {
+	username := c.GetString("username")
+
+	fileJobs.Lock()
+	jobs := []FileJob{}
+	for _, job := range fileJobs.byID {
+		if job.username == username {
+			jobs = append(jobs, job.snapshot())
+		}
+	}
+	fileJobs.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.After(jobs[j].StartedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetFileJob returns the progress of a single job
+func GetFileJob(c *gin.Context) {
+	job, ok := lookupFileJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job.snapshot())
+}
+
+// CancelFileJob stops a running job. Work already done is not rolled back,
+// except for partially written output files.
+func CancelFileJob(c *gin.Context) {
+	job, ok := lookupFileJob(c)
+	if !ok {
+		return
+	}
+	job.cancel()
+	c.JSON(http.StatusOK, gin.H{"message": "Cancellation requested", "job": job.snapshot()})
+}
+
+func lookupFileJob(c *gin.Context) (*FileJob, bool) {
+	fileJobs.Lock()
+	job, ok := fileJobs.byID[c.Param("id")]
+	fileJobs.Unlock()
+
+	if !ok || job.username != c.GetString("username") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil, false
+	}
+	return job, true
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultListLimit = 500
+	maxListLimit     = 5000
+	listReadBatch    = 4096
+	mimeSniffSize    = 3072
+)
+
+var errBadCursor = errors.New("cursor does not match this listing")
+
+// listQuery is a page request for a directory listing. Entries are ordered by
+// the sort key, then by name, so every entry has a unique position and the
+// cursor (the last entry of the previous page) stays valid while the
+// directory changes.
+type listQuery struct {
+	sort      string // name, size, mtime or type
+	desc      bool
+	dirsFirst bool
+	hidden    bool
+	filter    string // lower-cased substring, or a glob when glob is set
+	glob      bool
+	limit     int
+	after     *listEntry
+}
+
+// listEntry holds what is needed to order an entry; full details are only
+// gathered for the entries of the returned page
+type listEntry struct {
+	name  string
+	isDir bool
+	size  int64
+	mtime int64
+	info  fs.FileInfo // nil when the sort key did not need a stat
+}
+
+type listCursor struct {
+	Sort      string `json:"s"`
+	Desc      bool   `json:"o"`
+	DirsFirst bool   `json:"d"`
+	Name      string `json:"n"`
+	IsDir     bool   `json:"i"`
+	Size      int64  `json:"z"`
+	MTime     int64  `json:"t"`
+}
+
+func parseListQuery(c *gin.Context) (*listQuery, error) {
+	q := &listQuery{
+		sort:      c.DefaultQuery("sort", "name"),
+		desc:      c.Query("order") == "desc",
+		dirsFirst: c.Query("dirs_first") != "false",
+		hidden:    c.Query("hidden") == "true",
+		filter:    strings.ToLower(c.Query("filter")),
+		limit:     defaultListLimit,
+	}
+
+	switch q.sort {
+	case "name", "size", "mtime", "type":
+	default:
+		return nil, errors.New("Invalid sort key: " + q.sort)
+	}
+	if order := c.Query("order"); order != "" && order != "asc" && order != "desc" {
+		return nil, errors.New("Invalid order: " + order)
+	}
+	if strings.ContainsAny(q.filter, "*?[") {
+		if _, err := filepath.Match(q.filter, ""); err != nil {
+			return nil, errors.New("Invalid filter pattern")
+		}
+		q.glob = true
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("Invalid limit")
+		}
+		q.limit = min(n, maxListLimit)
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		data, err := base64.RawURLEncoding.DecodeString(v)
+		var cursor listCursor
+		if err != nil || json.Unmarshal(data, &cursor) != nil {
+			return nil, errBadCursor
+		}
+		if cursor.Sort != q.sort || cursor.Desc != q.desc || cursor.DirsFirst != q.dirsFirst {
+			return nil, errBadCursor
+		}
+		q.after = &listEntry{name: cursor.Name, isDir: cursor.IsDir, size: cursor.Size, mtime: cursor.MTime}
+	}
+	return q, nil
+}
+
+func (q *listQuery) cursor(e *listEntry) string {
+	data, _ := json.Marshal(listCursor{
+		Sort:      q.sort,
+		Desc:      q.desc,
+		DirsFirst: q.dirsFirst,
+		Name:      e.name,
+		IsDir:     e.isDir,
+		Size:      e.size,
+		MTime:     e.mtime,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// compare orders two entries; directories stay first in both directions
+func (q *listQuery) compare(a, b *listEntry) int {
+	if q.dirsFirst && a.isDir != b.isDir {
+		if a.isDir {
+			return -1
+		}
+		return 1
+	}
+
+	result := 0
+	switch q.sort {
+	case "size":
+		result = compareInt(a.size, b.size)
+	case "mtime":
+		result = compareInt(a.mtime, b.mtime)
+	case "type":
+		result = strings.Compare(listExtension(a), listExtension(b))
+	}
+	if result == 0 {
+		result = strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name))
+	}
+	if result == 0 {
+		result = strings.Compare(a.name, b.name)
+	}
+	if q.desc {
+		return -result
+	}
+	return result
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func listExtension(e *listEntry) string {
+	if e.isDir {
+		return ""
+	}
+	return strings.ToLower(filepath.Ext(e.name))
+}
+
+func (q *listQuery) matches(name string) bool {
+	if !q.hidden && strings.HasPrefix(name, ".") {
+		return false
+	}
+	if q.filter == "" {
+		return true
+	}
+	lower := strings.ToLower(name)
+	if q.glob {
+		ok, _ := filepath.Match(q.filter, lower)
+		return ok
+	}
+	return strings.Contains(lower, q.filter)
+}
+
+// pageHeap keeps the first entries of the page; its root is the last of them
+type pageHeap struct {
+	q       *listQuery
+	entries []*listEntry
+}
+
+func (h *pageHeap) Len() int           { return len(h.entries) }
+func (h *pageHeap) Less(i, j int) bool { return h.q.compare(h.entries[i], h.entries[j]) > 0 }
+func (h *pageHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *pageHeap) Push(x any)         { h.entries = append(h.entries, x.(*listEntry)) }
+func (h *pageHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// readPage streams the directory and returns the entries of the requested
+// page in order, whether more follow, and how many entries match in total.
+// Memory stays proportional to the page size however large the directory is.
+func (q *listQuery) readPage(dir *os.File) ([]*listEntry, bool, int, error) {
+	page := &pageHeap{q: q}
+	total := 0
+	needStat := q.sort == "size" || q.sort == "mtime"
+
+	for {
+		batch, err := dir.ReadDir(listReadBatch)
+		for _, d := range batch {
+			if !q.matches(d.Name()) {
+				continue
+			}
+			total++
+
+			entry := &listEntry{name: d.Name(), isDir: d.IsDir()}
+			if needStat {
+				info, err := d.Info()
+				if err != nil {
+					continue
+				}
+				entry.info = info
+				entry.size = info.Size()
+				entry.mtime = info.ModTime().UnixNano()
+			}
+			if q.after != nil && q.compare(entry, q.after) <= 0 {
+				continue
+			}
+
+			// limit+1件目があれば次のページが存在する
+			if page.Len() <= q.limit {
+				heap.Push(page, entry)
+			} else if q.compare(entry, page.entries[0]) < 0 {
+				page.entries[0] = entry
+				heap.Fix(page, 0)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, 0, err
+		}
+	}
+
+	entries := make([]*listEntry, page.Len())
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i] = heap.Pop(page).(*listEntry)
+	}
+	more := len(entries) > q.limit
+	if more {
+		entries = entries[:q.limit]
+	}
+	return entries, more, total, nil
+}
+
+// detectContent fills in the MIME type and whether a file holds text or binary
+// data. Regular files are sniffed from their first bytes when the user may
+// read them; otherwise the type is guessed from the extension.
+func detectContent(jail *fileJail, entry *FileInfo, info fs.FileInfo) {
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		entry.MimeType, entry.Kind = "inode/directory", "directory"
+		return
+	case mode&fs.ModeSymlink != 0:
+		entry.MimeType, entry.Kind = "inode/symlink", "symlink"
+		return
+	case !mode.IsRegular():
+		entry.MimeType, entry.Kind = "inode/x-special", "special"
+		return
+	}
+
+	entry.Kind = "unknown"
+	entry.MimeType = mime.TypeByExtension(filepath.Ext(info.Name()))
+	if entry.MimeType == "" {
+		entry.MimeType = "application/octet-stream"
+	}
+	// Empty files have nothing to sniff; reading files of kernel filesystems
+	// can have side effects (/proc/kmsg consumes messages) or block
+	if info.Size() == 0 || onPseudoFilesystem(entry.Path) || jail.canRead(entry.Path, false) != nil {
+		return
+	}
+	// FIFOに差し替えられても待たされないようにO_NONBLOCKで開く
+	file, err := jail.openFile(entry.Path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	head := make([]byte, mimeSniffSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return
+	}
+
+	detected := mimetype.Detect(head[:n])
+	entry.MimeType = detected.String()
+	entry.Kind = "binary"
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Is("text/plain") {
+			entry.Kind = "text"
+			break
+		}
+	}
+}
+
+// pseudoFilesystems are the kernel filesystems whose files are generated on
+// read rather than stored
+var pseudoFilesystems = map[int64]bool{
+	unix.PROC_SUPER_MAGIC:    true,
+	unix.SYSFS_MAGIC:         true,
+	unix.DEBUGFS_MAGIC:       true,
+	unix.TRACEFS_MAGIC:       true,
+	unix.SECURITYFS_MAGIC:    true,
+	unix.CGROUP_SUPER_MAGIC:  true,
+	unix.CGROUP2_SUPER_MAGIC: true,
+	0x62656570:               true, // configfs
+	unix.EFIVARFS_MAGIC:      true,
+	unix.BPF_FS_MAGIC:        true,
+	unix.PSTOREFS_MAGIC:      true,
+}
+
+func onPseudoFilesystem(path string) bool {
+	var st syscall.Statfs_t
+	return syscall.Statfs(path, &st) == nil && pseudoFilesystems[int64(st.Type)]
+}
@@ -0,0 +1,944 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/unix"
+)
+
+// PermissionChange is the result of a chmod, chown or ACL request. With
+// preview set nothing is modified and Changed counts what would change.
+type PermissionChange struct {
+	Preview bool     `json:"preview"`
+	Total   int      `json:"total"`
+	Changed int      `json:"changed"`
+	Skipped []string `json:"skipped"` // "path: reason"
+}
+
+type ACLEntry struct {
+	Tag       string `json:"tag"`       // user, group, mask, other
+	Qualifier string `json:"qualifier"` // user or group name, empty for the owner entries
+	Perms     string `json:"perms"`     // e.g. "rw-"
+	Effective string `json:"effective,omitempty"`
+}
+
+type FileACL struct {
+	Path    string     `json:"path"`
+	Owner   string     `json:"owner"`
+	Group   string     `json:"group"`
+	Access  []ACLEntry `json:"access"`
+	Default []ACLEntry `json:"default"`
+}
+
+const (
+	maxPermissionEntries = 100000
+	maxXattrValue        = 256
+	aclBatchSize         = 200
+)
+
+var errTooManyEntries = fmt.Errorf("more than %d entries; narrow the selection", maxPermissionEntries)
+
+// ChangeMode applies {"mode"} (octal like "0755" or symbolic like "u+x,go-w")
+// to {"path"}, optionally recursively. Symlinks are left alone.
+func ChangeMode(c *gin.Context) {
+	var request struct {
+		Path      string `json:"path"`
+		Mode      string `json:"mode"`
+		Recursive bool   `json:"recursive"`
+		Preview   bool   `json:"preview"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	apply, err := parseChmod(request.Mode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	result := PermissionChange{Preview: request.Preview, Skipped: []string{}}
+	ok = walkPermissionTargets(c, jail, request.Path, request.Recursive, &result, func(path string, st *syscall.Stat_t) (bool, error) {
+		if st.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+			return false, nil
+		}
+		old := st.Mode & 07777
+		mode := apply(old, st.Mode&syscall.S_IFMT == syscall.S_IFDIR)
+		if mode == old {
+			return false, nil
+		}
+		if err := jail.canChangeAttributes(st); err != nil {
+			return false, err
+		}
+		if !request.Preview {
+			if err := chmodUnchanged(path, st, mode); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ChangeOwner sets {"owner"} and/or {"group"} (names or numeric ids) of
+// {"path"}. Only administrators may change the owner; other users may move
+// their own files to a group they belong to, as chgrp allows.
+func ChangeOwner(c *gin.Context) {
+	var request struct {
+		Path      string `json:"path"`
+		Owner     string `json:"owner"`
+		Group     string `json:"group"`
+		Recursive bool   `json:"recursive"`
+		Preview   bool   `json:"preview"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if request.Owner == "" && request.Group == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner or group is required"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	uid, gid := -1, -1
+	var err error
+	if request.Owner != "" {
+		if uid, err = lookupAccountID(request.Owner, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user: " + request.Owner})
+			return
+		}
+	}
+	if request.Group != "" {
+		if gid, err = lookupAccountID(request.Group, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown group: " + request.Group})
+			return
+		}
+	}
+	if account := jail.account; account != nil {
+		if uid != -1 && uint32(uid) != account.uid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can change the owner"})
+			return
+		}
+		if gid != -1 && !account.gids[uint32(gid)] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of that group"})
+			return
+		}
+	}
+
+	result := PermissionChange{Preview: request.Preview, Skipped: []string{}}
+	ok = walkPermissionTargets(c, jail, request.Path, request.Recursive, &result, func(path string, st *syscall.Stat_t) (bool, error) {
+		if (uid == -1 || uint32(uid) == st.Uid) && (gid == -1 || uint32(gid) == st.Gid) {
+			return false, nil
+		}
+		if err := jail.canChangeAttributes(st); err != nil {
+			return false, err
+		}
+		if !request.Preview {
+			if err := chownUnchanged(path, st, uid, gid); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetFileACL returns the POSIX ACL of ?path= as reported by getfacl
+func GetFileACL(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	path, err := jail.resolve(c.Query("path"), true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		respondFileError(c, err, "File not found")
+		return
+	}
+
+	acls, err := readACLs([]string{path}, false)
+	if err != nil {
+		respondACLError(c, err)
+		return
+	}
+	if len(acls) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read ACL"})
+		return
+	}
+
+	c.JSON(http.StatusOK, acls[0])
+}
+
+// SetFileACL adds or replaces the {"modify"} entries ("u:alice:rwx",
+// "g:dev:r-x") and drops the {"remove"} entries ("u:alice") of the access ACL,
+// or of the default ACL of directories when {"default"} is set
+func SetFileACL(c *gin.Context) {
+	var request struct {
+		Path      string   `json:"path"`
+		Modify    []string `json:"modify"`
+		Remove    []string `json:"remove"`
+		Default   bool     `json:"default"`
+		Recursive bool     `json:"recursive"`
+		Preview   bool     `json:"preview"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if len(request.Modify) == 0 && len(request.Remove) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No ACL entries given"})
+		return
+	}
+
+	var modify, remove []ACLEntry
+	for _, spec := range request.Modify {
+		entry, err := parseACLSpec(spec, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		modify = append(modify, entry)
+	}
+	for _, spec := range request.Remove {
+		entry, err := parseACLSpec(spec, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		remove = append(remove, entry)
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	result := PermissionChange{Preview: request.Preview, Skipped: []string{}}
+	var candidates []string
+	stats := map[string]*syscall.Stat_t{}
+	ok = walkPermissionTargets(c, jail, request.Path, request.Recursive, &result, func(path string, st *syscall.Stat_t) (bool, error) {
+		kind := st.Mode & syscall.S_IFMT
+		if kind == syscall.S_IFLNK || (request.Default && kind != syscall.S_IFDIR) {
+			return false, nil
+		}
+		if err := jail.canChangeAttributes(st); err != nil {
+			return false, err
+		}
+		candidates = append(candidates, path)
+		stats[path] = st
+		return false, nil
+	})
+	if !ok {
+		return
+	}
+
+	// 現在のACLと比較して実際に変わるものだけを対象にする
+	var changed []string
+	for start := 0; start < len(candidates); start += aclBatchSize {
+		batch := candidates[start:min(start+aclBatchSize, len(candidates))]
+		acls, err := readACLs(batch, true)
+		if err != nil {
+			respondACLError(c, err)
+			return
+		}
+		for _, acl := range acls {
+			entries := acl.Access
+			if request.Default {
+				entries = acl.Default
+			}
+			if aclWouldChange(entries, modify, remove) {
+				changed = append(changed, acl.Path)
+			}
+		}
+	}
+	result.Changed = len(changed)
+
+	if !request.Preview {
+		// setfaclにはパスではなく検証済みのfdを渡し、走査後に親ディレクトリを
+		// シンボリックリンクへ差し替えられても別のファイルを変更しないようにする
+		var args []string
+		if request.Default {
+			args = append(args, "-d")
+		}
+		if len(modify) > 0 {
+			args = append(args, "-m", joinACLEntries(modify))
+		}
+		if len(remove) > 0 {
+			args = append(args, "-x", joinACLEntries(remove))
+		}
+		for start := 0; start < len(changed); start += aclBatchSize {
+			batch := changed[start:min(start+aclBatchSize, len(changed))]
+			if err := setACLUnchanged(args, batch, stats, &result); err != nil {
+				respondACLError(c, err)
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// walkPermissionTargets calls visit for path and, when recursive, everything
+// below it without following symlinks. visit reports whether the entry
+// changed; its errors are recorded as skipped entries. Recursive changes of
+// protected paths are refused.
+func walkPermissionTargets(c *gin.Context, jail *fileJail, path string, recursive bool, result *PermissionChange, visit func(path string, st *syscall.Stat_t) (bool, error)) bool {
+	root, err := jail.resolve(path, true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return false
+	}
+	if _, err := os.Lstat(root); err != nil {
+		respondFileError(c, err, "File not found")
+		return false
+	}
+	if recursive && jail.containsProtected(root) {
+		respondFileError(c, errProtectedPath, "")
+		return false
+	}
+
+	skip := func(path string, err error) {
+		result.Skipped = append(result.Skipped, path+": "+err.Error())
+	}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			skip(path, err)
+			return nil
+		}
+		if err := jail.check(path); err != nil {
+			skip(path, err)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		result.Total++
+		if result.Total > maxPermissionEntries {
+			return errTooManyEntries
+		}
+
+		var st syscall.Stat_t
+		if err := syscall.Lstat(path, &st); err != nil {
+			skip(path, err)
+		} else if changed, err := visit(path, &st); err != nil {
+			skip(path, err)
+		} else if changed {
+			result.Changed++
+		}
+
+		if !recursive {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+var errEntryReplaced = errors.New("entry was replaced while being changed")
+
+// openUnchanged opens path itself (O_PATH, never following a symlink) and
+// checks that it is still the entry described by st. A user could otherwise
+// swap an entry, or a directory above it, for a symlink between the walk and
+// the change, and have the server modify a file outside the jail.
+func openUnchanged(path string, st *syscall.Stat_t) (int, error) {
+	fd, err := syscall.Open(path, unix.O_PATH|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	var now syscall.Stat_t
+	if err := syscall.Fstat(fd, &now); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if now.Dev != st.Dev || now.Ino != st.Ino || now.Mode&syscall.S_IFMT != st.Mode&syscall.S_IFMT {
+		syscall.Close(fd)
+		return -1, errEntryReplaced
+	}
+	return fd, nil
+}
+
+func chmodUnchanged(path string, st *syscall.Stat_t, mode uint32) error {
+	fd, err := openUnchanged(path, st)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	// O_PATHのfdにはfchmodが使えないので/procのリンク経由で変更する
+	return syscall.Chmod("/proc/self/fd/"+strconv.Itoa(fd), mode)
+}
+
+func chownUnchanged(path string, st *syscall.Stat_t, uid, gid int) error {
+	fd, err := openUnchanged(path, st)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	// AT_EMPTY_PATHでfd自身（シンボリックリンクならリンク自体）を変更する
+	return unix.Fchownat(fd, "", uid, gid, unix.AT_EMPTY_PATH)
+}
+
+// setACLUnchanged runs setfacl with args on paths. Each path is passed as the
+// /proc/self/fd link of a descriptor checked with openUnchanged, so setfacl
+// reaches the walked entry even if a directory above it was swapped since.
+func setACLUnchanged(args, paths []string, stats map[string]*syscall.Stat_t, result *PermissionChange) error {
+	cmd := exec.Command("setfacl")
+	args = append(slices.Clone(args), "--")
+	fdPaths := map[string]string{}
+	for _, path := range paths {
+		fd, err := openUnchanged(path, stats[path])
+		if err != nil {
+			result.Skipped = append(result.Skipped, path+": "+err.Error())
+			result.Changed--
+			continue
+		}
+		file := os.NewFile(uintptr(fd), path)
+		defer file.Close()
+		// ExtraFilesは子プロセスで3番から順に割り当てられる
+		fdPath := "/proc/self/fd/" + strconv.Itoa(3+len(cmd.ExtraFiles))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+		fdPaths[fdPath] = path
+		args = append(args, fdPath)
+	}
+	if len(cmd.ExtraFiles) == 0 {
+		return nil
+	}
+
+	cmd.Args = append(cmd.Args, args...)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if len(output) == 0 {
+		return err
+	}
+	// setfaclは失敗したファイルだけを報告して残りは処理する
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		line = strings.TrimPrefix(line, "setfacl: ")
+		if fdPath, rest, ok := strings.Cut(line, ":"); ok && fdPaths[fdPath] != "" {
+			line = fdPaths[fdPath] + ":" + rest
+		}
+		result.Skipped = append(result.Skipped, line)
+		result.Changed--
+	}
+	return nil
+}
+
+// canChangeAttributes emulates the kernel rule that only the owner may change
+// the mode, group or ACL of a file
+func (j *fileJail) canChangeAttributes(st *syscall.Stat_t) error {
+	if j.account != nil && st.Uid != j.account.uid {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// parseChmod accepts an octal mode or comma separated symbolic clauses in the
+// syntax of chmod(1): [ugoa]*[+-=][rwxXst]*. Clauses without a class apply
+// to all classes; the umask is not consulted.
+func parseChmod(spec string) (func(old uint32, dir bool) uint32, error) {
+	if spec == "" {
+		return nil, errors.New("Mode is required")
+	}
+	if v, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if v > 07777 {
+			return nil, errors.New("Invalid mode: " + spec)
+		}
+		return func(uint32, bool) uint32 { return uint32(v) }, nil
+	}
+
+	type clause struct {
+		who   string
+		op    byte
+		perms string
+	}
+	var clauses []clause
+	for _, part := range strings.Split(spec, ",") {
+		who := strings.TrimLeft(part, "ugoa")
+		classes := part[:len(part)-len(who)]
+		if classes == "" || strings.Contains(classes, "a") {
+			classes = "ugo"
+		}
+		if who == "" {
+			return nil, errors.New("Invalid mode: " + spec)
+		}
+		// "u+x-w" のように演算子が続く場合に対応
+		for who != "" {
+			op := who[0]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, errors.New("Invalid mode: " + spec)
+			}
+			rest := who[1:]
+			perms := rest[:len(rest)-len(strings.TrimLeft(rest, "rwxXst"))]
+			clauses = append(clauses, clause{classes, op, perms})
+			who = rest[len(perms):]
+		}
+	}
+
+	return func(mode uint32, dir bool) uint32 {
+		for _, cl := range clauses {
+			bits, clear := uint32(0), uint32(0)
+			for _, class := range cl.who {
+				shift := map[rune]uint{'u': 6, 'g': 3, 'o': 0}[class]
+				clear |= 07 << shift
+				for _, p := range cl.perms {
+					switch p {
+					case 'r':
+						bits |= 04 << shift
+					case 'w':
+						bits |= 02 << shift
+					case 'x':
+						bits |= 01 << shift
+					case 'X':
+						if dir || mode&0111 != 0 {
+							bits |= 01 << shift
+						}
+					case 's':
+						bits |= map[rune]uint32{'u': 04000, 'g': 02000}[class]
+					case 't':
+						if class == 'o' {
+							bits |= 01000
+						}
+					}
+				}
+				clear |= map[rune]uint32{'u': 04000, 'g': 02000, 'o': 01000}[class]
+			}
+			switch cl.op {
+			case '+':
+				mode |= bits
+			case '-':
+				mode &^= bits
+			case '=':
+				mode = mode&^clear | bits
+			}
+		}
+		return mode
+	}, nil
+}
+
+// lookupAccountID resolves a user or group name, or accepts a numeric id
+func lookupAccountID(name string, group bool) (int, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return int(id), nil
+	}
+	if group {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return -1, err
+		}
+		return strconv.Atoi(g.Gid)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// parseACLSpec parses "u:alice:rwx", "g::r-x", "m::rw" or "o::r". Qualifiers are
+// converted to numeric ids so that they compare with `getfacl -n` output.
+func parseACLSpec(spec string, withPerms bool) (ACLEntry, error) {
+	invalid := errors.New("Invalid ACL entry: " + spec)
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || (withPerms && len(parts) != 3) {
+		return ACLEntry{}, invalid
+	}
+
+	var entry ACLEntry
+	switch parts[0] {
+	case "u", "user":
+		entry.Tag = "user"
+	case "g", "group":
+		entry.Tag = "group"
+	case "m", "mask":
+		entry.Tag = "mask"
+	case "o", "other":
+		entry.Tag = "other"
+	default:
+		return ACLEntry{}, invalid
+	}
+
+	if qualifier := parts[1]; qualifier != "" {
+		if entry.Tag != "user" && entry.Tag != "group" {
+			return ACLEntry{}, invalid
+		}
+		id, err := lookupAccountID(qualifier, entry.Tag == "group")
+		if err != nil {
+			return ACLEntry{}, errors.New("Unknown " + entry.Tag + ": " + qualifier)
+		}
+		entry.Qualifier = strconv.Itoa(id)
+	} else if !withPerms && (entry.Tag == "user" || entry.Tag == "group") {
+		// 所有者・所有グループのエントリは削除できない
+		return ACLEntry{}, invalid
+	}
+
+	if withPerms {
+		perms := []byte("---")
+		for _, p := range parts[2] {
+			switch p {
+			case 'r':
+				perms[0] = 'r'
+			case 'w':
+				perms[1] = 'w'
+			case 'x':
+				perms[2] = 'x'
+			case '-':
+			default:
+				return ACLEntry{}, invalid
+			}
+		}
+		entry.Perms = string(perms)
+	}
+	return entry, nil
+}
+
+func joinACLEntries(entries []ACLEntry) string {
+	specs := make([]string, len(entries))
+	for i, e := range entries {
+		specs[i] = e.Tag + ":" + e.Qualifier
+		if e.Perms != "" {
+			specs[i] += ":" + e.Perms
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+// aclWouldChange reports whether applying modify and remove alters current
+func aclWouldChange(current, modify, remove []ACLEntry) bool {
+	find := func(e ACLEntry) *ACLEntry {
+		for i := range current {
+			if current[i].Tag == e.Tag && current[i].Qualifier == e.Qualifier {
+				return &current[i]
+			}
+		}
+		return nil
+	}
+	for _, e := range modify {
+		if existing := find(e); existing == nil || existing.Perms != e.Perms {
+			return true
+		}
+	}
+	for _, e := range remove {
+		if find(e) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readACLs runs getfacl on paths. numeric keeps uids and gids as numbers.
+func readACLs(paths []string, numeric bool) ([]FileACL, error) {
+	args := []string{"-p", "-P"}
+	if numeric {
+		args = append(args, "-n")
+	}
+	cmd := exec.Command("getfacl", append(append(args, "--"), paths...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	// 一部のファイルで失敗しても読めた分は返す
+	if err != nil && len(output) == 0 {
+		if stderr.Len() > 0 {
+			return nil, errors.New(strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return parseGetfacl(string(output)), nil
+}
+
+// parseGetfacl parses blocks like
+//
+//	# file: /srv/share
+//	# owner: root
+//	# group: dev
+//	user::rwx
+//	user:alice:rwx		#effective:r-x
+//	group::r-x
+//	mask::r-x
+//	other::---
+//	default:user::rwx
+func parseGetfacl(output string) []FileACL {
+	var acls []FileACL
+	var current *FileACL
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "# file: "); ok {
+			acls = append(acls, FileACL{Path: unescapeACLName(rest), Access: []ACLEntry{}, Default: []ACLEntry{}})
+			current = &acls[len(acls)-1]
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# owner: "); ok {
+			current.Owner = unescapeACLName(rest)
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# group: "); ok {
+			current.Group = unescapeACLName(rest)
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		spec, comment, _ := strings.Cut(line, "#")
+		isDefault := false
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(spec), "default:"); ok {
+			spec, isDefault = rest, true
+		}
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 3 {
+			continue
+		}
+		entry := ACLEntry{Tag: parts[0], Qualifier: unescapeACLName(parts[1]), Perms: parts[2]}
+		entry.Effective, _ = strings.CutPrefix(strings.TrimSpace(comment), "effective:")
+		if isDefault {
+			current.Default = append(current.Default, entry)
+		} else {
+			current.Access = append(current.Access, entry)
+		}
+	}
+	return acls
+}
+
+// unescapeACLName decodes the \ooo octal escapes getfacl uses for special characters
+func unescapeACLName(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func respondACLError(c *gin.Context, err error) {
+	if errors.Is(err, exec.ErrNotFound) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "getfacl/setfacl are not installed (acl package)"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// idNames caches uid and gid lookups while building a listing
+type idNames struct {
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func newIDNames() *idNames {
+	return &idNames{users: map[uint32]string{}, groups: map[uint32]string{}}
+}
+
+func (n *idNames) user(uid uint32) string {
+	if name, ok := n.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	n.users[uid] = name
+	return name
+}
+
+func (n *idNames) group(gid uint32) string {
+	if name, ok := n.groups[gid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	n.groups[gid] = name
+	return name
+}
+
+// newFileInfo builds the listing entry for info, found in dir
+func newFileInfo(jail *fileJail, dir string, info os.FileInfo, names *idNames) FileInfo {
+	entry := FileInfo{
+		Name:    info.Name(),
+		Path:    filepath.Join(dir, info.Name()),
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.Mode = fmt.Sprintf("%04o", st.Mode&07777)
+		entry.Permissions = lsPermissions(st.Mode)
+		entry.UID = st.Uid
+		entry.GID = st.Gid
+		entry.Owner = names.user(st.Uid)
+		entry.Group = names.group(st.Gid)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		entry.IsSymlink = true
+		entry.LinkTarget, _ = os.Readlink(entry.Path)
+	} else {
+		// Unixユーザーには自分でも読める user.* 属性だけを見せる
+		entry.Xattrs, entry.HasACL = readXattrs(entry.Path, jail.account == nil)
+		if entry.HasACL {
+			entry.Permissions += "+"
+		}
+	}
+	return entry
+}
+
+// lsPermissions formats st_mode like `ls -l`, e.g. "drwxr-sr-x"
+func lsPermissions(mode uint32) string {
+	b := []byte("?rwxrwxrwx")
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+		b[0] = '-'
+	case syscall.S_IFDIR:
+		b[0] = 'd'
+	case syscall.S_IFLNK:
+		b[0] = 'l'
+	case syscall.S_IFCHR:
+		b[0] = 'c'
+	case syscall.S_IFBLK:
+		b[0] = 'b'
+	case syscall.S_IFIFO:
+		b[0] = 'p'
+	case syscall.S_IFSOCK:
+		b[0] = 's'
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+
+	special := func(i int, set bool, lower byte) {
+		if !set {
+			return
+		}
+		if b[i] == 'x' {
+			b[i] = lower
+		} else {
+			b[i] = lower - 'a' + 'A'
+		}
+	}
+	special(3, mode&syscall.S_ISUID != 0, 's')
+	special(6, mode&syscall.S_ISGID != 0, 's')
+	special(9, mode&syscall.S_ISVTX != 0, 't')
+	return string(b)
+}
+
+// readXattrs returns the extended attributes of path and whether it carries a
+// POSIX ACL. ACL attributes are reported through the flag only, and values
+// that are not printable text are shown as hex.
+func readXattrs(path string, all bool) (map[string]string, bool) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, false
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, false
+	}
+
+	attrs := map[string]string{}
+	hasACL := false
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if strings.HasPrefix(name, "system.posix_acl_") {
+			hasACL = true
+			continue
+		}
+		if name == "" || (!all && !strings.HasPrefix(name, "user.")) {
+			continue
+		}
+
+		n, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(path, name, value); err != nil {
+			continue
+		}
+		attrs[name] = formatXattr(value[:n])
+	}
+
+	if len(attrs) == 0 {
+		attrs = nil
+	}
+	return attrs, hasACL
+}
+
+func formatXattr(value []byte) string {
+	value = bytes.TrimRight(value, "\x00")
+	truncated := len(value) > maxXattrValue
+	if truncated {
+		value = value[:maxXattrValue]
+	}
+
+	printable := utf8.Valid(value) && !bytes.ContainsFunc(value, func(r rune) bool {
+		return !unicode.IsPrint(r) && r != '\n' && r != '\t'
+	})
+	s := string(value)
+	if !printable {
+		s = "0x" + hex.EncodeToString(value)
+	}
+	if truncated {
+		s += "…"
+	}
+	return s
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChmod(t *testing.T) {
+	tests := []struct {
+		spec string
+		old  uint32
+		dir  bool
+		want uint32
+	}{
+		{"755", 0600, false, 0755},
+		{"0644", 0777, false, 0644},
+		{"4755", 0644, false, 04755},
+		{"u+x", 0644, false, 0744},
+		{"go-w", 0666, false, 0644},
+		{"a=r", 0777, false, 0444},
+		{"=rw", 0777, false, 0666},
+		{"+x", 0644, false, 0755},
+		{"u=rwx,g=rx,o=", 0000, false, 0750},
+		{"ug=rw", 0007, false, 0667},
+		{"u+x-w", 0644, false, 0544},
+		{"u=rw,u+x", 0000, false, 0700},
+		{"+X", 0644, false, 0644},
+		{"+X", 0744, false, 0755},
+		{"+X", 0644, true, 0755},
+		{"u+s,g+s", 0755, false, 06755},
+		{"u-s", 04755, false, 0755},
+		{"g=rx", 02775, false, 0755},
+		{"+t", 0777, true, 01777},
+		{"u+t", 0755, true, 0755},
+		{"o=", 01777, true, 0770},
+		{"o-t", 01777, true, 0777},
+		{"u+rwxXst", 0000, false, 04700},
+	}
+	for _, tt := range tests {
+		apply, err := parseChmod(tt.spec)
+		if err != nil {
+			t.Errorf("parseChmod(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got := apply(tt.old, tt.dir); got != tt.want {
+			t.Errorf("parseChmod(%q) on %04o (dir %v) = %04o, want %04o", tt.spec, tt.old, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestParseChmodInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"", "u", "ug", "8", "10000", "-1", "rwx", "z+x", "u+q", "u+x,", ",u+x", "u+x,,g+w", "u+x g+w", "u+x;rm",
+	} {
+		if _, err := parseChmod(spec); err == nil {
+			t.Errorf("parseChmod(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestParseACLSpec(t *testing.T) {
+	tests := []struct {
+		spec      string
+		withPerms bool
+		want      ACLEntry
+		wantErr   bool
+	}{
+		{spec: "u:root:rwx", withPerms: true, want: ACLEntry{Tag: "user", Qualifier: "0", Perms: "rwx"}},
+		{spec: "user:1000:-w-", withPerms: true, want: ACLEntry{Tag: "user", Qualifier: "1000", Perms: "-w-"}},
+		{spec: "g::r-x", withPerms: true, want: ACLEntry{Tag: "group", Perms: "r-x"}},
+		{spec: "m::rw", withPerms: true, want: ACLEntry{Tag: "mask", Perms: "rw-"}},
+		{spec: "o::r", withPerms: true, want: ACLEntry{Tag: "other", Perms: "r--"}},
+		{spec: "o::", withPerms: true, want: ACLEntry{Tag: "other", Perms: "---"}},
+		{spec: "u:root", want: ACLEntry{Tag: "user", Qualifier: "0"}},
+		{spec: "m:", want: ACLEntry{Tag: "mask"}},
+		{spec: "u:root", withPerms: true, wantErr: true},
+		{spec: "u:root:rwz", withPerms: true, wantErr: true},
+		{spec: "x::r", withPerms: true, wantErr: true},
+		{spec: "m:root:r", withPerms: true, wantErr: true},
+		{spec: "o:root:r", withPerms: true, wantErr: true},
+		{spec: "u:no-such-user-webos:r", withPerms: true, wantErr: true},
+		// the owner entries cannot be removed
+		{spec: "u:", wantErr: true},
+		{spec: "g:", wantErr: true},
+		// a second entry must not be smuggled into the setfacl argument
+		{spec: "u:root:r,o::rwx", withPerms: true, wantErr: true},
+		{spec: "u:root:r:x", withPerms: true, wantErr: true},
+		{spec: "u", withPerms: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseACLSpec(tt.spec, tt.withPerms)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseACLSpec(%q, %v) = %+v, want an error", tt.spec, tt.withPerms, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseACLSpec(%q, %v) failed: %v", tt.spec, tt.withPerms, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseACLSpec(%q, %v) = %+v, want %+v", tt.spec, tt.withPerms, got, tt.want)
+		}
+	}
+}
+
+func TestParseGetfacl(t *testing.T) {
+	output := `# file: /srv/share
+# owner: root
+# group: dev
+user::rwx
+user:alice:rwx			#effective:r-x
+group::r-x
+mask::r-x
+other::---
+default:user::rwx
+default:group:dev:rw-
+
+# file: /srv/with\040space
+# owner: bob\134x
+# group: bob
+user::rw-
+group::r--
+other::r--
+
+`
+	want := []FileACL{
+		{
+			Path:  "/srv/share",
+			Owner: "root",
+			Group: "dev",
+			Access: []ACLEntry{
+				{Tag: "user", Perms: "rwx"},
+				{Tag: "user", Qualifier: "alice", Perms: "rwx", Effective: "r-x"},
+				{Tag: "group", Perms: "r-x"},
+				{Tag: "mask", Perms: "r-x"},
+				{Tag: "other", Perms: "---"},
+			},
+			Default: []ACLEntry{
+				{Tag: "user", Perms: "rwx"},
+				{Tag: "group", Qualifier: "dev", Perms: "rw-"},
+			},
+		},
+		{
+			Path:  "/srv/with space",
+			Owner: `bob\x`,
+			Group: "bob",
+			Access: []ACLEntry{
+				{Tag: "user", Perms: "rw-"},
+				{Tag: "group", Perms: "r--"},
+				{Tag: "other", Perms: "r--"},
+			},
+			Default: []ACLEntry{},
+		},
+	}
+	if got := parseGetfacl(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGetfacl() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := parseGetfacl("user::rwx\nother::r--\n"); len(got) != 0 {
+		t.Errorf("entries before a # file: header were kept: %+v", got)
+	}
+}
+
+func TestUnescapeACLName(t *testing.T) {
+	tests := map[string]string{
+		"plain":        "plain",
+		`a\040b`:       "a b",
+		`a\134b`:       `a\b`,
+		`tab\011`:      "tab\t",
+		`short\04`:     `short\04`,
+		`not\999octal`: `not\999octal`,
+		`\`:            `\`,
+	}
+	for in, want := range tests {
+		if got := unescapeACLName(in); got != want {
+			t.Errorf("unescapeACLName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,584 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPreviewLines = 200
+	maxPreviewLines     = 2000
+	// A preview never reads more than this, however long the lines are
+	maxPreviewBytes      = 1 << 20
+	maxPreviewLineLength = 2000
+	previewToolTimeout   = 10 * time.Second
+	// EXIF lives near the start of a JPEG; give up past this point
+	maxExifScan = 256 << 10
+)
+
+// ImageMetadata describes an image; EXIF holds the common camera tags
+type ImageMetadata struct {
+	Width  int            `json:"width"`
+	Height int            `json:"height"`
+	Format string         `json:"format"`
+	EXIF   map[string]any `json:"exif,omitempty"`
+}
+
+// MediaMetadata describes an audio or video file as reported by ffprobe
+type MediaMetadata struct {
+	Duration float64       `json:"duration"` // seconds
+	Format   string        `json:"format"`
+	BitRate  int64         `json:"bit_rate,omitempty"`
+	Streams  []MediaStream `json:"streams"`
+}
+
+type MediaStream struct {
+	Type       string  `json:"type"` // video, audio or subtitle
+	Codec      string  `json:"codec"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	SampleRate int     `json:"sample_rate,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+}
+
+// TextPreview is the first or last lines of a text file
+type TextPreview struct {
+	From      string   `json:"from"` // head or tail
+	Lines     []string `json:"lines"`
+	Truncated bool     `json:"truncated"` // the file has more than what is shown
+}
+
+// GetFilePreview describes ?path= for the preview pane: its type, image
+// dimensions and EXIF, media duration and streams, PDF page count, and for
+// text files ?lines= lines (default 200) from the start, or from the end with
+// ?from=tail. Large files are never read in full.
+func GetFilePreview(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	path, err := jail.resolve(c.Query("path"), true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		respondFileError(c, err, "File not found")
+		return
+	}
+	if err := jail.canRead(path, info.IsDir()); err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+
+	from := c.DefaultQuery("from", "head")
+	if from != "head" && from != "tail" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from: " + from})
+		return
+	}
+	lines := defaultPreviewLines
+	if v := c.Query("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lines"})
+			return
+		}
+		lines = min(n, maxPreviewLines)
+	}
+
+	entry := FileInfo{Name: info.Name(), Path: path, Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}
+	detectContent(jail, &entry, info)
+	response := gin.H{
+		"path":          path,
+		"name":          entry.Name,
+		"size":          entry.Size,
+		"modTime":       entry.ModTime,
+		"mimeType":      entry.MimeType,
+		"kind":          entry.Kind,
+		"has_thumbnail": hasThumbnail(entry.MimeType),
+	}
+	if !info.Mode().IsRegular() {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	file, err := jail.openFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), previewToolTimeout)
+	defer cancel()
+
+	var notes []string
+	switch mimeType := entry.MimeType; {
+	case entry.Kind == "text":
+		preview, err := readTextPreview(file, info.Size(), lines, from == "tail")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		response["text"] = preview
+	case strings.HasPrefix(mimeType, "image/"):
+		metadata, err := readImageMetadata(ctx, jail, file, mimeType)
+		if err != nil {
+			notes = append(notes, err.Error())
+		} else {
+			response["image"] = metadata
+		}
+	case strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/"):
+		metadata, err := probeMedia(ctx, jail, file)
+		if err != nil {
+			notes = append(notes, err.Error())
+		} else {
+			response["media"] = metadata
+		}
+	case mimeType == "application/pdf":
+		document, err := readPDFInfo(ctx, jail, file)
+		if err != nil {
+			notes = append(notes, err.Error())
+		} else {
+			response["document"] = document
+		}
+	}
+	if len(notes) > 0 {
+		response["notes"] = notes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// hasThumbnail reports whether GetThumbnail can handle a MIME type here
+func hasThumbnail(mimeType string) bool {
+	switch {
+	case mimeType == "image/jpeg" || mimeType == "image/png" || mimeType == "image/gif":
+		return true
+	case mimeType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mimeType, "image/"):
+		_, err := lookTool("magick")
+		if err != nil {
+			_, err = lookTool("convert")
+		}
+		return err == nil
+	case mimeType == "application/pdf":
+		_, err := lookTool("pdftoppm")
+		return err == nil
+	case strings.HasPrefix(mimeType, "video/"):
+		_, err := lookTool("ffmpeg")
+		return err == nil
+	}
+	return false
+}
+
+// readTextPreview returns up to n lines from the start or the end of file.
+// The tail is read backwards in chunks, so only the end of a huge log is read.
+func readTextPreview(file *os.File, size int64, n int, tail bool) (TextPreview, error) {
+	if !tail {
+		preview := TextPreview{From: "head", Lines: []string{}}
+		reader := bufio.NewReader(io.LimitReader(file, maxPreviewBytes))
+		read := int64(0)
+		for len(preview.Lines) < n {
+			line, err := reader.ReadString('\n')
+			read += int64(len(line))
+			if line != "" {
+				preview.Lines = append(preview.Lines, previewLine(line))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return preview, err
+			}
+		}
+		preview.Truncated = read < size
+		return preview, nil
+	}
+
+	const chunk = 64 << 10
+	var data []byte
+	offset := size
+	// 末尾の改行の後ろは行として数えない
+	for offset > 0 && bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) < n && int64(len(data)) < maxPreviewBytes {
+		step := min(int64(chunk), offset)
+		offset -= step
+		buf := make([]byte, step)
+		if _, err := file.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return TextPreview{}, err
+		}
+		data = append(buf, data...)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	truncated := offset > 0
+	// 読んだ範囲の先頭行は途中から始まっている可能性がある
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+		truncated = true
+	}
+	preview := TextPreview{From: "tail", Lines: make([]string, 0, len(lines)), Truncated: truncated}
+	for _, line := range lines {
+		preview.Lines = append(preview.Lines, previewLine(line))
+	}
+	return preview, nil
+}
+
+func previewLine(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > maxPreviewLineLength {
+		return strings.ToValidUTF8(line[:maxPreviewLineLength], "") + "…"
+	}
+	return strings.ToValidUTF8(line, "�")
+}
+
+// readImageMetadata reads dimensions with the standard decoders, falling back
+// to ffprobe for other formats, and the EXIF tags of JPEGs
+func readImageMetadata(ctx context.Context, jail *fileJail, file *os.File, mimeType string) (*ImageMetadata, error) {
+	metadata := &ImageMetadata{Format: strings.TrimPrefix(mimeType, "image/")}
+	if config, format, err := image.DecodeConfig(file); err == nil {
+		metadata.Width, metadata.Height, metadata.Format = config.Width, config.Height, format
+	} else {
+		media, err := probeMedia(ctx, jail, file)
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range media.Streams {
+			if stream.Type == "video" {
+				metadata.Width, metadata.Height = stream.Width, stream.Height
+				break
+			}
+		}
+	}
+
+	if mimeType == "image/jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			if exif := readJPEGExif(file); len(exif.tags) > 0 {
+				metadata.EXIF = exif.tags
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// runPreviewTool runs a metadata tool on the already opened file, passed as
+// /dev/fd/3 with the reduced rights of the thumbnail converters
+func runPreviewTool(ctx context.Context, jail *fileJail, file *os.File, tool string, args ...string) ([]byte, error) {
+	bin, err := lookTool(tool)
+	if err != nil {
+		return nil, errors.New(tool + " is not installed")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: toolCredential(jail, file)}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(tool + " could not read the file")
+	}
+	return output, nil
+}
+
+// probeMedia reads duration and streams with ffprobe
+func probeMedia(ctx context.Context, jail *fileJail, file *os.File) (*MediaMetadata, error) {
+	output, err := runPreviewTool(ctx, jail, file, "ffprobe", "-v", "error", "-protocol_whitelist", "file,pipe", "-print_format", "json", "-show_format", "-show_streams", "/dev/fd/3")
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType  string `json:"codec_type"`
+			CodecName  string `json:"codec_name"`
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+			Channels   int    `json:"channels"`
+			SampleRate string `json:"sample_rate"`
+			Duration   string `json:"duration"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, errors.New("ffprobe returned unexpected output")
+	}
+
+	metadata := &MediaMetadata{Format: probe.Format.FormatName, Streams: []MediaStream{}}
+	metadata.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	metadata.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	for _, s := range probe.Streams {
+		stream := MediaStream{Type: s.CodecType, Codec: s.CodecName, Width: s.Width, Height: s.Height, Channels: s.Channels}
+		stream.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		stream.Duration, _ = strconv.ParseFloat(s.Duration, 64)
+		metadata.Streams = append(metadata.Streams, stream)
+	}
+	return metadata, nil
+}
+
+// readPDFInfo returns the page count and document fields reported by pdfinfo
+func readPDFInfo(ctx context.Context, jail *fileJail, file *os.File) (gin.H, error) {
+	output, err := runPreviewTool(ctx, jail, file, "pdfinfo", "/dev/fd/3")
+	if err != nil {
+		return nil, err
+	}
+	document := gin.H{}
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Pages":
+			document["pages"], _ = strconv.Atoi(value)
+		case "Title", "Author", "Creator", "Producer":
+			if value != "" {
+				document[strings.ToLower(key)] = value
+			}
+		case "Page size":
+			document["page_size"] = value
+		}
+	}
+	return document, nil
+}
+
+type exifData struct {
+	tags        map[string]any
+	orientation int
+}
+
+// EXIF tags reported in previews, by IFD
+var (
+	exifIFD0Tags = map[uint16]string{
+		0x010F: "Make",
+		0x0110: "Model",
+		0x0112: "Orientation",
+		0x0131: "Software",
+		0x0132: "DateTime",
+	}
+	exifSubIFDTags = map[uint16]string{
+		0x829A: "ExposureTime",
+		0x829D: "FNumber",
+		0x8827: "ISO",
+		0x9003: "DateTimeOriginal",
+		0x920A: "FocalLength",
+		0xA002: "PixelXDimension",
+		0xA003: "PixelYDimension",
+		0xA434: "LensModel",
+	}
+	exifGPSTags = map[uint16]string{
+		0x0001: "GPSLatitudeRef",
+		0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef",
+		0x0004: "GPSLongitude",
+		0x0006: "GPSAltitude",
+	}
+)
+
+const (
+	exifSubIFDPointer = 0x8769
+	exifGPSPointer    = 0x8825
+)
+
+// readJPEGExif finds the APP1 Exif segment of a JPEG and decodes the tags
+// above. Malformed data yields whatever could be read before it.
+func readJPEGExif(r io.Reader) exifData {
+	result := exifData{tags: map[string]any{}, orientation: 1}
+	reader := bufio.NewReader(io.LimitReader(r, maxExifScan))
+	var soi [2]byte
+	if _, err := io.ReadFull(reader, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return result
+	}
+
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(reader, marker[:]); err != nil || marker[0] != 0xFF {
+			return result
+		}
+		// SOS以降は画像データなのでEXIFはない
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return result
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return result
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return result
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			parseTIFFExif(segment[6:], &result)
+			return result
+		}
+	}
+}
+
+// parseTIFFExif walks IFD0 and its Exif and GPS sub-IFDs
+func parseTIFFExif(data []byte, result *exifData) {
+	if len(data) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return
+	}
+
+	ifd0 := readIFD(data, order, order.Uint32(data[4:]))
+	for tag, name := range exifIFD0Tags {
+		if value, ok := ifd0[tag]; ok {
+			result.tags[name] = value
+		}
+	}
+	if v, ok := ifd0[0x0112].(uint64); ok {
+		result.orientation = int(v)
+	}
+	if offset, ok := ifd0[exifSubIFDPointer].(uint64); ok {
+		sub := readIFD(data, order, uint32(offset))
+		for tag, name := range exifSubIFDTags {
+			if value, ok := sub[tag]; ok {
+				result.tags[name] = value
+			}
+		}
+	}
+	if offset, ok := ifd0[exifGPSPointer].(uint64); ok {
+		gps := readIFD(data, order, uint32(offset))
+		named := map[string]any{}
+		for tag, name := range exifGPSTags {
+			if value, ok := gps[tag]; ok {
+				named[name] = value
+			}
+		}
+		if lat, ok := gpsCoordinate(named["GPSLatitude"], named["GPSLatitudeRef"], "S"); ok {
+			result.tags["GPSLatitude"] = lat
+		}
+		if lon, ok := gpsCoordinate(named["GPSLongitude"], named["GPSLongitudeRef"], "W"); ok {
+			result.tags["GPSLongitude"] = lon
+		}
+		if alt, ok := named["GPSAltitude"].(float64); ok {
+			result.tags["GPSAltitude"] = alt
+		}
+	}
+}
+
+// gpsCoordinate turns degrees, minutes and seconds into signed decimal degrees
+func gpsCoordinate(value, ref any, negative string) (float64, bool) {
+	dms, ok := value.([]any)
+	if !ok || len(dms) != 3 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i, part := range dms {
+		if parts[i], ok = part.(float64); !ok {
+			return 0, false
+		}
+	}
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref == negative {
+		degrees = -degrees
+	}
+	return math.Round(degrees*1e6) / 1e6, true
+}
+
+// readIFD decodes the entries of one IFD. Integers become uint64 (int64 when
+// signed), rationals float64 and strings string; entries with several values
+// become []any.
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) map[uint16]any {
+	entries := map[uint16]any{}
+	if int(offset)+2 > len(data) {
+		return entries
+	}
+	count := int(order.Uint16(data[offset:]))
+	sizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + 12*i
+		if start+12 > len(data) {
+			break
+		}
+		entry := data[start : start+12]
+		tag, typ, n := order.Uint16(entry), order.Uint16(entry[2:]), int(order.Uint32(entry[4:]))
+		size, ok := sizes[typ]
+		if !ok || n <= 0 || n > len(data) {
+			continue
+		}
+		raw := entry[8:12]
+		if size*n > 4 {
+			at := int(order.Uint32(entry[8:]))
+			if at < 0 || at+size*n > len(data) {
+				continue
+			}
+			raw = data[at : at+size*n]
+		}
+
+		if typ == 2 {
+			entries[tag] = strings.TrimSpace(strings.TrimRight(string(raw[:n]), "\x00"))
+			continue
+		}
+		values := make([]any, 0, n)
+		for k := 0; k < n; k++ {
+			v := raw[k*size:]
+			switch typ {
+			case 1, 7:
+				values = append(values, uint64(v[0]))
+			case 3:
+				values = append(values, uint64(order.Uint16(v)))
+			case 4:
+				values = append(values, uint64(order.Uint32(v)))
+			case 9:
+				values = append(values, int64(int32(order.Uint32(v))))
+			case 5, 10:
+				num, den := float64(order.Uint32(v)), float64(order.Uint32(v[4:]))
+				if typ == 10 {
+					num, den = float64(int32(order.Uint32(v))), float64(int32(order.Uint32(v[4:])))
+				}
+				if den == 0 {
+					values = append(values, float64(0))
+				} else {
+					values = append(values, num/den)
+				}
+			}
+		}
+		if len(values) == 1 {
+			entries[tag] = values[0]
+		} else {
+			entries[tag] = values
+		}
+	}
+	return entries
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testIFDEntry struct {
+	tag, typ uint16
+	count    int
+	value    []byte
+}
+
+type testByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// testTIFF builds a TIFF body one IFD at a time. Values longer than four
+// bytes are stored right after the IFD that uses them.
+type testTIFF struct {
+	order testByteOrder
+	data  []byte
+}
+
+func newTestTIFF(order testByteOrder) *testTIFF {
+	t := &testTIFF{order: order, data: make([]byte, 8)}
+	if order == binary.LittleEndian {
+		copy(t.data, "II")
+	} else {
+		copy(t.data, "MM")
+	}
+	order.PutUint16(t.data[2:], 42)
+	return t
+}
+
+// ifd appends an IFD and returns its offset
+func (t *testTIFF) ifd(entries ...testIFDEntry) uint32 {
+	offset := len(t.data)
+	extra := offset + 2 + 12*len(entries) + 4
+	t.data = t.order.AppendUint16(t.data, uint16(len(entries)))
+	var values []byte
+	for _, e := range entries {
+		t.data = t.order.AppendUint16(t.data, e.tag)
+		t.data = t.order.AppendUint16(t.data, e.typ)
+		t.data = t.order.AppendUint32(t.data, uint32(e.count))
+		if len(e.value) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, e.value)
+			t.data = append(t.data, inline...)
+		} else {
+			t.data = t.order.AppendUint32(t.data, uint32(extra+len(values)))
+			values = append(values, e.value...)
+		}
+	}
+	t.data = t.order.AppendUint32(t.data, 0)
+	t.data = append(t.data, values...)
+	return uint32(offset)
+}
+
+// root makes ifd the first IFD and returns the finished body
+func (t *testTIFF) root(ifd uint32) []byte {
+	t.order.PutUint32(t.data[4:], ifd)
+	return t.data
+}
+
+func (t *testTIFF) ascii(tag uint16, s string) testIFDEntry {
+	return testIFDEntry{tag, 2, len(s) + 1, []byte(s + "\x00")}
+}
+
+func (t *testTIFF) short(tag uint16, v uint16) testIFDEntry {
+	return testIFDEntry{tag, 3, 1, t.order.AppendUint16(nil, v)}
+}
+
+func (t *testTIFF) long(tag uint16, v uint32) testIFDEntry {
+	return testIFDEntry{tag, 4, 1, t.order.AppendUint32(nil, v)}
+}
+
+// rational takes numerator and denominator pairs
+func (t *testTIFF) rational(tag uint16, signed bool, pairs ...int32) testIFDEntry {
+	var value []byte
+	for _, v := range pairs {
+		value = t.order.AppendUint32(value, uint32(v))
+	}
+	typ := uint16(5)
+	if signed {
+		typ = 10
+	}
+	return testIFDEntry{tag, typ, len(pairs) / 2, value}
+}
+
+// sampleTIFF has camera tags in IFD0 and both sub-IFDs
+func sampleTIFF(order testByteOrder) []byte {
+	t := newTestTIFF(order)
+	gps := t.ifd(
+		t.ascii(0x0001, "N"),
+		t.rational(0x0002, false, 35, 1, 30, 1, 36, 1),
+		t.ascii(0x0003, "W"),
+		t.rational(0x0004, false, 139, 1, 45, 1, 0, 1),
+		t.rational(0x0006, false, 100, 2),
+	)
+	sub := t.ifd(
+		t.rational(0x829A, false, 1, 250),
+		t.rational(0x829D, false, 28, 10),
+		t.short(0x8827, 400),
+		t.ascii(0x9003, "2024:05:01 12:00:00"),
+		// not reported
+		t.short(0x9209, 16),
+	)
+	return t.root(t.ifd(
+		t.ascii(0x010F, "Canon"),
+		t.ascii(0x0110, "R5"),
+		t.short(0x0112, 6),
+		t.long(exifSubIFDPointer, sub),
+		t.long(exifGPSPointer, gps),
+	))
+}
+
+var sampleEXIFTags = map[string]any{
+	"Make":             "Canon",
+	"Model":            "R5",
+	"Orientation":      uint64(6),
+	"ExposureTime":     0.004,
+	"FNumber":          2.8,
+	"ISO":              uint64(400),
+	"DateTimeOriginal": "2024:05:01 12:00:00",
+	"GPSLatitude":      35.51,
+	"GPSLongitude":     -139.75,
+	"GPSAltitude":      50.0,
+}
+
+// testJPEG wraps body in an APP1 Exif segment, after an APP0 segment
+func testJPEG(body []byte) []byte {
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00}
+	segment := append([]byte("Exif\x00\x00"), body...)
+	jpeg = append(jpeg, 0xFF, 0xE1)
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+}
+
+func TestParseTIFFExif(t *testing.T) {
+	for _, order := range []testByteOrder{binary.LittleEndian, binary.BigEndian} {
+		result := exifData{tags: map[string]any{}, orientation: 1}
+		parseTIFFExif(sampleTIFF(order), &result)
+		if !reflect.DeepEqual(result.tags, sampleEXIFTags) {
+			t.Errorf("%v: tags = %v, want %v", order, result.tags, sampleEXIFTags)
+		}
+		if result.orientation != 6 {
+			t.Errorf("%v: orientation = %d, want 6", order, result.orientation)
+		}
+	}
+}
+
+func TestReadJPEGExif(t *testing.T) {
+	result := readJPEGExif(bytes.NewReader(testJPEG(sampleTIFF(binary.BigEndian))))
+	if !reflect.DeepEqual(result.tags, sampleEXIFTags) || result.orientation != 6 {
+		t.Errorf("readJPEGExif() = %v, orientation %d", result.tags, result.orientation)
+	}
+
+	sample := testJPEG(sampleTIFF(binary.LittleEndian))
+	malformed := map[string][]byte{
+		"empty":            nil,
+		"not a JPEG":       []byte("GIF89a"),
+		"only SOI":         {0xFF, 0xD8},
+		"bad marker":       {0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x10},
+		"short length":     {0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01},
+		"truncated":        sample[:len(sample)/2],
+		"image data first": append([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, sample[2:]...),
+		"no Exif header":   {0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x06, 'X', 'M', 'P', 0x00, 0xFF, 0xD9},
+		"bad TIFF order":   testJPEG([]byte("XX\x00\x2a\x08\x00\x00\x00")),
+		"bad TIFF magic":   testJPEG([]byte("II\x2b\x00\x08\x00\x00\x00")),
+	}
+	for name, data := range malformed {
+		result := readJPEGExif(bytes.NewReader(data))
+		if len(result.tags) != 0 || result.orientation != 1 {
+			t.Errorf("%s: readJPEGExif() = %v, orientation %d; want nothing", name, result.tags, result.orientation)
+		}
+	}
+}
+
+func TestParseTIFFExifTruncated(t *testing.T) {
+	// every prefix must decode without panicking, keeping what fits
+	for _, order := range []testByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := sampleTIFF(order)
+		for n := 0; n <= len(data); n++ {
+			result := exifData{tags: map[string]any{}, orientation: 1}
+			parseTIFFExif(data[:n], &result)
+			for name, value := range result.tags {
+				if !reflect.DeepEqual(value, sampleEXIFTags[name]) {
+					t.Errorf("%v, %d bytes: %s = %v, want %v", order, n, name, value, sampleEXIFTags[name])
+				}
+			}
+		}
+	}
+}
+
+func TestReadIFD(t *testing.T) {
+	order := binary.LittleEndian
+	tiff := newTestTIFF(order)
+	ifd := tiff.ifd(
+		tiff.rational(1, true, -1, 2),
+		tiff.rational(2, false, 5, 0),
+		testIFDEntry{3, 1, 3, []byte{7, 8, 9}},
+		testIFDEntry{4, 9, 1, order.AppendUint32(nil, 0xFFFFFFFE)},
+		tiff.ascii(5, "  padded  "),
+		// unknown type
+		testIFDEntry{6, 99, 1, []byte{1}},
+		// zero count
+		testIFDEntry{7, 3, 0, nil},
+		// count far beyond the data
+		testIFDEntry{8, 4, 1 << 30, nil},
+		tiff.short(9, 42),
+	)
+	data := tiff.root(ifd)
+
+	want := map[uint16]any{
+		1: -0.5,
+		2: float64(0),
+		3: []any{uint64(7), uint64(8), uint64(9)},
+		4: int64(-2),
+		5: "padded",
+		9: uint64(42),
+	}
+	if got := readIFD(data, order, ifd); !reflect.DeepEqual(got, want) {
+		t.Errorf("readIFD() = %v, want %v", got, want)
+	}
+
+	// entry 5 points past the end once its value is cut off
+	if got := readIFD(data[:len(data)-4], order, ifd); got[5] != nil || got[9] != uint64(42) {
+		t.Errorf("readIFD() of a cut value = %v", got)
+	}
+	for _, offset := range []uint32{uint32(len(data)), uint32(len(data) - 1), 0xFFFFFFFF} {
+		if got := readIFD(data, order, offset); len(got) != 0 {
+			t.Errorf("readIFD() at %d = %v, want nothing", offset, got)
+		}
+	}
+}
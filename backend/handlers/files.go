@@ -1,41 +1,79 @@
 package handlers
 
 import (
-	"io/ioutil"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type FileInfo struct {
-	Name    string    `json:"name"`
-	Path    string    `json:"path"`
-	IsDir   bool      `json:"isDir"`
-	Size    int64     `json:"size"`
-	ModTime time.Time `json:"modTime"`
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	IsDir       bool              `json:"isDir"`
+	Size        int64             `json:"size"`
+	ModTime     time.Time         `json:"modTime"`
+	Mode        string            `json:"mode"`        // octal, e.g. "0755"
+	Permissions string            `json:"permissions"` // ls -l style, e.g. "drwxr-xr-x+"
+	Owner       string            `json:"owner"`
+	Group       string            `json:"group"`
+	UID         uint32            `json:"uid"`
+	GID         uint32            `json:"gid"`
+	IsSymlink   bool              `json:"isSymlink"`
+	LinkTarget  string            `json:"linkTarget,omitempty"`
+	HasACL      bool              `json:"hasAcl"`
+	Xattrs      map[string]string `json:"xattrs,omitempty"`
+	MimeType    string            `json:"mimeType"`
+	Kind        string            `json:"kind"` // text, binary, directory, symlink, special or unknown
 }
 
-// GetFileList returns a list of files in the specified directory
+// GetFileList returns one page of the files in the specified directory.
+//
+//	sort=        name (default), size, mtime or type
+//	order=       asc (default) or desc
+//	dirs_first=  false to mix directories with files
+//	hidden=true  include dotfiles
+//	filter=      case-insensitive substring, or a glob when it contains *?[
+//	limit=       entries per page (default 500, at most 5000)
+//	cursor=      next_cursor of the previous page
 func GetFileList(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	query, err := parseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	path := c.Query("path")
 	if path == "" {
 		path = "/home"
+		if jail.check(path) != nil {
+			path = jail.roots[0]
+		}
 	}
 
-	// パスの検証（セキュリティ対策）
-	absPath, err := filepath.Abs(path)
+	// パスの検証（ルート外・シンボリックリンク経由の脱出を防ぐ）
+	absPath, err := jail.resolve(path, true)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		respondFileError(c, err, "Invalid path")
 		return
 	}
 
 	// ディレクトリが存在するか確認
 	fileInfo, err := os.Stat(absPath)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Directory not found"})
+		respondFileError(c, err, "Directory not found")
 		return
 	}
 
@@ -44,34 +82,49 @@ func GetFileList(c *gin.Context) {
 		return
 	}
 
+	if err := jail.canRead(absPath, true); err != nil {
+		respondFileError(c, err, "Failed to read directory")
+		return
+	}
+
 	// ディレクトリ内のファイル一覧を取得
-	files, err := ioutil.ReadDir(absPath)
+	dir, err := jail.openFile(absPath, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		respondFileError(c, err, "Failed to read directory")
+		return
+	}
+	defer dir.Close()
+
+	entries, more, total, err := query.readPage(dir)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read directory"})
 		return
 	}
 
-	// ファイル情報を構造体に変換
-	var fileList []FileInfo
-	for _, file := range files {
-		// 隠しファイルはスキップ（オプション）
-		if file.Name()[0] == '.' {
-			continue
+	// ファイル情報を構造体に変換（詳細はこのページの分だけ取得する）
+	fileList := make([]FileInfo, 0, len(entries))
+	names := newIDNames()
+	for _, entry := range entries {
+		info := entry.info
+		if info == nil {
+			if info, err = os.Lstat(filepath.Join(absPath, entry.name)); err != nil {
+				continue
+			}
 		}
-
-		fileList = append(fileList, FileInfo{
-			Name:    file.Name(),
-			Path:    filepath.Join(absPath, file.Name()),
-			IsDir:   file.IsDir(),
-			Size:    file.Size(),
-			ModTime: file.ModTime(),
-		})
+		file := newFileInfo(jail, absPath, info, names)
+		detectContent(jail, &file, info)
+		fileList = append(fileList, file)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"path":  absPath,
 		"files": fileList,
-	})
+		"total": total,
+	}
+	if more {
+		response["next_cursor"] = query.cursor(entries[len(entries)-1])
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // GetFileContent returns the content of a file
@@ -82,17 +135,22 @@ func GetFileContent(c *gin.Context) {
 		return
 	}
 
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
 	// パスの検証
-	absPath, err := filepath.Abs(path)
+	absPath, err := jail.resolve(path, true)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		respondFileError(c, err, "Invalid path")
 		return
 	}
 
 	// ファイルが存在するか確認
 	fileInfo, err := os.Stat(absPath)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		respondFileError(c, err, "File not found")
 		return
 	}
 
@@ -107,18 +165,32 @@ func GetFileContent(c *gin.Context) {
 		return
 	}
 
+	if err := jail.canRead(absPath, false); err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+
 	// ファイルの内容を読み込む
-	content, err := ioutil.ReadFile(absPath)
+	file, err := jail.openFile(absPath, os.O_RDONLY, 0)
+	if err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
 		return
 	}
 
+	c.Header("ETag", fileETag(fileInfo))
 	c.JSON(http.StatusOK, gin.H{
 		"path":    absPath,
 		"content": string(content),
 		"size":    fileInfo.Size(),
 		"modTime": fileInfo.ModTime(),
+		"etag":    fileETag(fileInfo),
 	})
 }
 
@@ -139,17 +211,28 @@ func CreateDirectory(c *gin.Context) {
 		return
 	}
 
+	// 名前にパス区切りを含めてルート外に作成されるのを防ぐ
+	if strings.Contains(request.Name, "/") || request.Name == "." || request.Name == ".." {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid directory name"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
 	// パスの検証
-	absPath, err := filepath.Abs(request.Path)
+	absPath, err := jail.resolve(request.Path, true)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		respondFileError(c, err, "Invalid path")
 		return
 	}
 
 	// 親ディレクトリが存在するか確認
 	parentInfo, err := os.Stat(absPath)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Parent directory not found"})
+		respondFileError(c, err, "Parent directory not found")
 		return
 	}
 
@@ -160,13 +243,18 @@ func CreateDirectory(c *gin.Context) {
 
 	// 新しいディレクトリのパス
 	newDirPath := filepath.Join(absPath, request.Name)
+	if err := jail.canWrite(newDirPath); err != nil {
+		respondFileError(c, err, "Failed to create directory")
+		return
+	}
 
 	// ディレクトリを作成
 	err = os.Mkdir(newDirPath, 0755)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create directory"})
+		respondFileError(c, err, "Failed to create directory")
 		return
 	}
+	jail.chownToAccount(newDirPath)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Directory created successfully",
@@ -182,30 +270,57 @@ func DeleteFile(c *gin.Context) {
 		return
 	}
 
-	// パスの検証
-	absPath, err := filepath.Abs(path)
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	// パスの検証（シンボリックリンクはリンク自体を削除する）
+	absPath, err := jail.resolve(path, false)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		respondFileError(c, err, "Invalid path")
 		return
 	}
 
 	// ファイルまたはディレクトリが存在するか確認
-	fileInfo, err := os.Stat(absPath)
+	fileInfo, err := os.Lstat(absPath)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File or directory not found"})
+		respondFileError(c, err, "File or directory not found")
 		return
 	}
 
-	// 削除処理
-	var removeErr error
-	if fileInfo.IsDir() {
-		removeErr = os.RemoveAll(absPath) // ディレクトリとその中身を再帰的に削除
-	} else {
-		removeErr = os.Remove(absPath) // ファイルを削除
+	// 保護されたパスは削除できない
+	if err := jail.canDelete(absPath); err != nil {
+		respondFileError(c, err, "Failed to delete")
+		return
+	}
+
+	// 既定ではゴミ箱へ移動する（ゴミ箱の中身は完全に削除する）
+	if c.Query("permanent") != "true" {
+		trash, err := newUserTrash(jail)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to locate the trash: " + err.Error()})
+			return
+		}
+		if !trash.contains(absPath) {
+			item, err := trash.move(absPath, fileInfo)
+			if err != nil {
+				respondTrashError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "Moved to trash",
+				"path":    absPath,
+				"isDir":   fileInfo.IsDir(),
+				"trash":   item,
+			})
+			return
+		}
 	}
 
-	if removeErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+	// 削除処理（ディレクトリの中身もすべて削除権限を確認してから削除する）
+	if removeErr := jail.removeAll(absPath); removeErr != nil {
+		respondFileError(c, removeErr, "Failed to delete")
 		return
 	}
 
@@ -216,11 +331,18 @@ func DeleteFile(c *gin.Context) {
 	})
 }
 
-// SaveFileContent saves content to a file
+// SaveFileContent saves content to a file. When the request carries the etag
+// or modTime returned by GetFileContent (or an If-Match header), the save is
+// rejected with 409 if the file was changed in the meantime. Known
+// configuration files are validated first: system configuration is rejected
+// with 422, other formats are saved and their issues returned.
 func SaveFileContent(c *gin.Context) {
 	var request struct {
-		Path    string `json:"path"`
-		Content string `json:"content"`
+		Path    string     `json:"path"`
+		Content string     `json:"content"`
+		ETag    string     `json:"etag"`
+		ModTime *time.Time `json:"modTime"`
+		Force   bool       `json:"force"` // save despite failed validation (admins only)
 	}
 
 	if err := c.BindJSON(&request); err != nil {
@@ -232,30 +354,219 @@ func SaveFileContent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
 		return
 	}
+	if request.ETag == "" {
+		request.ETag = c.GetHeader("If-Match")
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
 
 	// パスの検証
-	absPath, err := filepath.Abs(request.Path)
+	absPath, err := jail.resolve(request.Path, true)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		respondFileError(c, err, "Invalid path")
 		return
 	}
 
-	// ファイルが存在するか確認
-	_, err = os.Stat(absPath)
-	if err != nil && !os.IsNotExist(err) {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check file"})
+	if err := jail.canWrite(absPath); err != nil {
+		respondFileError(c, err, "Failed to write file")
+		return
+	}
+
+	// sudoers、fstab等の既知の設定ファイルは保存前に検証する
+	validation, ok := checkBeforeSave(c, jail, absPath, []byte(request.Content), request.Force)
+	if !ok {
 		return
 	}
 
-	// ファイルに書き込む
-	err = ioutil.WriteFile(absPath, []byte(request.Content), 0644)
+	info, version, err := jail.saveFile(absPath, []byte(request.Content), request.ETag, request.ModTime)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write file"})
+		respondSaveError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "File saved successfully",
-		"path":    absPath,
+		"message":    "File saved successfully",
+		"path":       absPath,
+		"etag":       fileETag(info),
+		"modTime":    info.ModTime(),
+		"size":       info.Size(),
+		"version":    version,    // empty when no previous version was kept
+		"validation": validation, // null for files without a validator
 	})
 }
+
+var errNotRegularFile = errors.New("not a regular file")
+
+// fileChangedError is returned when a file no longer matches the version the
+// client loaded; info is nil when the file was deleted
+type fileChangedError struct {
+	info os.FileInfo
+}
+
+func (e *fileChangedError) Error() string {
+	return "the file was changed since it was opened"
+}
+
+// ファイル保存のチェックと置き換えを直列化する
+var fileSaveLock sync.Mutex
+
+// saveFile replaces the content of real. The content is written to a temporary
+// file beside it that is renamed over the original, so the file is never seen
+// half written; mode, owner and extended attributes (including ACLs) are
+// carried over. Hard-linked files, and files whose directory or owner rules
+// out a rename, are rewritten in place like editors do. The previous content
+// is kept as a version.
+func (j *fileJail) saveFile(real string, content []byte, etag string, modTime *time.Time) (os.FileInfo, string, error) {
+	fileSaveLock.Lock()
+	defer fileSaveLock.Unlock()
+
+	info, err := os.Stat(real)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	exists := err == nil
+	if exists && !info.Mode().IsRegular() {
+		return nil, "", errNotRegularFile
+	}
+	if etag != "" || modTime != nil {
+		if !exists {
+			return nil, "", &fileChangedError{}
+		}
+		if (etag != "" && etag != fileETag(info)) || (modTime != nil && !modTime.Equal(info.ModTime())) {
+			return nil, "", &fileChangedError{info: info}
+		}
+	}
+
+	var version string
+	if exists {
+		version = j.keepVersion(real, info, content)
+		replaced := false
+		if j.canReplace(real, info) {
+			err = j.replaceFile(real, content, info)
+			replaced = err == nil
+		}
+		// 置き換えできない場合（所有者を引き継げない等）はその場で書き換える
+		if !replaced && (err == nil || errors.Is(err, os.ErrPermission)) {
+			err = j.writeInPlace(real, content)
+		}
+	} else {
+		if err = j.replaceFile(real, content, nil); err == nil {
+			j.chownToAccount(real)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	info, err = os.Stat(real)
+	return info, version, err
+}
+
+// canReplace reports whether the file can be replaced by a rename without
+// splitting hard links or going around the user's directory permissions
+func (j *fileJail) canReplace(real string, info os.FileInfo) bool {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+		return false
+	}
+	return j.checkPermission(filepath.Dir(real), 2|1, real) == nil
+}
+
+// replaceFile writes content to a temporary file and renames it over real.
+// info describes the file being replaced, or is nil for a new file.
+func (j *fileJail) replaceFile(real string, content []byte, info os.FileInfo) error {
+	tmp, err := os.CreateTemp(filepath.Dir(real), "."+filepath.Base(real)+".webos-save-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	err = func() error {
+		if _, err := tmp.Write(content); err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if info != nil {
+			// chownはsetuidビットを落とすため、モードより先に設定する
+			if st, ok := info.Sys().(*syscall.Stat_t); ok {
+				if err := tmp.Chown(int(st.Uid), int(st.Gid)); err != nil {
+					return err
+				}
+			}
+			copyXattrs(real, tmpName)
+			mode = j.preservedMode(info.Mode())
+		}
+		if err := tmp.Chmod(mode); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, real)
+}
+
+func (j *fileJail) writeInPlace(real string, content []byte) error {
+	file, err := j.openFile(real, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyXattrs copies every extended attribute the server can read; security
+// labels and ACLs are among them. Failures are ignored.
+func copyXattrs(from, to string) {
+	size, err := syscall.Listxattr(from, nil)
+	if err != nil || size == 0 {
+		return
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(from, buf); err != nil {
+		return
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		n, err := syscall.Getxattr(from, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(from, name, value); err == nil {
+			syscall.Setxattr(to, name, value[:n], 0)
+		}
+	}
+}
+
+// respondSaveError reports a conflicting save together with the file's current state
+func respondSaveError(c *gin.Context, err error) {
+	var changed *fileChangedError
+	switch {
+	case errors.As(err, &changed) && changed.info == nil:
+		c.JSON(http.StatusConflict, gin.H{"error": "The file was deleted since it was opened", "deleted": true})
+	case errors.As(err, &changed):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "The file was changed since it was opened",
+			"etag":    fileETag(changed.info),
+			"modTime": changed.info.ModTime(),
+			"size":    changed.info.Size(),
+		})
+	case errors.Is(err, errNotRegularFile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is not a regular file"})
+	default:
+		respondFileError(c, err, "Failed to write file")
+	}
+}
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type SearchMatch struct {
+	Type    string       `json:"type"` // always "match"
+	Path    string       `json:"path"`
+	Name    string       `json:"name"`
+	IsDir   bool         `json:"isDir"`
+	Size    int64        `json:"size"`
+	ModTime time.Time    `json:"modTime"`
+	Lines   []SearchLine `json:"lines,omitempty"` // content matches
+}
+
+type SearchLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+const (
+	defaultSearchResults = 1000
+	maxSearchResults     = 10000
+	maxSearchFileSize    = 32 * 1024 * 1024
+	maxSearchLines       = 5 // matching lines reported per file
+	maxSearchLineLength  = 300
+	binarySniffSize      = 8000
+)
+
+// Pseudo filesystems are never searched; their files are endless or expensive to read
+var searchSkipDirs = map[string]bool{
+	"/proc": true,
+	"/sys":  true,
+	"/dev":  true,
+}
+
+type fileSearch struct {
+	root          string
+	name          string
+	globs         []string
+	content       *regexp.Regexp
+	caseSensitive bool
+	minSize       int64
+	maxSize       int64
+	after         time.Time
+	before        time.Time
+	fileType      string // file, dir or empty for both
+	hidden        bool
+	maxDepth      int
+	maxResults    int
+
+	dirs  atomic.Int64
+	files atomic.Int64
+}
+
+// SearchFiles streams files below ?path= matching every given criterion over
+// a WebSocket:
+//
+//	name=           substring of the file name
+//	glob=           shell patterns for the file name, comma separated
+//	content=        regular expression matched line by line; binaries are skipped
+//	case_sensitive= applies to name, glob and content (default false)
+//	min_size=, max_size=               bytes
+//	modified_after=, modified_before=  RFC 3339 or YYYY-MM-DD
+//	type=           file or dir
+//	hidden=true     include dotfiles
+//	max_depth=, max_results=
+//
+// The server sends "match" messages as they are found, "progress" messages
+// twice a second and a final "done". Sending {"type": "cancel"} or closing the
+// socket stops the search.
+func SearchFiles(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	path := c.Query("path")
+	if path == "" {
+		path = jail.roots[0]
+	}
+	root, err := jail.resolve(path, true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	if info, err := os.Stat(root); err != nil {
+		respondFileError(c, err, "Directory not found")
+		return
+	} else if !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is not a directory"})
+		return
+	}
+	if err := jail.canRead(root, true); err != nil {
+		respondFileError(c, err, "Failed to read directory")
+		return
+	}
+
+	search, err := parseFileSearch(c, root)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "WebSocket upgrade failed"})
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	var cancelled atomic.Bool
+	go func() {
+		defer cancel()
+		for {
+			var message struct {
+				Type string `json:"type"`
+			}
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			if message.Type == "cancel" {
+				cancelled.Store(true)
+				return
+			}
+		}
+	}()
+
+	started := time.Now()
+	results := make(chan SearchMatch, 64)
+	go func() {
+		search.walk(ctx, jail, results)
+		close(results)
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	matches, truncated := 0, false
+	for done := false; !done; {
+		select {
+		case match, ok := <-results:
+			if !ok {
+				done = true
+				break
+			}
+			// 上限到達後は残りを読み捨てる
+			if matches >= search.maxResults {
+				continue
+			}
+			if err := conn.WriteJSON(match); err != nil {
+				cancel()
+				continue
+			}
+			matches++
+			if matches == search.maxResults {
+				truncated = true
+				cancel()
+			}
+		case <-ticker.C:
+			conn.WriteJSON(gin.H{
+				"type":          "progress",
+				"scanned_dirs":  search.dirs.Load(),
+				"scanned_files": search.files.Load(),
+			})
+		}
+	}
+
+	conn.WriteJSON(gin.H{
+		"type":          "done",
+		"matches":       matches,
+		"scanned_dirs":  search.dirs.Load(),
+		"scanned_files": search.files.Load(),
+		"truncated":     truncated,
+		"cancelled":     cancelled.Load(),
+		"elapsed_ms":    time.Since(started).Milliseconds(),
+	})
+}
+
+func parseFileSearch(c *gin.Context, root string) (*fileSearch, error) {
+	s := &fileSearch{
+		root:          root,
+		name:          c.Query("name"),
+		caseSensitive: c.Query("case_sensitive") == "true",
+		minSize:       -1,
+		maxSize:       -1,
+		fileType:      c.Query("type"),
+		hidden:        c.Query("hidden") == "true",
+		maxResults:    defaultSearchResults,
+	}
+
+	if !s.caseSensitive {
+		s.name = strings.ToLower(s.name)
+	}
+	for _, glob := range splitQueryList(c.QueryArray("glob")) {
+		if !s.caseSensitive {
+			glob = strings.ToLower(glob)
+		}
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, errors.New("Invalid glob: " + glob)
+		}
+		s.globs = append(s.globs, glob)
+	}
+
+	if pattern := c.Query("content"); pattern != "" {
+		if !s.caseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		var err error
+		if s.content, err = regexp.Compile(pattern); err != nil {
+			return nil, errors.New("Invalid content pattern: " + err.Error())
+		}
+		if s.fileType == "dir" {
+			return nil, errors.New("Content search only matches files")
+		}
+		s.fileType = "file"
+	}
+
+	switch s.fileType {
+	case "", "file", "dir":
+	default:
+		return nil, errors.New("Invalid type: " + s.fileType)
+	}
+
+	for key, target := range map[string]*int64{"min_size": &s.minSize, "max_size": &s.maxSize} {
+		if v := c.Query(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("Invalid " + key)
+			}
+			*target = n
+		}
+	}
+	for key, target := range map[string]*time.Time{"modified_after": &s.after, "modified_before": &s.before} {
+		if v := c.Query(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+			}
+			if err != nil {
+				return nil, errors.New("Invalid " + key)
+			}
+			*target = t
+		}
+	}
+	if v, err := strconv.Atoi(c.Query("max_depth")); err == nil && v > 0 {
+		s.maxDepth = v
+	}
+	if v, err := strconv.Atoi(c.Query("max_results")); err == nil && v > 0 {
+		s.maxResults = min(v, maxSearchResults)
+	}
+
+	return s, nil
+}
+
+// walk traverses the tree and sends matches to results. Names and metadata
+// are checked during the walk; file contents are searched by a bounded pool
+// of workers.
+func (s *fileSearch) walk(ctx context.Context, jail *fileJail, results chan<- SearchMatch) {
+	send := func(match SearchMatch) {
+		select {
+		case results <- match:
+		case <-ctx.Done():
+		}
+	}
+
+	candidates := make(chan SearchMatch, 256)
+	var wg sync.WaitGroup
+	if s.content != nil {
+		for i := 0; i < min(runtime.NumCPU(), 8); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for match := range candidates {
+					if lines := s.grep(ctx, jail, match.Path); len(lines) > 0 {
+						match.Lines = lines
+						send(match)
+					}
+				}
+			}()
+		}
+	}
+
+	filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil || path == s.root {
+			return nil
+		}
+
+		skip := func() error {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !s.hidden && strings.HasPrefix(d.Name(), ".") {
+			return skip()
+		}
+		if searchSkipDirs[path] || jail.check(path) != nil {
+			return skip()
+		}
+		if d.IsDir() {
+			if jail.canRead(path, true) != nil {
+				return filepath.SkipDir
+			}
+			s.dirs.Add(1)
+		} else {
+			s.files.Add(1)
+		}
+
+		depth := strings.Count(strings.TrimPrefix(path, s.root), "/")
+		if s.root == "/" {
+			depth++
+		}
+		info, err := d.Info()
+		if err != nil || !s.matches(d.Name(), info) {
+			return s.descend(d, depth)
+		}
+
+		match := SearchMatch{
+			Type:    "match",
+			Path:    path,
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if s.content == nil {
+			send(match)
+		} else if info.Mode().IsRegular() && info.Size() <= maxSearchFileSize {
+			select {
+			case candidates <- match:
+			case <-ctx.Done():
+			}
+		}
+		return s.descend(d, depth)
+	})
+
+	close(candidates)
+	wg.Wait()
+}
+
+// descend stops the walk below directories at the maximum depth
+func (s *fileSearch) descend(d fs.DirEntry, depth int) error {
+	if d.IsDir() && s.maxDepth > 0 && depth >= s.maxDepth {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// matches checks the name and metadata criteria
+func (s *fileSearch) matches(name string, info fs.FileInfo) bool {
+	switch s.fileType {
+	case "file":
+		if !info.Mode().IsRegular() {
+			return false
+		}
+	case "dir":
+		if !info.IsDir() {
+			return false
+		}
+	}
+
+	if !s.caseSensitive {
+		name = strings.ToLower(name)
+	}
+	if s.name != "" && !strings.Contains(name, s.name) {
+		return false
+	}
+	if len(s.globs) > 0 {
+		matched := false
+		for _, glob := range s.globs {
+			if ok, _ := filepath.Match(glob, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if s.minSize >= 0 && info.Size() < s.minSize {
+		return false
+	}
+	if s.maxSize >= 0 && info.Size() > s.maxSize {
+		return false
+	}
+	if !s.after.IsZero() && info.ModTime().Before(s.after) {
+		return false
+	}
+	if !s.before.IsZero() && !info.ModTime().Before(s.before) {
+		return false
+	}
+	return true
+}
+
+// grep returns the first matching lines of a text file. Files with a NUL
+// byte near the start are treated as binary and skipped.
+func (s *fileSearch) grep(ctx context.Context, jail *fileJail, path string) []SearchLine {
+	if jail.canRead(path, false) != nil {
+		return nil
+	}
+	file, err := jail.openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	head, _ := reader.Peek(binarySniffSize)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+
+	var lines []SearchLine
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if n%1000 == 0 && ctx.Err() != nil {
+			return nil
+		}
+		if !s.content.Match(scanner.Bytes()) {
+			continue
+		}
+		lines = append(lines, SearchLine{Line: n, Text: truncateLine(scanner.Text())})
+		if len(lines) == maxSearchLines {
+			break
+		}
+	}
+	return lines
+}
+
+func truncateLine(line string) string {
+	if len(line) <= maxSearchLineLength {
+		return line
+	}
+	return strings.ToValidUTF8(line[:maxSearchLineLength], "") + "…"
+}
@@ -0,0 +1,596 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadSession is a resumable upload. The data is staged in a hidden file
+// next to the target so that completing the upload is a rename on the same
+// filesystem; the number of bytes staged so far is the resume offset.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	Progress  float64   `json:"progress"`
+	Overwrite bool      `json:"overwrite"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	mu sync.Mutex
+}
+
+type UploadResult struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	uploadStagingPrefix = ".webos-upload-"
+	// Unfinished uploads without activity for this long are discarded
+	uploadSessionTTL = 24 * time.Hour
+)
+
+var uploadSessions = struct {
+	sync.Mutex
+	loaded bool
+	byID   map[string]*UploadSession
+}{byID: map[string]*UploadSession{}}
+
+// DownloadFile streams a file with Range, ETag and Content-Disposition support.
+// ?inline=true lets the browser display the file instead of saving it.
+func DownloadFile(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	absPath, err := jail.resolve(c.Query("path"), true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		respondFileError(c, err, "File not found")
+		return
+	}
+	if info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is a directory, not a file"})
+		return
+	}
+	if jail.maxDownloadBytes > 0 && info.Size() > jail.maxDownloadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the download size limit"})
+		return
+	}
+	if err := jail.canRead(absPath, false); err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+
+	file, err := jail.openFile(absPath, os.O_RDONLY, 0)
+	if err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Name()}))
+	c.Header("ETag", fileETag(info))
+
+	// ServeContent handles Range, If-Range, If-None-Match and If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// UploadFiles stores the files of a multipart request in the directory ?path=.
+// Parts are streamed to disk, so the request size is only bounded by the quota.
+func UploadFiles(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	dir, err := jail.resolve(c.Query("path"), true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target is not a directory"})
+		return
+	}
+	if err := jail.checkPermission(dir, 2|1, ""); err != nil {
+		respondFileError(c, err, "Failed to upload")
+		return
+	}
+	if c.Request.ContentLength > jail.maxUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload exceeds the size limit"})
+		return
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart/form-data request"})
+		return
+	}
+	overwrite := c.Query("overwrite") == "true"
+
+	results := []UploadResult{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed multipart request", "files": results})
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		result := UploadResult{Name: filepath.Base(part.FileName())}
+		result.Path = filepath.Join(dir, result.Name)
+		if result.Name == "." || result.Name == ".." || result.Name == "/" {
+			result.Error = "invalid file name"
+		} else if err := jail.check(result.Path); err != nil {
+			result.Error = err.Error()
+		} else if _, err := os.Lstat(result.Path); err == nil && !overwrite {
+			result.Error = "file already exists"
+		} else {
+			result.Size, err = receiveUpload(jail, part, result.Path)
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files": results,
+	})
+}
+
+// receiveUpload writes one multipart file through a temporary file into place
+func receiveUpload(jail *fileJail, src io.Reader, path string) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), uploadStagingPrefix)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	// One extra byte tells us that the limit was exceeded
+	n, err := io.Copy(tmp, io.LimitReader(src, jail.maxUploadBytes+1))
+	if err == nil && n > jail.maxUploadBytes {
+		err = fmt.Errorf("file exceeds the upload size limit")
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := jail.placeUpload(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// placeUpload renames a staged upload to path. A file it overwrites keeps its
+// mode, owner and extended attributes; a new file belongs to the user.
+func (j *fileJail) placeUpload(staged, path string) error {
+	info, err := os.Lstat(path)
+	replacing := err == nil && info.Mode().IsRegular()
+	if replacing {
+		// chownはsetuidビットを落とすため、モードより先に設定する
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Chown(staged, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+		copyXattrs(path, staged)
+		err = os.Chmod(staged, j.preservedMode(info.Mode()))
+	} else {
+		err = os.Chmod(staged, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(staged, path); err != nil {
+		return err
+	}
+	if !replacing {
+		j.chownToAccount(path)
+	}
+	return nil
+}
+
+// ListUploads returns the unfinished uploads of the current user
+func ListUploads(c *gin.Context) {
+	username := c.GetString("username")
+
+	uploads := []*UploadSession{}
+	for _, session := range userUploadSessions(username) {
+		session.mu.Lock()
+		session.refresh()
+		uploads = append(uploads, session)
+		session.mu.Unlock()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uploads": uploads})
+}
+
+// CreateUpload starts a resumable upload of size bytes to path
+func CreateUpload(c *gin.Context) {
+	var request struct {
+		Path      string `json:"path"`
+		Size      int64  `json:"size"`
+		Overwrite bool   `json:"overwrite"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	if request.Size < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+		return
+	}
+	if request.Size > jail.maxUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the upload size limit"})
+		return
+	}
+
+	// 未完了のアップロードの合計がクォータを超えないようにする
+	pending := int64(0)
+	for _, session := range userUploadSessions(jail.username) {
+		pending += session.Size
+	}
+	if pending+request.Size > jail.uploadQuotaBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload quota exceeded; finish or cancel other uploads first"})
+		return
+	}
+
+	path, err := jail.resolve(request.Path, false)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	dir := filepath.Dir(path)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target directory does not exist"})
+		return
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.IsDir() || !request.Overwrite {
+			c.JSON(http.StatusConflict, gin.H{"error": "File already exists"})
+			return
+		}
+	}
+	if err := jail.checkPermission(dir, 2|1, ""); err != nil {
+		respondFileError(c, err, "Failed to create upload")
+		return
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err == nil && int64(fs.Bavail)*int64(fs.Bsize) < request.Size {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough free space on the target filesystem"})
+		return
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	session := &UploadSession{
+		ID:        id,
+		Username:  jail.username,
+		Path:      path,
+		Size:      request.Size,
+		Overwrite: request.Overwrite,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	staging, err := jail.openFile(session.stagingPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		respondFileError(c, err, "Failed to create upload")
+		return
+	}
+	staging.Close()
+
+	if err := session.save(); err != nil {
+		os.Remove(session.stagingPath())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload session: " + err.Error()})
+		return
+	}
+
+	uploadSessions.Lock()
+	uploadSessions.byID[id] = session
+	uploadSessions.Unlock()
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// GetUpload returns the progress of an upload; clients resume from its offset
+func GetUpload(c *gin.Context) {
+	session, ok := lookupUploadSession(c)
+	if !ok {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.refresh()
+	c.JSON(http.StatusOK, session)
+}
+
+// UploadChunk appends the request body to an upload at ?offset=, which has to
+// match the bytes received so far. The file is moved into place once complete.
+func UploadChunk(c *gin.Context) {
+	session, ok := lookupUploadSession(c)
+	if !ok {
+		return
+	}
+
+	// 同じアップロードへのチャンクは同時に1つだけ受け付ける
+	if !session.mu.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another chunk is being written"})
+		return
+	}
+	defer session.mu.Unlock()
+
+	session.refresh()
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset != session.Offset {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offset does not match", "offset": session.Offset})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	staging, err := jail.openFile(session.stagingPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		respondFileError(c, err, "Upload data is missing")
+		return
+	}
+
+	remaining := session.Size - session.Offset
+	n, copyErr := io.Copy(staging, io.LimitReader(c.Request.Body, remaining))
+	tooLarge := false
+	if copyErr == nil && n == remaining {
+		var extra [1]byte
+		if m, _ := c.Request.Body.Read(extra[:]); m > 0 {
+			tooLarge = true
+		}
+	}
+	if tooLarge {
+		// 途中までの書き込みを取り消して元のオフセットに戻す
+		staging.Truncate(session.Offset)
+	}
+	staging.Close()
+
+	session.UpdatedAt = time.Now()
+	session.refresh()
+	session.save()
+
+	if tooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds the declared upload size", "offset": session.Offset})
+		return
+	}
+	if copyErr != nil {
+		// Whatever arrived stays staged; the client resumes from the new offset
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload interrupted: " + copyErr.Error(), "offset": session.Offset})
+		return
+	}
+
+	if session.Offset < session.Size {
+		c.JSON(http.StatusOK, gin.H{"upload": session, "complete": false})
+		return
+	}
+
+	if err := finishUpload(jail, session); err != nil {
+		respondFileError(c, err, "Failed to complete upload: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"upload":   session,
+		"complete": true,
+		"path":     session.Path,
+	})
+}
+
+// CancelUpload discards an unfinished upload
+func CancelUpload(c *gin.Context) {
+	session, ok := lookupUploadSession(c)
+	if !ok {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.remove()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload cancelled"})
+}
+
+// finishUpload moves the staged data to the target path and forgets the session
+func finishUpload(jail *fileJail, session *UploadSession) error {
+	// The configuration or the directory may have changed since the upload started
+	path, err := jail.resolve(session.Path, false)
+	if err != nil {
+		return err
+	}
+	if path != session.Path {
+		return errOutsideRoots
+	}
+	if _, err := os.Lstat(path); err == nil && !session.Overwrite {
+		return fmt.Errorf("file already exists")
+	}
+
+	staging := session.stagingPath()
+	if file, err := os.OpenFile(staging, os.O_WRONLY|syscall.O_NOFOLLOW, 0); err == nil {
+		file.Sync()
+		file.Close()
+	}
+	if err := jail.placeUpload(staging, path); err != nil {
+		return err
+	}
+
+	session.remove()
+	return nil
+}
+
+func lookupUploadSession(c *gin.Context) (*UploadSession, bool) {
+	loadUploadSessions()
+
+	uploadSessions.Lock()
+	session, ok := uploadSessions.byID[c.Param("id")]
+	uploadSessions.Unlock()
+
+	// 他のユーザーのアップロードは存在しないものとして扱う
+	if !ok || session.Username != c.GetString("username") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	return session, true
+}
+
+// userUploadSessions returns the sessions of username after discarding expired ones
+func userUploadSessions(username string) []*UploadSession {
+	loadUploadSessions()
+
+	uploadSessions.Lock()
+	var sessions, expired []*UploadSession
+	for _, session := range uploadSessions.byID {
+		if time.Since(session.UpdatedAt) > uploadSessionTTL {
+			expired = append(expired, session)
+		} else if session.Username == username {
+			sessions = append(sessions, session)
+		}
+	}
+	uploadSessions.Unlock()
+
+	for _, session := range expired {
+		if session.mu.TryLock() {
+			session.remove()
+			session.mu.Unlock()
+		}
+	}
+	return sessions
+}
+
+// loadUploadSessions reads sessions persisted by a previous run of the server
+func loadUploadSessions() {
+	uploadSessions.Lock()
+	defer uploadSessions.Unlock()
+	if uploadSessions.loaded {
+		return
+	}
+	uploadSessions.loaded = true
+
+	files, _ := filepath.Glob(filepath.Join(uploadSessionDir(), "*.json"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		session := &UploadSession{}
+		if json.Unmarshal(data, session) == nil && session.ID != "" {
+			uploadSessions.byID[session.ID] = session
+		}
+	}
+}
+
+func uploadSessionDir() string {
+	return filepath.Join(webOSStateDir(), "uploads")
+}
+
+func (s *UploadSession) stagingPath() string {
+	return filepath.Join(filepath.Dir(s.Path), uploadStagingPrefix+s.ID)
+}
+
+// refresh takes the offset from the staged data, which survives crashes and restarts
+func (s *UploadSession) refresh() {
+	if info, err := os.Lstat(s.stagingPath()); err == nil {
+		s.Offset = info.Size()
+	}
+	s.Progress = 100
+	if s.Size > 0 {
+		s.Progress = float64(s.Offset) * 100 / float64(s.Size)
+	}
+}
+
+func (s *UploadSession) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(uploadSessionDir(), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(uploadSessionDir(), s.ID+".json"), data, 0600)
+}
+
+func (s *UploadSession) remove() {
+	os.Remove(s.stagingPath())
+	os.Remove(filepath.Join(uploadSessionDir(), s.ID+".json"))
+
+	uploadSessions.Lock()
+	delete(uploadSessions.byID, s.ID)
+	uploadSessions.Unlock()
+}
+
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// fileETag identifies a version of a file by inode, size and modification time
+func fileETag(info os.FileInfo) string {
+	var inode uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = st.Ino
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, inode, info.Size(), info.ModTime().UnixNano())
+}
@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigValidation is the outcome of checking content before it is saved
+type ConfigValidation struct {
+	Validator string            `json:"validator"` // visudo, nginx, sshd, findmnt, docker-compose, yaml, json or toml
+	Valid     bool              `json:"valid"`
+	Advisory  bool              `json:"advisory,omitempty"` // failures are reported but do not stop the save
+	Skipped   string            `json:"skipped,omitempty"`  // why the check could not run
+	Issues    []ValidationIssue `json:"issues"`
+	Output    string            `json:"output,omitempty"` // output of external validators
+}
+
+type ValidationIssue struct {
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"` // error or warning
+	Message  string `json:"message"`
+}
+
+const (
+	validationTimeout = 30 * time.Second
+	nginxConfDir      = "/etc/nginx"
+)
+
+type configValidator struct {
+	name  string
+	match func(path string) bool
+	run   func(ctx context.Context, jail *fileJail, path string, content []byte) (*ConfigValidation, error)
+	// advisory validators only know the file by its name, which also matches
+	// dialects they reject (JSON with comments, templated YAML)
+	advisory bool
+}
+
+// configValidators are tried in order; the first whose match accepts the
+// resolved path checks the file
+var configValidators = []configValidator{
+	{"visudo", func(path string) bool {
+		return path == "/etc/sudoers" || isWithinPath("/etc/sudoers.d", path) && path != "/etc/sudoers.d"
+	}, validateSudoers, false},
+	{"sshd", func(path string) bool {
+		return path == "/etc/ssh/sshd_config" || filepath.Dir(path) == "/etc/ssh/sshd_config.d"
+	}, validateSSHD, false},
+	{"nginx", func(path string) bool {
+		return isWithinPath(nginxConfDir, path) && path != nginxConfDir
+	}, validateNginx, false},
+	{"findmnt", func(path string) bool {
+		return path == "/etc/fstab"
+	}, validateFstab, false},
+	{"docker-compose", func(path string) bool {
+		switch filepath.Base(path) {
+		case "compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml":
+			return true
+		}
+		return false
+	}, validateCompose, true},
+	{"yaml", hasExtension(".yaml", ".yml"), validateYAML, true},
+	{"json", hasExtension(".json"), validateJSON, true},
+	{"toml", hasExtension(".toml"), validateTOML, true},
+}
+
+func hasExtension(exts ...string) func(string) bool {
+	return func(path string) bool {
+		ext := strings.ToLower(filepath.Ext(path))
+		for _, e := range exts {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// validateConfigFile checks content for the file at path; it returns nil when
+// no validator knows the file
+func validateConfigFile(ctx context.Context, jail *fileJail, path string, content []byte) (*ConfigValidation, error) {
+	for _, v := range configValidators {
+		if !v.match(path) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, validationTimeout)
+		defer cancel()
+		result, err := v.run(ctx, jail, path, content)
+		if result != nil {
+			result.Validator = v.name
+			result.Advisory = v.advisory
+			if result.Issues == nil {
+				result.Issues = []ValidationIssue{}
+			}
+		}
+		return result, err
+	}
+	return nil, nil
+}
+
+// checkBeforeSave validates content and writes an error response when the
+// save has to stop. Only system configuration files stop it; administrators
+// may still save them with force.
+func checkBeforeSave(c *gin.Context, jail *fileJail, path string, content []byte, force bool) (*ConfigValidation, bool) {
+	validation, err := validateConfigFile(c.Request.Context(), jail, path, content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate file: " + err.Error()})
+		return nil, false
+	}
+	if validation == nil || validation.Valid || validation.Advisory {
+		return validation, true
+	}
+	if force && jail.isAdmin() {
+		return validation, true
+	}
+	if force {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can save a file that fails validation", "validation": validation})
+		return nil, false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":      "The file failed " + validation.Validator + " validation and was not saved",
+		"validation": validation,
+		"can_force":  jail.isAdmin(),
+	})
+	return nil, false
+}
+
+// lookTool finds a validator; sbin directories are often missing from the server's PATH
+func lookTool(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, dir := range []string{"/usr/sbin", "/sbin", "/usr/local/sbin"} {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+// stageContent writes content to a private temporary directory under the
+// file's own name, so tools report errors against a recognisable name
+func stageContent(path string, content []byte, perm os.FileMode) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "webos-validate-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	staged := filepath.Join(dir, filepath.Base(path))
+	if err := os.WriteFile(staged, content, perm); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return staged, cleanup, nil
+}
+
+// runValidator runs a tool and turns its output into issues. The staged path
+// is replaced by the real one in messages.
+func runValidator(ctx context.Context, tool string, args []string, staged, path string) (*ConfigValidation, error) {
+	return runValidatorAs(ctx, nil, tool, args, staged, path)
+}
+
+// runValidatorAs is runValidator with the tool running as cred (nil keeps the
+// server's rights); the staged file has to be readable by it
+func runValidatorAs(ctx context.Context, cred *syscall.Credential, tool string, args []string, staged, path string) (*ConfigValidation, error) {
+	bin, err := lookTool(tool)
+	if err != nil {
+		return &ConfigValidation{Valid: true, Skipped: tool + " is not installed"}, nil
+	}
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	if cred != nil {
+		// the server's home directory is not readable with those rights
+		cmd.Env = append(os.Environ(), "HOME="+filepath.Dir(staged))
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return &ConfigValidation{Valid: true, Skipped: tool + " timed out"}, nil
+	}
+
+	result := &ConfigValidation{
+		Valid:  err == nil,
+		Output: strings.TrimSpace(strings.ReplaceAll(string(output), staged, path)),
+	}
+	if !result.Valid {
+		result.Issues = parseToolIssues(string(output), staged, path)
+	}
+	return result, nil
+}
+
+var (
+	lineNumberPattern  = regexp.MustCompile(`line (\d+)`)
+	errorPrefixPattern = regexp.MustCompile(`^(?:>>>\s*)?(?:nginx: )?(?:\[(?:emerg|alert|crit|error)\]\s*)?`)
+)
+
+// parseToolIssues makes an issue of every output line. Line numbers are taken
+// from "staged:LINE[:COL]", "staged line LINE" and "near line LINE" forms
+// when the line refers to the staged file.
+func parseToolIssues(output, staged, path string) []ValidationIssue {
+	position := regexp.MustCompile(regexp.QuoteMeta(staged) + `:(\d+)(?::(\d+))?`)
+
+	var issues []ValidationIssue
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		issue := ValidationIssue{Severity: "error"}
+		if m := position.FindStringSubmatch(line); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			issue.Column, _ = strconv.Atoi(m[2])
+		} else if m := lineNumberPattern.FindStringSubmatch(line); m != nil && strings.Contains(line, staged) {
+			issue.Line, _ = strconv.Atoi(m[1])
+		}
+		if strings.Contains(line, "[warn]") || strings.HasPrefix(strings.ToLower(line), "warning") {
+			issue.Severity = "warning"
+		}
+		issue.Message = errorPrefixPattern.ReplaceAllString(strings.ReplaceAll(line, staged, path), "")
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func validateSudoers(ctx context.Context, _ *fileJail, path string, content []byte) (*ConfigValidation, error) {
+	// visudo also checks the mode sudo expects
+	staged, cleanup, err := stageContent(path, content, 0440)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return runValidator(ctx, "visudo", []string{"-c", "-f", staged}, staged, path)
+}
+
+func validateSSHD(ctx context.Context, _ *fileJail, path string, content []byte) (*ConfigValidation, error) {
+	staged, cleanup, err := stageContent(path, content, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	// drop-in fragments are checked on their own, which catches syntax errors
+	return runValidator(ctx, "sshd", []string{"-t", "-f", staged}, staged, path)
+}
+
+// validateNginx runs nginx -t on the whole configuration with the edited file
+// swapped in: a copy of nginx.conf is written beside it whose include
+// directives point at the new content instead of the file on disk. Files
+// nginx.conf does not include (directly or through a sites-enabled link) are
+// not checked.
+func validateNginx(ctx context.Context, _ *fileJail, path string, content []byte) (*ConfigValidation, error) {
+	main := filepath.Join(nginxConfDir, "nginx.conf")
+	staged, cleanup, err := stageContent(path, content, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	mainContent := content
+	if path != main {
+		if mainContent, err = os.ReadFile(main); err != nil {
+			if os.IsNotExist(err) {
+				return &ConfigValidation{Valid: true, Skipped: main + " does not exist"}, nil
+			}
+			return nil, err
+		}
+	}
+	rewritten, included := rewriteNginxIncludes(mainContent, path, staged)
+	if path != main && !included {
+		return &ConfigValidation{Valid: true, Skipped: "the file is not included by " + main}, nil
+	}
+
+	// nginx resolves relative includes in every file against the directory of
+	// the -c file, so the copy has to live next to nginx.conf
+	mainFile, err := os.CreateTemp(nginxConfDir, ".webos-validate-*")
+	if err != nil {
+		return nil, err
+	}
+	stagedMain := mainFile.Name()
+	defer os.Remove(stagedMain)
+	_, err = mainFile.Write(rewritten)
+	if closeErr := mainFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if path == main {
+		staged = stagedMain
+	}
+	result, err := runValidator(ctx, "nginx", []string{"-t", "-q", "-c", stagedMain}, staged, path)
+	if result != nil {
+		result.Output = strings.ReplaceAll(result.Output, stagedMain, main)
+		for i := range result.Issues {
+			result.Issues[i].Message = strings.ReplaceAll(result.Issues[i].Message, stagedMain, main)
+		}
+	}
+	return result, err
+}
+
+var nginxIncludePattern = regexp.MustCompile(`\binclude\s+("[^"]*"|'[^']*'|[^\s;]+)\s*;`)
+
+// rewriteNginxIncludes makes the include paths of a main configuration
+// absolute (they are relative to its directory, which changes once it is
+// copied) and expands includes that match path to the staged file. It
+// reports whether path was matched.
+func rewriteNginxIncludes(content []byte, path, staged string) ([]byte, bool) {
+	included := false
+	rewritten := nginxIncludePattern.ReplaceAllFunc(content, func(directive []byte) []byte {
+		pattern := strings.Trim(string(nginxIncludePattern.FindSubmatch(directive)[1]), `"'`)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(nginxConfDir, pattern)
+		}
+
+		matches, _ := filepath.Glob(pattern)
+		matched := false
+		for i, match := range matches {
+			real, err := filepath.EvalSymlinks(match)
+			if match == path || (err == nil && real == path) {
+				matches[i] = staged
+				matched = true
+			}
+		}
+		if !matched {
+			return []byte("include " + strconv.Quote(pattern) + ";")
+		}
+
+		included = true
+		var b strings.Builder
+		for _, match := range matches {
+			b.WriteString("include " + strconv.Quote(match) + "; ")
+		}
+		return []byte(b.String())
+	})
+	return rewritten, included
+}
+
+// validateFstab runs findmnt --verify. Its messages name the mount point, so
+// issues are mapped back to the line of that entry.
+func validateFstab(ctx context.Context, _ *fileJail, path string, content []byte) (*ConfigValidation, error) {
+	staged, cleanup, err := stageContent(path, content, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	result, err := runValidator(ctx, "findmnt", []string{"--verify", "--tab-file", staged}, staged, path)
+	if result == nil || result.Skipped != "" {
+		return result, err
+	}
+
+	lines := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") {
+			lines[unescapeMountField(fields[1])] = n
+		}
+	}
+
+	// 出力はマウントポイントの行の下に "[E] ..." / "[W] ..." が続く形式
+	result.Issues = nil
+	entry := 0
+	for _, line := range strings.Split(result.Output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if m := lineNumberPattern.FindStringSubmatch(trimmed); m != nil && strings.Contains(trimmed, "parse error") {
+			n, _ := strconv.Atoi(m[1])
+			message := strings.TrimPrefix(strings.TrimPrefix(trimmed, "findmnt: "), path+": ")
+			result.Issues = append(result.Issues, ValidationIssue{Line: n, Severity: "error", Message: message})
+			continue
+		}
+		severity := ""
+		switch {
+		case strings.HasPrefix(trimmed, "[E]"):
+			severity = "error"
+		case strings.HasPrefix(trimmed, "[W]"):
+			severity = "warning"
+		case trimmed != "" && line == trimmed:
+			entry = lines[trimmed]
+			continue
+		}
+		if severity == "" {
+			continue
+		}
+		result.Issues = append(result.Issues, ValidationIssue{Line: entry, Severity: severity, Message: strings.TrimSpace(trimmed[3:])})
+	}
+	return result, nil
+}
+
+// validateCompose parses the YAML and then asks docker compose to interpret
+// the file with the project directory of the original. Compose reads the
+// env_file and include entries of the file, so it runs with the rights of the
+// user's account; for users without one only administrators get it.
+func validateCompose(ctx context.Context, jail *fileJail, path string, content []byte) (*ConfigValidation, error) {
+	if result, err := validateYAML(ctx, jail, path, content); err != nil || !result.Valid {
+		return result, err
+	}
+
+	cred := accountCredential(jail)
+	if cred == nil && os.Geteuid() == 0 && !jail.isAdmin() {
+		return &ConfigValidation{Valid: true, Skipped: "docker compose only checks files of administrators and Unix accounts"}, nil
+	}
+
+	staged, cleanup, err := stageContent(path, content, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if cred != nil {
+		os.Chown(filepath.Dir(staged), int(cred.Uid), int(cred.Gid))
+		os.Chown(staged, int(cred.Uid), int(cred.Gid))
+	}
+
+	result, err := runValidatorAs(ctx, cred, "docker", []string{"compose", "--project-directory", filepath.Dir(path), "-f", staged, "config", "-q"}, staged, path)
+	if result != nil && !result.Valid && strings.Contains(result.Output, "is not a docker command") {
+		return &ConfigValidation{Valid: true, Skipped: "the docker compose plugin is not installed"}, nil
+	}
+	return result, err
+}
+
+var yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): `)
+
+func validateYAML(_ context.Context, _ *fileJail, _ string, content []byte) (*ConfigValidation, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			return &ConfigValidation{Valid: true}, nil
+		}
+		if err != nil {
+			issue := ValidationIssue{Severity: "error", Message: err.Error()}
+			if m := yamlLinePattern.FindStringSubmatch(issue.Message); m != nil {
+				issue.Line, _ = strconv.Atoi(m[1])
+				issue.Message = strings.TrimPrefix(issue.Message, m[0])
+			}
+			return &ConfigValidation{Issues: []ValidationIssue{issue}}, nil
+		}
+	}
+}
+
+func validateJSON(_ context.Context, _ *fileJail, _ string, content []byte) (*ConfigValidation, error) {
+	var value any
+	err := json.Unmarshal(content, &value)
+	if err == nil {
+		return &ConfigValidation{Valid: true}, nil
+	}
+
+	issue := ValidationIssue{Severity: "error", Message: err.Error()}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		issue.Line, issue.Column = offsetPosition(content, syntaxErr.Offset)
+	}
+	return &ConfigValidation{Issues: []ValidationIssue{issue}}, nil
+}
+
+// offsetPosition converts a byte offset to a 1-based line and column
+func offsetPosition(content []byte, offset int64) (int, int) {
+	offset = min(offset, int64(len(content)))
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+func validateTOML(_ context.Context, _ *fileJail, _ string, content []byte) (*ConfigValidation, error) {
+	var value map[string]any
+	err := toml.Unmarshal(content, &value)
+	if err == nil {
+		return &ConfigValidation{Valid: true}, nil
+	}
+
+	issue := ValidationIssue{Severity: "error", Message: err.Error()}
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		issue.Line, issue.Column = decodeErr.Position()
+	}
+	return &ConfigValidation{Issues: []ValidationIssue{issue}}, nil
+}
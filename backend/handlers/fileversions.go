@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileVersion is an earlier content of a file, kept when a save replaced it
+type FileVersion struct {
+	ID         string    `json:"id"`
+	ModTime    time.Time `json:"modTime"`     // when this content was written
+	ReplacedAt time.Time `json:"replaced_at"` // when a save replaced it
+	ReplacedBy string    `json:"replaced_by"`
+	Size       int64     `json:"size"`
+	// Owner and permission bits of the file while it held this content; only
+	// users who could read it then may read the version
+	UID  uint32 `json:"uid"`
+	GID  uint32 `json:"gid"`
+	Mode uint32 `json:"mode"`
+}
+
+type fileVersionIndex struct {
+	Path     string        `json:"path"`
+	Versions []FileVersion `json:"versions"` // oldest first
+}
+
+const (
+	maxFileVersions = 20
+	// Larger files cannot be opened in the editor, so they are never versioned
+	maxVersionedFileSize = 10 * 1024 * 1024
+	// Limits for all files together; the oldest versions go first
+	maxFileVersionAge        = 90 * 24 * time.Hour
+	maxFileVersionStoreBytes = 2 << 30
+	fileVersionPruneInterval = time.Hour
+)
+
+// fileVersionStore serialises index updates and pruning
+var fileVersionStore struct {
+	sync.Mutex
+	lastPrune time.Time
+}
+
+var errVersionNotFound = errors.New("version not found")
+
+// fileVersionDir holds the versions of one file, keyed by its resolved path
+func fileVersionDir(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(webOSStateDir(), "versions", hex.EncodeToString(sum[:]))
+}
+
+func readFileVersionIndex(path string) fileVersionIndex {
+	index := fileVersionIndex{Path: path}
+	data, err := os.ReadFile(filepath.Join(fileVersionDir(path), "index.json"))
+	if err == nil {
+		json.Unmarshal(data, &index)
+	}
+	// ハッシュの衝突に備えてパスを確認する
+	if index.Path != path {
+		return fileVersionIndex{Path: path}
+	}
+	return index
+}
+
+// keepVersion stores the current content of real before a save replaces it
+// and returns the version ID, or "" when nothing was kept
+func (j *fileJail) keepVersion(real string, info os.FileInfo, content []byte) string {
+	if info.Size() > maxVersionedFileSize {
+		return ""
+	}
+	file, err := j.openFile(real, os.O_RDONLY, 0)
+	if err != nil {
+		return ""
+	}
+	previous, err := io.ReadAll(io.LimitReader(file, maxVersionedFileSize+1))
+	file.Close()
+	if err != nil || len(previous) > maxVersionedFileSize || bytes.Equal(previous, content) {
+		return ""
+	}
+
+	dir := fileVersionDir(real)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	now := time.Now()
+	version := FileVersion{
+		ID:         strconv.FormatInt(now.UnixNano(), 10),
+		ModTime:    info.ModTime(),
+		ReplacedAt: now,
+		ReplacedBy: j.username,
+		Size:       int64(len(previous)),
+		UID:        st.Uid,
+		GID:        st.Gid,
+		Mode:       st.Mode & 0777,
+	}
+	if err := writeFileAtomic(filepath.Join(dir, version.ID), previous, 0600); err != nil {
+		return ""
+	}
+
+	fileVersionStore.Lock()
+	index := readFileVersionIndex(real)
+	index.Versions = append(index.Versions, version)
+	for len(index.Versions) > maxFileVersions {
+		os.Remove(filepath.Join(dir, index.Versions[0].ID))
+		index.Versions = index.Versions[1:]
+	}
+	data, err := json.Marshal(index)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(dir, "index.json"), data, 0600)
+	}
+	fileVersionStore.Unlock()
+	if err != nil {
+		os.Remove(filepath.Join(dir, version.ID))
+		return ""
+	}
+
+	pruneFileVersions()
+	return version.ID
+}
+
+// pruneFileVersions drops versions older than maxFileVersionAge and then the
+// oldest ones until the store fits maxFileVersionStoreBytes. It runs at most
+// once per fileVersionPruneInterval.
+func pruneFileVersions() {
+	fileVersionStore.Lock()
+	defer fileVersionStore.Unlock()
+	if time.Since(fileVersionStore.lastPrune) < fileVersionPruneInterval {
+		return
+	}
+	fileVersionStore.lastPrune = time.Now()
+
+	type storedVersion struct {
+		dir     string
+		version FileVersion
+	}
+	root := filepath.Join(webOSStateDir(), "versions")
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	indexes := map[string]*fileVersionIndex{}
+	var stored []storedVersion
+	total := int64(0)
+	for _, entry := range entries {
+		dir := filepath.Join(root, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+		var index fileVersionIndex
+		if err != nil || json.Unmarshal(data, &index) != nil {
+			continue
+		}
+		indexes[dir] = &index
+		for _, version := range index.Versions {
+			stored = append(stored, storedVersion{dir, version})
+			total += version.Size
+		}
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].version.ReplacedAt.Before(stored[j].version.ReplacedAt)
+	})
+	cutoff := time.Now().Add(-maxFileVersionAge)
+	dropped := map[string]map[string]bool{}
+	for _, s := range stored {
+		if !s.version.ReplacedAt.Before(cutoff) && total <= maxFileVersionStoreBytes {
+			break
+		}
+		os.Remove(filepath.Join(s.dir, s.version.ID))
+		if dropped[s.dir] == nil {
+			dropped[s.dir] = map[string]bool{}
+		}
+		dropped[s.dir][s.version.ID] = true
+		total -= s.version.Size
+	}
+
+	for dir, ids := range dropped {
+		index := indexes[dir]
+		versions := index.Versions[:0]
+		for _, version := range index.Versions {
+			if !ids[version.ID] {
+				versions = append(versions, version)
+			}
+		}
+		index.Versions = versions
+		if len(versions) == 0 {
+			os.RemoveAll(dir)
+			continue
+		}
+		if data, err := json.Marshal(index); err == nil {
+			writeFileAtomic(filepath.Join(dir, "index.json"), data, 0600)
+		}
+	}
+}
+
+// mayReadVersion reports whether the user could read the file back when it
+// held the version's content. A file deleted or made private since then
+// does not expose its earlier contents to others.
+func (j *fileJail) mayReadVersion(version FileVersion) bool {
+	return j.account == nil || j.account.permits(version.UID, version.GID, version.Mode, 4)
+}
+
+// readFileVersion returns the content of a version listed in the file's index
+func readFileVersion(jail *fileJail, path, id string) (FileVersion, []byte, error) {
+	for _, version := range readFileVersionIndex(path).Versions {
+		if version.ID == id && jail.mayReadVersion(version) {
+			content, err := os.ReadFile(filepath.Join(fileVersionDir(path), version.ID))
+			return version, content, err
+		}
+	}
+	return FileVersion{}, nil, errVersionNotFound
+}
+
+// versionedPath resolves ?path= for the version endpoints. Versions of a file
+// are visible to whoever may read it, or create it again once it is deleted,
+// and each version only to those who could read the file at the time.
+func versionedPath(c *gin.Context, jail *fileJail, path string) (string, bool) {
+	real, err := jail.resolve(path, true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return "", false
+	}
+	if _, err := os.Stat(real); err == nil {
+		err = jail.canRead(real, false)
+	} else {
+		err = jail.canWrite(real)
+	}
+	if err != nil {
+		respondFileError(c, err, "File not found")
+		return "", false
+	}
+	return real, true
+}
+
+// ListFileVersions returns the kept versions of ?path=, newest first
+func ListFileVersions(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+	path, ok := versionedPath(c, jail, c.Query("path"))
+	if !ok {
+		return
+	}
+
+	index := readFileVersionIndex(path)
+	versions := make([]FileVersion, 0, len(index.Versions))
+	for i := len(index.Versions) - 1; i >= 0; i-- {
+		if jail.mayReadVersion(index.Versions[i]) {
+			versions = append(versions, index.Versions[i])
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"path": path, "versions": versions})
+}
+
+// DiffFileVersion returns a unified diff from version ?from= to version ?to=,
+// or to the current content when to is empty or "current"
+func DiffFileVersion(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+	path, ok := versionedPath(c, jail, c.Query("path"))
+	if !ok {
+		return
+	}
+
+	from, fromContent, err := readFileVersion(jail, path, c.Query("from"))
+	if err != nil {
+		respondVersionError(c, err)
+		return
+	}
+	fromLabel := path + "\t" + from.ModTime.Format(time.RFC3339)
+
+	var toContent []byte
+	toLabel := path + "\t(current)"
+	if to := c.Query("to"); to != "" && to != "current" {
+		version, content, err := readFileVersion(jail, path, to)
+		if err != nil {
+			respondVersionError(c, err)
+			return
+		}
+		toContent = content
+		toLabel = path + "\t" + version.ModTime.Format(time.RFC3339)
+	} else if info, err := os.Stat(path); err == nil {
+		if info.Size() > maxVersionedFileSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large"})
+			return
+		}
+		file, err := jail.openFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			respondFileError(c, err, "Failed to read file")
+			return
+		}
+		toContent, err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+	}
+
+	diff, err := unifiedDiff(fromContent, toContent, fromLabel, toLabel)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "diff is not installed (diffutils package)"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare versions: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"path":      path,
+		"from":      from.ID,
+		"to":        c.DefaultQuery("to", "current"),
+		"identical": diff == "",
+		"diff":      diff,
+	})
+}
+
+// unifiedDiff runs diff -u on two contents
+func unifiedDiff(a, b []byte, labelA, labelB string) (string, error) {
+	dir, err := os.MkdirTemp("", "webos-diff-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	fileA, fileB := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(fileA, a, 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(fileB, b, 0600); err != nil {
+		return "", err
+	}
+
+	output, err := exec.Command("diff", "-u", "--label", labelA, "--label", labelB, fileA, fileB).Output()
+	// 終了コード1は差分ありを意味する
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	return string(output), err
+}
+
+// RestoreFileVersion rolls {"path"} back to version {"id"}. Like a save it
+// takes the client's {"etag"} or {"modTime"}, and the content it replaces is
+// kept as a new version, so a rollback can be undone. The old content is
+// validated like a save.
+func RestoreFileVersion(c *gin.Context) {
+	var request struct {
+		Path    string     `json:"path"`
+		ID      string     `json:"id"`
+		ETag    string     `json:"etag"`
+		ModTime *time.Time `json:"modTime"`
+		Force   bool       `json:"force"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+	path, ok := versionedPath(c, jail, request.Path)
+	if !ok {
+		return
+	}
+	if err := jail.canWrite(path); err != nil {
+		respondFileError(c, err, "Failed to write file")
+		return
+	}
+
+	_, content, err := readFileVersion(jail, path, request.ID)
+	if err != nil {
+		respondVersionError(c, err)
+		return
+	}
+	validation, ok := checkBeforeSave(c, jail, path, content, request.Force)
+	if !ok {
+		return
+	}
+	info, version, err := jail.saveFile(path, content, request.ETag, request.ModTime)
+	if err != nil {
+		respondSaveError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Version restored",
+		"path":       path,
+		"etag":       fileETag(info),
+		"modTime":    info.ModTime(),
+		"size":       info.Size(),
+		"version":    version,
+		"validation": validation,
+	})
+}
+
+func respondVersionError(c *gin.Context, err error) {
+	if errors.Is(err, errVersionNotFound) || errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read version"})
+}
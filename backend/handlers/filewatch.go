@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type WatchEvent struct {
+	Op      string `json:"op"` // create, modify, delete, rename
+	Dir     string `json:"dir"`
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"` // rename only
+	IsDir   bool   `json:"isDir"`
+}
+
+const (
+	maxWatchesPerUser = 32
+	// Events are collected for this long and sent as one batch, so that a
+	// burst of writes to the same file becomes a single "modify"
+	watchBatchInterval = 250 * time.Millisecond
+
+	watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+		syscall.IN_ONLYDIR | syscall.IN_EXCL_UNLINK
+)
+
+// Watches held by each user across all connections
+var fileWatchCounts = struct {
+	sync.Mutex
+	byUser map[string]int
+}{byUser: map[string]int{}}
+
+type inotifyEvent struct {
+	wd     int32
+	mask   uint32
+	cookie uint32
+	name   string
+}
+
+// fileWatcher holds the inotify instance of one WebSocket connection. Its maps
+// are only used from the connection's main loop.
+type fileWatcher struct {
+	jail    *fileJail
+	fd      int
+	file    *os.File
+	byPath  map[string]int32
+	byWD    map[int32]string
+	pending []WatchEvent
+	index   map[string]int // path -> position in pending
+	moves   map[uint32]int // cookie -> position of the unpaired moved_from
+}
+
+// WatchFiles pushes changes in directories over a WebSocket. Directories are
+// given as ?path= (repeatable) or added later with {"type": "watch", "path"}
+// and removed with {"type": "unwatch", "path"}. Changes arrive as
+// {"type": "events", "events": [...]} batches; {"type": "overflow"} means
+// events were lost and the client should reload its listings.
+func WatchFiles(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "WebSocket upgrade failed"})
+		return
+	}
+	defer conn.Close()
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		conn.WriteJSON(gin.H{"type": "error", "error": "Failed to initialise inotify: " + err.Error()})
+		return
+	}
+	w := &fileWatcher{
+		jail:   jail,
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"), // non-blocking, so Close interrupts Read
+		byPath: map[string]int32{},
+		byWD:   map[int32]string{},
+		index:  map[string]int{},
+		moves:  map[uint32]int{},
+	}
+	defer w.close()
+
+	// 終了後に送信側のgoroutineが詰まらないようにする
+	done := make(chan struct{})
+	defer close(done)
+
+	type request struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	}
+	requests := make(chan request)
+	go func() {
+		defer close(requests)
+		for {
+			var req request
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	events := make(chan []inotifyEvent)
+	go w.read(events, done)
+
+	for _, path := range splitQueryList(c.QueryArray("path")) {
+		conn.WriteJSON(w.watch(path))
+	}
+
+	var flush <-chan time.Time
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			switch req.Type {
+			case "watch":
+				conn.WriteJSON(w.watch(req.Path))
+			case "unwatch":
+				conn.WriteJSON(w.unwatch(req.Path))
+			default:
+				conn.WriteJSON(gin.H{"type": "error", "error": "Unknown message type: " + req.Type})
+			}
+
+		case batch, ok := <-events:
+			if !ok {
+				conn.WriteJSON(gin.H{"type": "error", "error": "inotify stopped"})
+				return
+			}
+			for _, ev := range batch {
+				if ev.mask&syscall.IN_Q_OVERFLOW != 0 {
+					if err := conn.WriteJSON(gin.H{"type": "overflow"}); err != nil {
+						return
+					}
+					continue
+				}
+				if removed := w.handle(ev); removed != "" {
+					conn.WriteJSON(gin.H{"type": "unwatched", "path": removed, "reason": "directory was removed or moved"})
+				}
+			}
+			if len(w.pending) > 0 && flush == nil {
+				flush = time.After(watchBatchInterval)
+			}
+
+		case <-flush:
+			flush = nil
+			if err := conn.WriteJSON(gin.H{"type": "events", "events": w.takePending()}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// watch adds an inotify watch for a directory within the user's jail
+func (w *fileWatcher) watch(path string) gin.H {
+	dir, err := w.jail.resolve(path, true)
+	if err != nil {
+		return gin.H{"type": "error", "path": path, "error": err.Error()}
+	}
+	if _, ok := w.byPath[dir]; ok {
+		return gin.H{"type": "watching", "path": dir}
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return gin.H{"type": "error", "path": path, "error": "Not a directory"}
+	}
+	if err := w.jail.canRead(dir, true); err != nil {
+		return gin.H{"type": "error", "path": path, "error": "Permission denied"}
+	}
+
+	fileWatchCounts.Lock()
+	if fileWatchCounts.byUser[w.jail.username] >= maxWatchesPerUser {
+		fileWatchCounts.Unlock()
+		return gin.H{"type": "error", "path": path, "error": "Too many watched directories; close some folders first"}
+	}
+	fileWatchCounts.byUser[w.jail.username]++
+	fileWatchCounts.Unlock()
+
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		releaseFileWatches(w.jail.username, 1)
+		return gin.H{"type": "error", "path": path, "error": err.Error()}
+	}
+	// 同じディレクトリがシンボリックリンク経由で既に監視されている場合
+	if _, ok := w.byWD[int32(wd)]; ok {
+		releaseFileWatches(w.jail.username, 1)
+		delete(w.byPath, w.byWD[int32(wd)])
+	}
+	w.byPath[dir] = int32(wd)
+	w.byWD[int32(wd)] = dir
+	return gin.H{"type": "watching", "path": dir}
+}
+
+func (w *fileWatcher) unwatch(path string) gin.H {
+	dir, err := w.jail.resolve(path, true)
+	if err != nil {
+		return gin.H{"type": "error", "path": path, "error": err.Error()}
+	}
+	wd, ok := w.byPath[dir]
+	if !ok {
+		return gin.H{"type": "error", "path": path, "error": "Not watched"}
+	}
+	w.remove(wd)
+	return gin.H{"type": "unwatched", "path": dir}
+}
+
+// remove drops a watch; events still queued for it are ignored
+func (w *fileWatcher) remove(wd int32) {
+	syscall.InotifyRmWatch(w.fd, uint32(wd))
+	delete(w.byPath, w.byWD[wd])
+	delete(w.byWD, wd)
+	releaseFileWatches(w.jail.username, 1)
+}
+
+// read parses events from the inotify descriptor until it is closed
+func (w *fileWatcher) read(events chan<- []inotifyEvent, done <-chan struct{}) {
+	defer close(events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		var batch []inotifyEvent
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(buf[nameStart:nameEnd])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			batch = append(batch, inotifyEvent{wd: raw.Wd, mask: raw.Mask, cookie: raw.Cookie, name: name})
+			offset = nameEnd
+		}
+		select {
+		case events <- batch:
+		case <-done:
+			return
+		}
+	}
+}
+
+// handle merges one event into the pending batch. It returns the directory
+// whose watch ended, if any.
+func (w *fileWatcher) handle(ev inotifyEvent) string {
+	dir, ok := w.byWD[ev.wd]
+	if !ok {
+		return ""
+	}
+
+	// IN_IGNORED: the kernel dropped the watch, e.g. when the filesystem was unmounted
+	if ev.mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+		w.remove(ev.wd)
+		return dir
+	}
+	if ev.name == "" {
+		return ""
+	}
+
+	path := filepath.Join(dir, ev.name)
+	// 拒否パス（/etc/shadowなど）の変更は通知しない
+	if w.jail.check(path) != nil {
+		return ""
+	}
+	event := WatchEvent{Dir: dir, Path: path, IsDir: ev.mask&syscall.IN_ISDIR != 0}
+
+	switch {
+	case ev.mask&syscall.IN_MOVED_FROM != 0:
+		event.Op = "delete"
+		w.merge(event)
+		if i, ok := w.index[path]; ok && w.pending[i].Op == "delete" {
+			w.moves[ev.cookie] = i
+		}
+	case ev.mask&syscall.IN_MOVED_TO != 0:
+		if i, ok := w.moves[ev.cookie]; ok && w.pending[i].Op == "delete" {
+			delete(w.moves, ev.cookie)
+			from := w.pending[i]
+			// 移動元の削除イベントを名前変更に置き換える
+			w.pending[i].Op = ""
+			delete(w.index, from.Path)
+			event.Op = "rename"
+			event.OldPath = from.Path
+			w.append(event)
+			return ""
+		}
+		event.Op = "create"
+		w.merge(event)
+	case ev.mask&syscall.IN_CREATE != 0:
+		event.Op = "create"
+		w.merge(event)
+	case ev.mask&syscall.IN_DELETE != 0:
+		event.Op = "delete"
+		w.merge(event)
+	case ev.mask&(syscall.IN_MODIFY|syscall.IN_ATTRIB) != 0:
+		event.Op = "modify"
+		w.merge(event)
+	}
+	return ""
+}
+
+// merge combines an event with a pending one for the same path
+func (w *fileWatcher) merge(event WatchEvent) {
+	i, ok := w.index[event.Path]
+	if !ok {
+		w.append(event)
+		return
+	}
+
+	previous := &w.pending[i]
+	switch {
+	case previous.Op == "create" && event.Op == "modify":
+		// still a new file
+	case previous.Op == "create" && event.Op == "delete":
+		previous.Op = ""
+		delete(w.index, event.Path)
+	case previous.Op == "delete" && event.Op == "create":
+		previous.Op = "modify"
+		previous.IsDir = event.IsDir
+	case previous.Op == "rename" && event.Op == "modify":
+		// the renamed file was written to; the client reloads it anyway
+	default:
+		previous.Op = event.Op
+	}
+}
+
+func (w *fileWatcher) append(event WatchEvent) {
+	w.index[event.Path] = len(w.pending)
+	w.pending = append(w.pending, event)
+}
+
+// takePending returns the batch in arrival order without merged-away events
+func (w *fileWatcher) takePending() []WatchEvent {
+	events := make([]WatchEvent, 0, len(w.pending))
+	for _, event := range w.pending {
+		if event.Op != "" {
+			events = append(events, event)
+		}
+	}
+	w.pending = nil
+	w.index = map[string]int{}
+	w.moves = map[uint32]int{}
+	return events
+}
+
+func (w *fileWatcher) close() {
+	w.file.Close()
+	releaseFileWatches(w.jail.username, len(w.byWD))
+}
+
+func releaseFileWatches(username string, n int) {
+	fileWatchCounts.Lock()
+	defer fileWatchCounts.Unlock()
+	fileWatchCounts.byUser[username] -= n
+	if fileWatchCounts.byUser[username] <= 0 {
+		delete(fileWatchCounts.byUser, username)
+	}
+}
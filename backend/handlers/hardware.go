@@ -0,0 +1,628 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type HardwareInventory struct {
+	CPU          CPUInventory    `json:"cpu"`
+	Memory       MemoryInventory `json:"memory"`
+	System       DMIInfo         `json:"system"`
+	PCIDevices   []PCIDevice     `json:"pci_devices"`
+	USBDevices   []USBDevice     `json:"usb_devices"`
+	BlockDevices []BlockDevice   `json:"block_devices"`
+	NICs         []NICInventory  `json:"nics"`
+}
+
+type CPUInventory struct {
+	Model          string   `json:"model"`
+	Vendor         string   `json:"vendor"`
+	Family         string   `json:"family"`
+	ModelID        string   `json:"model_id"`
+	Stepping       string   `json:"stepping"`
+	Microcode      string   `json:"microcode"`
+	MHz            float64  `json:"mhz"`
+	MaxMHz         float64  `json:"max_mhz"`
+	CacheSize      string   `json:"cache_size"`
+	Sockets        int      `json:"sockets"`
+	Cores          int      `json:"cores"`
+	Threads        int      `json:"threads"`
+	ThreadsPerCore int      `json:"threads_per_core"`
+	NUMANodes      int      `json:"numa_nodes"`
+	Flags          []string `json:"flags"`
+}
+
+type MemoryInventory struct {
+	Total uint64     `json:"total"`
+	DIMMs []DIMMInfo `json:"dimms"`
+}
+
+type DIMMInfo struct {
+	Locator      string `json:"locator"`
+	BankLocator  string `json:"bank_locator"`
+	Size         uint64 `json:"size"`
+	Type         string `json:"type"`
+	Speed        int    `json:"speed"`
+	Manufacturer string `json:"manufacturer"`
+	PartNumber   string `json:"part_number"`
+	Serial       string `json:"serial"`
+}
+
+type DMIInfo struct {
+	SystemVendor   string `json:"system_vendor"`
+	ProductName    string `json:"product_name"`
+	ProductVersion string `json:"product_version"`
+	ProductSerial  string `json:"product_serial"`
+	BoardVendor    string `json:"board_vendor"`
+	BoardName      string `json:"board_name"`
+	BoardVersion   string `json:"board_version"`
+	BoardSerial    string `json:"board_serial"`
+	BIOSVendor     string `json:"bios_vendor"`
+	BIOSVersion    string `json:"bios_version"`
+	BIOSDate       string `json:"bios_date"`
+	ChassisType    string `json:"chassis_type"`
+}
+
+type PCIDevice struct {
+	Address    string `json:"address"`
+	VendorID   string `json:"vendor_id"`
+	DeviceID   string `json:"device_id"`
+	Vendor     string `json:"vendor"`
+	Device     string `json:"device"`
+	ClassID    string `json:"class_id"`
+	Class      string `json:"class"`
+	Driver     string `json:"driver"`
+	IOMMUGroup string `json:"iommu_group"`
+}
+
+type USBDevice struct {
+	Bus          int    `json:"bus"`
+	DeviceNum    int    `json:"device_num"`
+	Path         string `json:"path"`
+	VendorID     string `json:"vendor_id"`
+	ProductID    string `json:"product_id"`
+	Vendor       string `json:"vendor"`
+	Product      string `json:"product"`
+	Manufacturer string `json:"manufacturer"`
+	Serial       string `json:"serial"`
+	Speed        string `json:"speed"`
+	Driver       string `json:"driver"`
+}
+
+type BlockDevice struct {
+	Name       string `json:"name"`
+	Size       uint64 `json:"size"`
+	Model      string `json:"model"`
+	Vendor     string `json:"vendor"`
+	Serial     string `json:"serial"`
+	Rotational bool   `json:"rotational"`
+	Removable  bool   `json:"removable"`
+	Transport  string `json:"transport"`
+}
+
+type NICInventory struct {
+	Name       string `json:"name"`
+	MAC        string `json:"mac"`
+	Driver     string `json:"driver"`
+	PCIAddress string `json:"pci_address"`
+	Speed      int    `json:"speed"`
+}
+
+// idDatabase holds the vendor/device/class names from pci.ids or usb.ids
+type idDatabase struct {
+	vendors map[string]string
+	devices map[string]string
+	classes map[string]string
+}
+
+var (
+	pciIDs     *idDatabase
+	pciIDsOnce sync.Once
+	usbIDs     *idDatabase
+	usbIDsOnce sync.Once
+)
+
+var pciIDPaths = []string{"/usr/share/misc/pci.ids", "/usr/share/hwdata/pci.ids", "/usr/share/pci.ids"}
+var usbIDPaths = []string{"/usr/share/misc/usb.ids", "/var/lib/usbutils/usb.ids", "/usr/share/hwdata/usb.ids", "/usr/share/usb.ids"}
+
+// SMBIOS memory types (type 17, offset 0x12)
+var dimmTypes = map[byte]string{
+	0x12: "DDR", 0x13: "DDR2", 0x14: "DDR2 FB-DIMM", 0x18: "DDR3", 0x1A: "DDR4",
+	0x1B: "LPDDR", 0x1C: "LPDDR2", 0x1D: "LPDDR3", 0x1E: "LPDDR4", 0x22: "DDR5", 0x23: "LPDDR5",
+}
+
+func getHardwareInventory() HardwareInventory {
+	return HardwareInventory{
+		CPU:          getCPUInventory(),
+		Memory:       getMemoryInventory(),
+		System:       getDMIInfo(),
+		PCIDevices:   getPCIDevices(),
+		USBDevices:   getUSBDevices(),
+		BlockDevices: getBlockDevices(),
+		NICs:         getNICInventory(),
+	}
+}
+
+func getCPUInventory() CPUInventory {
+	cpu := CPUInventory{Flags: []string{}}
+
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return cpu
+	}
+	defer file.Close()
+
+	// Only the first processor block is needed for model information
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "model name":
+			cpu.Model = value
+		case "vendor_id":
+			cpu.Vendor = value
+		case "cpu family":
+			cpu.Family = value
+		case "model":
+			cpu.ModelID = value
+		case "stepping":
+			cpu.Stepping = value
+		case "microcode":
+			cpu.Microcode = value
+		case "cpu MHz":
+			cpu.MHz, _ = strconv.ParseFloat(value, 64)
+		case "cache size":
+			cpu.CacheSize = value
+		case "flags", "Features":
+			cpu.Flags = strings.Fields(value)
+		}
+	}
+
+	if maxFreq, ok := readSysInt("/sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq"); ok {
+		cpu.MaxMHz = float64(maxFreq) / 1000.0
+	}
+
+	// Topology: count distinct packages and (package, core) pairs over online CPUs
+	sockets := make(map[string]bool)
+	cores := make(map[string]bool)
+	topologies, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/topology")
+	for _, dir := range topologies {
+		pkg := readSysString(filepath.Join(dir, "physical_package_id"))
+		core := readSysString(filepath.Join(dir, "core_id"))
+		sockets[pkg] = true
+		cores[pkg+":"+core] = true
+	}
+
+	cpu.Threads = len(topologies)
+	cpu.Sockets = len(sockets)
+	cpu.Cores = len(cores)
+	if cpu.Threads == 0 {
+		cpu.Threads = getCPUCores()
+	}
+	if cpu.Cores > 0 {
+		cpu.ThreadsPerCore = cpu.Threads / cpu.Cores
+	}
+
+	nodes, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	cpu.NUMANodes = len(nodes)
+
+	return cpu
+}
+
+func getMemoryInventory() MemoryInventory {
+	return MemoryInventory{
+		Total: getMemoryStats().Total,
+		DIMMs: getDIMMs(),
+	}
+}
+
+// getDIMMs decodes SMBIOS type 17 (Memory Device) tables. They are only
+// readable by root; an empty list is returned otherwise.
+func getDIMMs() []DIMMInfo {
+	dimms := []DIMMInfo{}
+
+	entries, _ := filepath.Glob("/sys/firmware/dmi/entries/17-*")
+	sort.Strings(entries)
+	for _, dir := range entries {
+		raw, err := os.ReadFile(filepath.Join(dir, "raw"))
+		if err != nil || len(raw) < 0x1B || int(raw[1]) > len(raw) {
+			continue
+		}
+
+		length := int(raw[1])
+		strs := dmiStrings(raw[length:])
+		str := func(offset int) string {
+			if offset >= length {
+				return ""
+			}
+			idx := int(raw[offset])
+			if idx == 0 || idx > len(strs) {
+				return ""
+			}
+			return strs[idx-1]
+		}
+
+		// Size in MB, or KB when bit 15 is set; 0x7FFF means use the extended size field
+		size := uint64(binary.LittleEndian.Uint16(raw[0x0C:]))
+		switch {
+		case size == 0 || size == 0xFFFF:
+			continue // Empty slot or unknown
+		case size == 0x7FFF && length >= 0x20:
+			size = uint64(binary.LittleEndian.Uint32(raw[0x1C:])&0x7FFFFFFF) << 20
+		case size&0x8000 != 0:
+			size = (size & 0x7FFF) << 10
+		default:
+			size <<= 20
+		}
+
+		dimm := DIMMInfo{
+			Locator:      str(0x10),
+			BankLocator:  str(0x11),
+			Size:         size,
+			Type:         dimmTypes[raw[0x12]],
+			Speed:        int(binary.LittleEndian.Uint16(raw[0x15:])),
+			Manufacturer: str(0x17),
+			Serial:       str(0x18),
+			PartNumber:   strings.TrimSpace(str(0x1A)),
+		}
+		dimms = append(dimms, dimm)
+	}
+
+	return dimms
+}
+
+// dmiStrings splits the string set that follows an SMBIOS structure
+func dmiStrings(data []byte) []string {
+	var strs []string
+	for len(data) > 0 && data[0] != 0 {
+		end := 0
+		for end < len(data) && data[end] != 0 {
+			end++
+		}
+		strs = append(strs, strings.TrimSpace(string(data[:end])))
+		if end >= len(data) {
+			break
+		}
+		data = data[end+1:]
+	}
+	return strs
+}
+
+func getDMIInfo() DMIInfo {
+	dmi := func(name string) string {
+		return readSysString(filepath.Join("/sys/class/dmi/id", name))
+	}
+
+	return DMIInfo{
+		SystemVendor:   dmi("sys_vendor"),
+		ProductName:    dmi("product_name"),
+		ProductVersion: dmi("product_version"),
+		ProductSerial:  dmi("product_serial"),
+		BoardVendor:    dmi("board_vendor"),
+		BoardName:      dmi("board_name"),
+		BoardVersion:   dmi("board_version"),
+		BoardSerial:    dmi("board_serial"),
+		BIOSVendor:     dmi("bios_vendor"),
+		BIOSVersion:    dmi("bios_version"),
+		BIOSDate:       dmi("bios_date"),
+		ChassisType:    dmi("chassis_type"),
+	}
+}
+
+func getPCIDevices() []PCIDevice {
+	devices := []PCIDevice{}
+
+	dirs, _ := filepath.Glob("/sys/bus/pci/devices/*")
+	sort.Strings(dirs)
+
+	ids := loadPCIIDs()
+	for _, dir := range dirs {
+		vendorID := strings.TrimPrefix(readSysString(filepath.Join(dir, "vendor")), "0x")
+		deviceID := strings.TrimPrefix(readSysString(filepath.Join(dir, "device")), "0x")
+		// class is 0xCCSSPP (class, subclass, programming interface)
+		classID := strings.TrimPrefix(readSysString(filepath.Join(dir, "class")), "0x")
+		if len(classID) >= 4 {
+			classID = classID[:4]
+		}
+
+		devices = append(devices, PCIDevice{
+			Address:    filepath.Base(dir),
+			VendorID:   vendorID,
+			DeviceID:   deviceID,
+			Vendor:     ids.vendor(vendorID),
+			Device:     ids.device(vendorID, deviceID),
+			ClassID:    classID,
+			Class:      ids.class(classID),
+			Driver:     linkBase(filepath.Join(dir, "driver")),
+			IOMMUGroup: linkBase(filepath.Join(dir, "iommu_group")),
+		})
+	}
+
+	return devices
+}
+
+func getUSBDevices() []USBDevice {
+	devices := []USBDevice{}
+
+	dirs, _ := filepath.Glob("/sys/bus/usb/devices/*")
+	sort.Strings(dirs)
+
+	ids := loadUSBIDs()
+	for _, dir := range dirs {
+		// Interfaces (1-1:1.0) have no idVendor; only devices and root hubs do
+		vendorID := readSysString(filepath.Join(dir, "idVendor"))
+		if vendorID == "" {
+			continue
+		}
+		productID := readSysString(filepath.Join(dir, "idProduct"))
+
+		bus, _ := strconv.Atoi(readSysString(filepath.Join(dir, "busnum")))
+		devnum, _ := strconv.Atoi(readSysString(filepath.Join(dir, "devnum")))
+
+		device := USBDevice{
+			Bus:          bus,
+			DeviceNum:    devnum,
+			Path:         filepath.Base(dir),
+			VendorID:     vendorID,
+			ProductID:    productID,
+			Vendor:       ids.vendor(vendorID),
+			Product:      ids.device(vendorID, productID),
+			Manufacturer: readSysString(filepath.Join(dir, "manufacturer")),
+			Serial:       readSysString(filepath.Join(dir, "serial")),
+			Speed:        readSysString(filepath.Join(dir, "speed")),
+			Driver:       linkBase(filepath.Join(dir, "driver")),
+		}
+		if device.Product == "" {
+			device.Product = readSysString(filepath.Join(dir, "product"))
+		}
+		if device.Vendor == "" {
+			device.Vendor = device.Manufacturer
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+func getBlockDevices() []BlockDevice {
+	devices := []BlockDevice{}
+
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return devices
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+
+		dir := filepath.Join("/sys/block", name)
+		serial := readSysString(filepath.Join(dir, "device", "serial"))
+		if serial == "" {
+			serial = readSysString(filepath.Join(dir, "device", "wwid"))
+		}
+
+		devices = append(devices, BlockDevice{
+			Name:       name,
+			Size:       readSysUint(filepath.Join(dir, "size")) * diskSectorSize,
+			Model:      readSysString(filepath.Join(dir, "device", "model")),
+			Vendor:     readSysString(filepath.Join(dir, "device", "vendor")),
+			Serial:     serial,
+			Rotational: readSysString(filepath.Join(dir, "queue", "rotational")) == "1",
+			Removable:  readSysString(filepath.Join(dir, "removable")) == "1",
+			Transport:  blockTransport(name, dir),
+		})
+	}
+
+	return devices
+}
+
+func blockTransport(name, dir string) string {
+	switch {
+	case strings.HasPrefix(name, "nvme"):
+		return "nvme"
+	case strings.HasPrefix(name, "vd"):
+		return "virtio"
+	case strings.HasPrefix(name, "mmcblk"):
+		return "mmc"
+	case strings.HasPrefix(name, "zram"):
+		return "zram"
+	}
+
+	target, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.Contains(target, "/usb"):
+		return "usb"
+	case strings.Contains(target, "/ata"):
+		return "sata"
+	}
+	return ""
+}
+
+func getNICInventory() []NICInventory {
+	nics := []NICInventory{}
+
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return nics
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(sysClassNet, entry.Name())
+		// Only physical interfaces have a backing device
+		if !fileExists(filepath.Join(dir, "device")) {
+			continue
+		}
+
+		speed, err := strconv.Atoi(readSysString(filepath.Join(dir, "speed")))
+		if err != nil {
+			speed = -1
+		}
+
+		nics = append(nics, NICInventory{
+			Name:       entry.Name(),
+			MAC:        readSysString(filepath.Join(dir, "address")),
+			Driver:     linkBase(filepath.Join(dir, "device", "driver")),
+			PCIAddress: linkBase(filepath.Join(dir, "device")),
+			Speed:      speed,
+		})
+	}
+
+	return nics
+}
+
+// linkBase returns the last element of a sysfs symlink target (driver names etc.)
+func linkBase(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+func loadPCIIDs() *idDatabase {
+	pciIDsOnce.Do(func() {
+		pciIDs = loadIDDatabase(pciIDPaths)
+	})
+	return pciIDs
+}
+
+func loadUSBIDs() *idDatabase {
+	usbIDsOnce.Do(func() {
+		usbIDs = loadIDDatabase(usbIDPaths)
+	})
+	return usbIDs
+}
+
+// loadIDDatabase parses the first existing pci.ids/usb.ids file:
+//
+//	8086  Intel Corporation
+//	\t1237  440FX - 82441FX PMC [Natoma]
+//	C 03  Display controller
+//	\t00  VGA compatible controller
+func loadIDDatabase(paths []string) *idDatabase {
+	db := &idDatabase{
+		vendors: make(map[string]string),
+		devices: make(map[string]string),
+		classes: make(map[string]string),
+	}
+
+	var file *os.File
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err == nil {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		return db
+	}
+	defer file.Close()
+
+	// section is "vendor" or "class" depending on the last top-level line
+	section, vendor, class := "", "", ""
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			// Subsystems and programming interfaces are not needed
+			continue
+
+		case line[0] == '\t':
+			id, name, ok := splitIDLine(line[1:])
+			if !ok {
+				continue
+			}
+			switch section {
+			case "vendor":
+				db.devices[vendor+":"+id] = name
+			case "class":
+				db.classes[class+id] = name
+			}
+
+		case strings.HasPrefix(line, "C "):
+			id, name, ok := splitIDLine(line[2:])
+			if !ok {
+				continue
+			}
+			section, class = "class", id
+			db.classes[id] = name
+
+		default:
+			id, name, ok := splitIDLine(line)
+			if !ok || len(id) != 4 {
+				// Other usb.ids sections (AT, HID, L, ...) are not needed
+				section = ""
+				continue
+			}
+			section, vendor = "vendor", id
+			db.vendors[id] = name
+		}
+	}
+
+	return db
+}
+
+func splitIDLine(line string) (string, string, bool) {
+	parts := strings.SplitN(line, "  ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	id := strings.ToLower(strings.TrimSpace(parts[0]))
+	if _, err := strconv.ParseUint(id, 16, 32); err != nil {
+		return "", "", false
+	}
+	return id, strings.TrimSpace(parts[1]), true
+}
+
+func (db *idDatabase) vendor(vendorID string) string {
+	return db.vendors[strings.ToLower(vendorID)]
+}
+
+func (db *idDatabase) device(vendorID, deviceID string) string {
+	return db.devices[strings.ToLower(vendorID)+":"+strings.ToLower(deviceID)]
+}
+
+// class resolves "0300" to the subclass name, falling back to the class name
+func (db *idDatabase) class(classID string) string {
+	classID = strings.ToLower(classID)
+	if name, ok := db.classes[classID]; ok {
+		return name
+	}
+	if len(classID) >= 2 {
+		return db.classes[classID[:2]]
+	}
+	return ""
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type JournalEntry struct {
+	Cursor           string    `json:"cursor"`
+	Timestamp        time.Time `json:"timestamp"`
+	Priority         int       `json:"priority"`
+	PriorityName     string    `json:"priority_name"`
+	Unit             string    `json:"unit"`
+	UserUnit         string    `json:"user_unit,omitempty"`
+	SyslogIdentifier string    `json:"syslog_identifier"`
+	Command          string    `json:"command"`
+	PID              int       `json:"pid"`
+	Hostname         string    `json:"hostname"`
+	BootID           string    `json:"boot_id"`
+	Transport        string    `json:"transport"`
+	Message          string    `json:"message"`
+}
+
+type JournalBoot struct {
+	Index      int        `json:"index"`
+	BootID     string     `json:"boot_id"`
+	FirstEntry *time.Time `json:"first_entry"`
+	LastEntry  *time.Time `json:"last_entry"`
+}
+
+// journalQuery holds the filters shared by the query and follow endpoints
+type journalQuery struct {
+	Units     []string
+	UserUnits []string
+	Priority  string
+	Boot      string
+	Since     string
+	Until     string
+	PID       int
+	Text      string
+}
+
+const (
+	defaultJournalLimit = 100
+	maxJournalLimit     = 1000
+)
+
+var journalPriorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// journalOutputFields limits the JSON output to what JournalEntry uses.
+// __CURSOR and __REALTIME_TIMESTAMP are always included.
+var journalOutputFields = []string{
+	"MESSAGE", "PRIORITY", "_SYSTEMD_UNIT", "_SYSTEMD_USER_UNIT", "UNIT", "USER_UNIT",
+	"SYSLOG_IDENTIFIER", "_COMM", "_PID", "_HOSTNAME", "_BOOT_ID", "_TRANSPORT",
+}
+
+// journalBootPattern accepts a boot offset (0, -1) or a 128-bit boot ID
+var journalBootPattern = regexp.MustCompile(`^(-?[0-9]+|[0-9a-f]{32})$`)
+
+// QueryJournal returns journal entries, newest first, one page at a time.
+// Pass next_cursor back as ?cursor= to get older entries, or combine it with
+// ?direction=forward to get entries newer than the cursor in chronological order.
+func QueryJournal(c *gin.Context) {
+	query, err := parseJournalQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// /services/:service/logs is scoped to that unit
+	if c.Param("service") != "" {
+		unit, ok := unitParam(c)
+		if !ok {
+			return
+		}
+		query.Units = []string{unit}
+	}
+
+	limit := defaultJournalLimit
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, maxJournalLimit)
+	}
+
+	cursor := c.Query("cursor")
+	forward := c.Query("direction") == "forward"
+
+	args := append([]string{"--no-pager", "-o", "json"}, query.args()...)
+	if !forward {
+		args = append(args, "--reverse")
+	}
+	if cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	}
+	// One extra entry tells us whether there is another page
+	args = append(args, "-n", strconv.Itoa(limit+1))
+
+	entries, err := readJournal(c.Request.Context(), args)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hasMore := len(entries) > limit
+	if hasMore {
+		entries = entries[:limit]
+	}
+
+	nextCursor := cursor
+	if len(entries) > 0 {
+		nextCursor = entries[len(entries)-1].Cursor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":     entries,
+		"next_cursor": nextCursor,
+		"has_more":    hasMore,
+	})
+}
+
+// ListJournalBoots returns the boots recorded in the journal, most recent last
+func ListJournalBoots(c *gin.Context) {
+	output, err := exec.Command("journalctl", "--no-pager", "--list-boots", "-o", "json").Output()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list boots: " + err.Error()})
+		return
+	}
+
+	boots, err := parseJournalBoots(output)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"boots": boots})
+}
+
+// StreamJournal follows the journal over a WebSocket and sends each new entry
+// as a JSON message. ?lines= sends that many recent entries first and
+// ?cursor= resumes after the last entry the client has seen.
+func StreamJournal(c *gin.Context) {
+	query, err := parseJournalQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := append([]string{"--no-pager", "-o", "json", "--follow"}, query.args()...)
+	if cursor := c.Query("cursor"); cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		lines := 0
+		if v, err := strconv.Atoi(c.Query("lines")); err == nil && v > 0 {
+			lines = min(v, maxJournalLimit)
+		}
+		args = append(args, "-n", strconv.Itoa(lines))
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "WebSocket upgrade failed"})
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		conn.WriteJSON(gin.H{"error": err.Error()})
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		conn.WriteJSON(gin.H{"error": err.Error()})
+		return
+	}
+	defer cmd.Wait()
+
+	// クライアントが切断したらjournalctlを終了する
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		entry, ok := parseJournalEntry(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		if err := conn.WriteJSON(entry); err != nil {
+			return
+		}
+	}
+}
+
+// recentUnitLogs returns the last n journal entries of a unit in chronological order
+func recentUnitLogs(unit string, n int) ([]JournalEntry, error) {
+	query := journalQuery{Units: []string{unit}}
+	args := append([]string{"--no-pager", "-o", "json", "-n", strconv.Itoa(n)}, query.args()...)
+	return readJournal(context.Background(), args)
+}
+
+func parseJournalQuery(c *gin.Context) (journalQuery, error) {
+	query := journalQuery{
+		Since: c.Query("since"),
+		Until: c.Query("until"),
+		Text:  c.Query("text"),
+	}
+
+	for _, unit := range splitQueryList(c.QueryArray("unit")) {
+		if !validUnitName(unit) {
+			return query, fmt.Errorf("invalid unit name: %s", unit)
+		}
+		query.Units = append(query.Units, unit)
+	}
+	for _, unit := range splitQueryList(c.QueryArray("user_unit")) {
+		if !validUnitName(unit) {
+			return query, fmt.Errorf("invalid unit name: %s", unit)
+		}
+		query.UserUnits = append(query.UserUnits, unit)
+	}
+
+	if priority := c.Query("priority"); priority != "" {
+		// A single level means "this level and more important", a range is from..to
+		for _, level := range strings.SplitN(priority, "..", 2) {
+			if _, ok := journalPriority(level); !ok {
+				return query, fmt.Errorf("invalid priority: %s", level)
+			}
+		}
+		query.Priority = priority
+	}
+
+	if boot := c.Query("boot"); boot != "" && boot != "all" {
+		if !journalBootPattern.MatchString(boot) {
+			return query, fmt.Errorf("invalid boot: %s", boot)
+		}
+		query.Boot = boot
+	}
+
+	if pid := c.Query("pid"); pid != "" {
+		v, err := strconv.Atoi(pid)
+		if err != nil || v <= 0 {
+			return query, fmt.Errorf("invalid pid: %s", pid)
+		}
+		query.PID = v
+	}
+
+	var err error
+	if query.Since, err = journalTime(query.Since); err != nil {
+		return query, err
+	}
+	if query.Until, err = journalTime(query.Until); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// args converts the filters into journalctl arguments
+func (q journalQuery) args() []string {
+	args := []string{"--output-fields=" + strings.Join(journalOutputFields, ",")}
+
+	for _, unit := range q.Units {
+		args = append(args, "--unit="+unit)
+	}
+	for _, unit := range q.UserUnits {
+		args = append(args, "--user-unit="+unit)
+	}
+	if q.Priority != "" {
+		args = append(args, "--priority="+q.Priority)
+	}
+	if q.Boot != "" {
+		args = append(args, "--boot="+q.Boot)
+	}
+	if q.Since != "" {
+		args = append(args, "--since="+q.Since)
+	}
+	if q.Until != "" {
+		args = append(args, "--until="+q.Until)
+	}
+	if q.Text != "" {
+		// Free text search, not a pattern
+		args = append(args, "--grep="+regexp.QuoteMeta(q.Text), "--case-sensitive=false")
+	}
+	if q.PID > 0 {
+		// Field matches are positional arguments
+		args = append(args, "_PID="+strconv.Itoa(q.PID))
+	}
+
+	return args
+}
+
+// readJournal runs journalctl with JSON output and parses every entry
+func readJournal(ctx context.Context, args []string) ([]JournalEntry, error) {
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("journalctl failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		// journalctl exits with 1 when --grep matches nothing
+		if len(output) == 0 && cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == 1 {
+			return []JournalEntry{}, nil
+		}
+		return nil, fmt.Errorf("journalctl failed: %v", err)
+	}
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if entry, ok := parseJournalEntry(scanner.Bytes()); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func parseJournalEntry(line []byte) (JournalEntry, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return JournalEntry{}, false
+	}
+
+	entry := JournalEntry{
+		Cursor:           journalField(fields["__CURSOR"]),
+		Priority:         6,
+		Unit:             journalField(fields["_SYSTEMD_UNIT"]),
+		UserUnit:         journalField(fields["_SYSTEMD_USER_UNIT"]),
+		SyslogIdentifier: journalField(fields["SYSLOG_IDENTIFIER"]),
+		Command:          journalField(fields["_COMM"]),
+		Hostname:         journalField(fields["_HOSTNAME"]),
+		BootID:           journalField(fields["_BOOT_ID"]),
+		Transport:        journalField(fields["_TRANSPORT"]),
+		Message:          journalField(fields["MESSAGE"]),
+	}
+
+	// Messages systemd logs about a unit carry it in UNIT= instead of _SYSTEMD_UNIT=
+	if unit := journalField(fields["UNIT"]); unit != "" {
+		entry.Unit = unit
+	}
+	if unit := journalField(fields["USER_UNIT"]); unit != "" {
+		entry.UserUnit = unit
+	}
+
+	if usec, err := strconv.ParseInt(journalField(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		entry.Timestamp = time.UnixMicro(usec)
+	}
+	if priority, err := strconv.Atoi(journalField(fields["PRIORITY"])); err == nil && priority >= 0 && priority < len(journalPriorityNames) {
+		entry.Priority = priority
+	}
+	entry.PriorityName = journalPriorityNames[entry.Priority]
+	entry.PID, _ = strconv.Atoi(journalField(fields["_PID"]))
+
+	return entry, true
+}
+
+// journalField decodes a field of journalctl's JSON output. Values are strings,
+// byte arrays for non-UTF-8 data, null when too large, or arrays when a field
+// occurs more than once in the entry.
+func journalField(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+
+	var data []byte
+	var numbers []int
+	if err := json.Unmarshal(raw, &numbers); err == nil {
+		for _, n := range numbers {
+			data = append(data, byte(n))
+		}
+		return strings.ToValidUTF8(string(data), "�")
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err == nil && len(values) > 0 {
+		return journalField(values[0])
+	}
+
+	return ""
+}
+
+// journalPriority accepts a syslog level by number (0-7) or name
+func journalPriority(level string) (int, bool) {
+	if v, err := strconv.Atoi(level); err == nil {
+		return v, v >= 0 && v < len(journalPriorityNames)
+	}
+	for i, name := range journalPriorityNames {
+		if level == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// journalTime converts RFC 3339 and unix timestamps into journalctl's @epoch
+// form. Anything else ("yesterday", "-1h", "2024-01-02 10:00") is passed
+// through for journalctl to parse.
+func journalTime(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return "@" + strconv.FormatInt(t.Unix(), 10), nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "@" + strconv.FormatInt(secs, 10), nil
+	}
+	if strings.ContainsAny(value, "\n\x00") {
+		return "", fmt.Errorf("invalid time: %q", value)
+	}
+	return value, nil
+}
+
+// parseJournalBoots parses `journalctl --list-boots -o json`. Older journalctl
+// versions ignore -o and print a table, which is parsed as a fallback.
+func parseJournalBoots(output []byte) ([]JournalBoot, error) {
+	boots := []JournalBoot{}
+
+	var records []struct {
+		Index      int    `json:"index"`
+		BootID     string `json:"boot_id"`
+		FirstEntry int64  `json:"first_entry"`
+		LastEntry  int64  `json:"last_entry"`
+	}
+	if err := json.Unmarshal(output, &records); err == nil {
+		for _, r := range records {
+			first := time.UnixMicro(r.FirstEntry)
+			last := time.UnixMicro(r.LastEntry)
+			boots = append(boots, JournalBoot{
+				Index:      r.Index,
+				BootID:     r.BootID,
+				FirstEntry: &first,
+				LastEntry:  &last,
+			})
+		}
+		return boots, nil
+	}
+
+	// " -1 0123456789abcdef0123456789abcdef Mon 2024-01-01 10:00:00 UTC—Mon 2024-01-01 12:00:00 UTC"
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !journalBootPattern.MatchString(fields[1]) {
+			continue
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		boots = append(boots, JournalBoot{Index: index, BootID: fields[1]})
+	}
+
+	return boots, nil
+}
+
+// splitQueryList accepts both repeated (?unit=a&unit=b) and comma separated (?unit=a,b) values
+func splitQueryList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RouteInfo struct {
+	Family      string `json:"family"`
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
+	Interface   string `json:"interface"`
+	Source      string `json:"source"`
+	Metric      uint32 `json:"metric"`
+	Protocol    string `json:"protocol"`
+	Scope       string `json:"scope"`
+	Default     bool   `json:"default"`
+}
+
+// netCounters holds the cumulative counters from /sys/class/net/<iface>/statistics
+type netCounters struct {
+	RxBytes   uint64
+	TxBytes   uint64
+	RxPackets uint64
+	TxPackets uint64
+	RxErrors  uint64
+	TxErrors  uint64
+	RxDropped uint64
+	TxDropped uint64
+}
+
+const sysClassNet = "/sys/class/net"
+
+var netSampler = newCounterSampler(readNetCounters)
+
+// GetNetworkDetails returns interfaces, the routing table and default gateways
+func GetNetworkDetails(c *gin.Context) {
+	routes, err := getRoutes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read routing table: " + err.Error()})
+		return
+	}
+
+	gateways := []RouteInfo{}
+	for _, route := range routes {
+		if route.Default {
+			gateways = append(gateways, route)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interfaces":       getNetworkStats(),
+		"routes":           routes,
+		"default_gateways": gateways,
+	})
+}
+
+func getNetworkStats() []NetworkStats {
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return []NetworkStats{}
+	}
+
+	addrs, _ := getInterfaceAddrs()
+	prev, cur, elapsed := netSampler.sample()
+
+	networkStats := []NetworkStats{}
+	for _, entry := range entries {
+		name := entry.Name()
+		dir := filepath.Join(sysClassNet, name)
+
+		c := cur[name]
+		p, ok := prev[name]
+		if !ok {
+			p = c
+		}
+
+		operState := readSysString(filepath.Join(dir, "operstate"))
+		carrier := readSysString(filepath.Join(dir, "carrier")) == "1"
+		flags, _ := strconv.ParseUint(strings.TrimPrefix(readSysString(filepath.Join(dir, "flags")), "0x"), 16, 32)
+		mtu, _ := strconv.Atoi(readSysString(filepath.Join(dir, "mtu")))
+		adminUp := flags&syscall.IFF_UP != 0
+
+		// loとトンネルはoperstateを報告しない（unknown）ので管理状態で判断する
+		status := "down"
+		if operState == "up" || operState == "unknown" && adminUp {
+			status = "up"
+		}
+
+		// speed is unreadable (EINVAL) or -1 while the link is down and for virtual devices
+		speed, err := strconv.Atoi(readSysString(filepath.Join(dir, "speed")))
+		if err != nil {
+			speed = -1
+		}
+
+		stats := NetworkStats{
+			Interface:    name,
+			RxBytes:      c.RxBytes,
+			TxBytes:      c.TxBytes,
+			RxPackets:    c.RxPackets,
+			TxPackets:    c.TxPackets,
+			Status:       status,
+			OperState:    operState,
+			AdminUp:      adminUp,
+			Carrier:      carrier,
+			MTU:          mtu,
+			MAC:          readSysString(filepath.Join(dir, "address")),
+			Speed:        speed,
+			Duplex:       readSysString(filepath.Join(dir, "duplex")),
+			Virtual:      !fileExists(filepath.Join(dir, "device")),
+			IPv4:         addrs[name].ipv4,
+			IPv6:         addrs[name].ipv6,
+			RxErrors:     c.RxErrors,
+			TxErrors:     c.TxErrors,
+			RxDropped:    c.RxDropped,
+			TxDropped:    c.TxDropped,
+			RxBytesSec:   counterRate(p.RxBytes, c.RxBytes, elapsed),
+			TxBytesSec:   counterRate(p.TxBytes, c.TxBytes, elapsed),
+			RxPacketsSec: counterRate(p.RxPackets, c.RxPackets, elapsed),
+			TxPacketsSec: counterRate(p.TxPackets, c.TxPackets, elapsed),
+		}
+		if stats.IPv4 == nil {
+			stats.IPv4 = []string{}
+		}
+		if stats.IPv6 == nil {
+			stats.IPv6 = []string{}
+		}
+
+		networkStats = append(networkStats, stats)
+	}
+
+	return networkStats
+}
+
+func readNetCounters() map[string]netCounters {
+	counters := make(map[string]netCounters)
+
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return counters
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(sysClassNet, entry.Name(), "statistics")
+		counters[entry.Name()] = netCounters{
+			RxBytes:   readSysUint(filepath.Join(dir, "rx_bytes")),
+			TxBytes:   readSysUint(filepath.Join(dir, "tx_bytes")),
+			RxPackets: readSysUint(filepath.Join(dir, "rx_packets")),
+			TxPackets: readSysUint(filepath.Join(dir, "tx_packets")),
+			RxErrors:  readSysUint(filepath.Join(dir, "rx_errors")),
+			TxErrors:  readSysUint(filepath.Join(dir, "tx_errors")),
+			RxDropped: readSysUint(filepath.Join(dir, "rx_dropped")),
+			TxDropped: readSysUint(filepath.Join(dir, "tx_dropped")),
+		}
+	}
+
+	return counters
+}
+
+type interfaceAddrs struct {
+	ipv4 []string
+	ipv6 []string
+}
+
+// getInterfaceAddrs dumps all addresses over netlink (RTM_GETADDR), keyed by interface name
+func getInterfaceAddrs() (map[string]interfaceAddrs, error) {
+	names, err := interfaceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := netlinkDump(syscall.RTM_GETADDR)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make(map[string]interfaceAddrs)
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			continue
+		}
+
+		// IFA_LOCAL is the local address on point-to-point links, IFA_ADDRESS the peer
+		var ip net.IP
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				if ip == nil {
+					ip = net.IP(a.Value)
+				}
+			case syscall.IFA_LOCAL:
+				ip = net.IP(a.Value)
+			}
+		}
+		if ip == nil {
+			continue
+		}
+
+		name := names[int(ifa.Index)]
+		cidr := (&net.IPNet{IP: ip, Mask: net.CIDRMask(int(ifa.Prefixlen), len(ip)*8)}).String()
+
+		entry := addrs[name]
+		if ifa.Family == syscall.AF_INET {
+			entry.ipv4 = append(entry.ipv4, cidr)
+		} else {
+			entry.ipv6 = append(entry.ipv6, cidr)
+		}
+		addrs[name] = entry
+	}
+
+	return addrs, nil
+}
+
+// getRoutes dumps the main routing table over netlink (RTM_GETROUTE)
+func getRoutes() ([]RouteInfo, error) {
+	names, err := interfaceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := netlinkDump(syscall.RTM_GETROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := []RouteInfo{}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Type != syscall.RTN_UNICAST {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			continue
+		}
+
+		table := uint32(rt.Table)
+		route := RouteInfo{
+			Family:   "ipv4",
+			Protocol: routeProtocolName(rt.Protocol),
+			Scope:    routeScopeName(rt.Scope),
+		}
+		if rt.Family == syscall.AF_INET6 {
+			route.Family = "ipv6"
+		}
+
+		var dst net.IP
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_DST:
+				dst = net.IP(a.Value)
+			case syscall.RTA_GATEWAY:
+				route.Gateway = net.IP(a.Value).String()
+			case syscall.RTA_PREFSRC:
+				route.Source = net.IP(a.Value).String()
+			case syscall.RTA_OIF:
+				route.Interface = names[int(binary.NativeEndian.Uint32(a.Value))]
+			case syscall.RTA_PRIORITY:
+				route.Metric = binary.NativeEndian.Uint32(a.Value)
+			case syscall.RTA_TABLE:
+				table = binary.NativeEndian.Uint32(a.Value)
+			}
+		}
+		if table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+
+		if dst == nil {
+			route.Default = rt.Dst_len == 0
+			if rt.Family == syscall.AF_INET6 {
+				dst = net.IPv6zero
+			} else {
+				dst = net.IPv4zero.To4()
+			}
+		}
+		route.Destination = (&net.IPNet{IP: dst, Mask: net.CIDRMask(int(rt.Dst_len), len(dst)*8)}).String()
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// netlinkDump sends a dump request of the given type for all address families
+func netlinkDump(proto int) ([]syscall.NetlinkMessage, error) {
+	data, err := syscall.NetlinkRIB(proto, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	return syscall.ParseNetlinkMessage(data)
+}
+
+func interfaceNames() (map[int]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string, len(ifaces))
+	for _, iface := range ifaces {
+		names[iface.Index] = iface.Name
+	}
+	return names, nil
+}
+
+func routeProtocolName(proto uint8) string {
+	switch proto {
+	case syscall.RTPROT_KERNEL:
+		return "kernel"
+	case syscall.RTPROT_BOOT:
+		return "boot"
+	case syscall.RTPROT_STATIC:
+		return "static"
+	case syscall.RTPROT_RA:
+		return "ra"
+	case syscall.RTPROT_DHCP:
+		return "dhcp"
+	}
+	return strconv.Itoa(int(proto))
+}
+
+func routeScopeName(scope uint8) string {
+	switch scope {
+	case syscall.RT_SCOPE_UNIVERSE:
+		return "global"
+	case syscall.RT_SCOPE_SITE:
+		return "site"
+	case syscall.RT_SCOPE_LINK:
+		return "link"
+	case syscall.RT_SCOPE_HOST:
+		return "host"
+	}
+	return strconv.Itoa(int(scope))
+}
+
+// readSysString reads a single-value sysfs/procfs attribute
+func readSysString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readSysUint(path string) uint64 {
+	value, _ := strconv.ParseUint(readSysString(path), 10, 64)
+	return value
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
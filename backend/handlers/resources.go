@@ -8,15 +8,20 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 type SystemResources struct {
-	CPU     CPUStats       `json:"cpu"`
-	Memory  MemoryStats    `json:"memory"`
-	Disk    []DiskStats    `json:"disk"`
-	Network []NetworkStats `json:"network"`
+	CPU      CPUStats                 `json:"cpu"`
+	Memory   MemoryStats              `json:"memory"`
+	Disk     []DiskStats              `json:"disk"`
+	DiskIO   []DiskIOStats            `json:"disk_io"`
+	Network  []NetworkStats           `json:"network"`
+	Pressure map[string]PressureStats `json:"pressure"`
+	Sensors  SensorStats              `json:"sensors"`
 }
 
 type CPUStats struct {
@@ -42,22 +47,45 @@ type SwapStats struct {
 }
 
 type DiskStats struct {
-	Device     string  `json:"device"`
-	Mountpoint string  `json:"mountpoint"`
-	Filesystem string  `json:"filesystem"`
-	Total      uint64  `json:"total"`
-	Used       uint64  `json:"used"`
-	Available  uint64  `json:"available"`
-	Usage      float64 `json:"usage"`
+	Device      string  `json:"device"`
+	Mountpoint  string  `json:"mountpoint"`
+	Filesystem  string  `json:"filesystem"`
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Available   uint64  `json:"available"`
+	Usage       float64 `json:"usage"`
+	InodesTotal uint64  `json:"inodes_total"`
+	InodesUsed  uint64  `json:"inodes_used"`
+	InodesFree  uint64  `json:"inodes_free"`
+	InodeUsage  float64 `json:"inode_usage"`
+	ReadOnly    bool    `json:"read_only"`
 }
 
 type NetworkStats struct {
-	Interface string `json:"interface"`
-	RxBytes   uint64 `json:"rx_bytes"`
-	TxBytes   uint64 `json:"tx_bytes"`
-	RxPackets uint64 `json:"rx_packets"`
-	TxPackets uint64 `json:"tx_packets"`
-	Status    string `json:"status"`
+	Interface    string   `json:"interface"`
+	RxBytes      uint64   `json:"rx_bytes"`
+	TxBytes      uint64   `json:"tx_bytes"`
+	RxPackets    uint64   `json:"rx_packets"`
+	TxPackets    uint64   `json:"tx_packets"`
+	Status       string   `json:"status"`     // up or down
+	OperState    string   `json:"oper_state"` // operstate as reported by the kernel
+	AdminUp      bool     `json:"admin_up"`
+	Carrier      bool     `json:"carrier"`
+	MTU          int      `json:"mtu"`
+	MAC          string   `json:"mac"`
+	Speed        int      `json:"speed"`
+	Duplex       string   `json:"duplex"`
+	Virtual      bool     `json:"virtual"`
+	IPv4         []string `json:"ipv4"`
+	IPv6         []string `json:"ipv6"`
+	RxErrors     uint64   `json:"rx_errors"`
+	TxErrors     uint64   `json:"tx_errors"`
+	RxDropped    uint64   `json:"rx_dropped"`
+	TxDropped    uint64   `json:"tx_dropped"`
+	RxBytesSec   float64  `json:"rx_bytes_sec"`
+	TxBytesSec   float64  `json:"tx_bytes_sec"`
+	RxPacketsSec float64  `json:"rx_packets_sec"`
+	TxPacketsSec float64  `json:"tx_packets_sec"`
 }
 
 type ProcessInfo struct {
@@ -76,14 +104,59 @@ type ProcessInfo struct {
 }
 
 func GetSystemResources(c *gin.Context) {
-	resources := SystemResources{
-		CPU:     getCPUStats(),
-		Memory:  getMemoryStats(),
-		Disk:    getDiskStats(),
-		Network: getNetworkStats(),
+	c.JSON(http.StatusOK, collectSystemResources())
+}
+
+// StreamSystemResources streams system resources via WebSocket
+func StreamSystemResources(c *gin.Context) {
+	interval := 2 * time.Second
+	if v, err := strconv.Atoi(c.Query("interval")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
 	}
 
-	c.JSON(http.StatusOK, resources)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "WebSocket upgrade failed"})
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := conn.WriteJSON(collectSystemResources()); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteJSON(collectSystemResources()); err != nil {
+				return
+			}
+
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func collectSystemResources() SystemResources {
+	return SystemResources{
+		CPU:      getCPUStats(),
+		Memory:   getMemoryStats(),
+		Disk:     getDiskStats(),
+		DiskIO:   getDiskIOStats(),
+		Network:  getNetworkStats(),
+		Pressure: getSystemPressure(),
+		Sensors:  getSensorStats(),
+	}
 }
 
 func getCPUStats() CPUStats {
@@ -268,126 +341,6 @@ func getMemoryStats() MemoryStats {
 	}
 }
 
-func getDiskStats() []DiskStats {
-	cmd := exec.Command("df", "-h")
-	output, err := cmd.Output()
-	if err != nil {
-		return []DiskStats{}
-	}
-
-	lines := strings.Split(string(output), "\n")
-	diskStats := []DiskStats{}
-
-	for i, line := range lines {
-		if i == 0 || line == "" {
-			continue // Skip header and empty lines
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) < 6 {
-			continue
-		}
-
-		// Parse sizes (remove suffix like M, G, T)
-		total := parseSize(fields[1])
-		used := parseSize(fields[2])
-		available := parseSize(fields[3])
-		usageStr := strings.TrimSuffix(fields[4], "%")
-		usage, _ := strconv.ParseFloat(usageStr, 64)
-
-		diskStats = append(diskStats, DiskStats{
-			Device:     fields[0],
-			Mountpoint: fields[5],
-			Filesystem: fields[0],
-			Total:      total,
-			Used:       used,
-			Available:  available,
-			Usage:      usage,
-		})
-	}
-
-	return diskStats
-}
-
-func parseSize(sizeStr string) uint64 {
-	if sizeStr == "-" {
-		return 0
-	}
-
-	multiplier := uint64(1)
-	size := sizeStr
-
-	if strings.HasSuffix(sizeStr, "K") {
-		multiplier = 1024
-		size = strings.TrimSuffix(sizeStr, "K")
-	} else if strings.HasSuffix(sizeStr, "M") {
-		multiplier = 1024 * 1024
-		size = strings.TrimSuffix(sizeStr, "M")
-	} else if strings.HasSuffix(sizeStr, "G") {
-		multiplier = 1024 * 1024 * 1024
-		size = strings.TrimSuffix(sizeStr, "G")
-	} else if strings.HasSuffix(sizeStr, "T") {
-		multiplier = 1024 * 1024 * 1024 * 1024
-		size = strings.TrimSuffix(sizeStr, "T")
-	}
-
-	value, err := strconv.ParseFloat(size, 64)
-	if err != nil {
-		return 0
-	}
-
-	return uint64(value * float64(multiplier))
-}
-
-func getNetworkStats() []NetworkStats {
-	data, err := ioutil.ReadFile("/proc/net/dev")
-	if err != nil {
-		return []NetworkStats{}
-	}
-
-	lines := strings.Split(string(data), "\n")
-	networkStats := []NetworkStats{}
-
-	for i, line := range lines {
-		if i < 2 || line == "" {
-			continue // Skip header lines and empty lines
-		}
-
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
-			continue
-		}
-
-		interfaceName := strings.TrimSpace(parts[0])
-		fields := strings.Fields(parts[1])
-
-		if len(fields) < 16 {
-			continue
-		}
-
-		rxBytes, _ := strconv.ParseUint(fields[0], 10, 64)
-		rxPackets, _ := strconv.ParseUint(fields[1], 10, 64)
-		txBytes, _ := strconv.ParseUint(fields[8], 10, 64)
-		txPackets, _ := strconv.ParseUint(fields[9], 10, 64)
-
-		status := "down"
-		if rxBytes > 0 || txBytes > 0 {
-			status = "up"
-		}
-
-		networkStats = append(networkStats, NetworkStats{
-			Interface: interfaceName,
-			RxBytes:   rxBytes,
-			TxBytes:   txBytes,
-			RxPackets: rxPackets,
-			TxPackets: txPackets,
-			Status:    status,
-		})
-	}
-
-	return networkStats
-}
-
 func KillProcess(c *gin.Context) {
 	var req struct {
 		PID    int    `json:"pid"`
@@ -404,8 +357,7 @@ func KillProcess(c *gin.Context) {
 		signal = "TERM"
 	}
 
-	cmd := exec.Command("kill", "-"+signal, strconv.Itoa(req.PID))
-	err := cmd.Run()
+	err := killProcess(req.PID, signal)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("Failed to kill process: %s", err.Error()),
@@ -418,6 +370,12 @@ func KillProcess(c *gin.Context) {
 	})
 }
 
+// killProcess sends a signal (e.g. TERM, KILL, HUP) to a process
+func killProcess(pid int, signal string) error {
+	cmd := exec.Command("kill", "-"+signal, strconv.Itoa(pid))
+	return cmd.Run()
+}
+
 func SetProcessPriority(c *gin.Context) {
 	var req struct {
 		PID      int `json:"pid"`
@@ -466,6 +424,23 @@ func GetDetailedSystemInfo(c *gin.Context) {
 	// Get OS info
 	osInfo, _ := ioutil.ReadFile("/etc/os-release")
 	info["os"] = string(osInfo)
+	info["os_release"] = parseOSRelease(string(osInfo))
+
+	// Get hardware inventory
+	info["hardware"] = getHardwareInventory()
 
 	c.JSON(http.StatusOK, info)
 }
+
+// parseOSRelease parses KEY="value" lines of /etc/os-release
+func parseOSRelease(content string) map[string]string {
+	release := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		release[parts[0]] = strings.Trim(parts[1], `"'`)
+	}
+	return release
+}
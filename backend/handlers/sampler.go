@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// counterSampler keeps the previous reading of cumulative kernel counters
+// (/proc/diskstats, /sys/class/net/*/statistics, ...) so that per-second
+// rates can be computed from the delta between two consecutive readings.
+type counterSampler[T any] struct {
+	mu   sync.Mutex
+	read func() T
+	prev T
+	at   time.Time
+}
+
+// samplerWarmup is how long sample waits for a second reading when there is
+// no recent previous one, so that the very first request still gets rates.
+const samplerWarmup = 250 * time.Millisecond
+
+// samplerMaxAge is the age after which a previous reading is considered too
+// old to compute meaningful rates from.
+const samplerMaxAge = time.Minute
+
+func newCounterSampler[T any](read func() T) *counterSampler[T] {
+	return &counterSampler[T]{read: read}
+}
+
+// sample returns the previous and current readings together with the number
+// of seconds between them.
+func (s *counterSampler[T]) sample() (prev, cur T, elapsed float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.at.IsZero() || time.Since(s.at) > samplerMaxAge {
+		s.prev = s.read()
+		s.at = time.Now()
+		time.Sleep(samplerWarmup)
+	}
+
+	now := time.Now()
+	cur = s.read()
+	prev = s.prev
+	elapsed = now.Sub(s.at).Seconds()
+
+	s.prev = cur
+	s.at = now
+
+	return prev, cur, elapsed
+}
+
+// counterRate returns the per-second rate of a cumulative counter, treating
+// counter resets (e.g. a device being re-plugged) as zero.
+func counterRate(prev, cur uint64, elapsed float64) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / elapsed
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SensorStats struct {
+	Temperatures []TemperatureSensor `json:"temperatures"`
+	Fans         []FanSensor         `json:"fans"`
+	Power        []PowerSensor       `json:"power"`
+}
+
+type TemperatureSensor struct {
+	Source   string   `json:"source"`
+	Chip     string   `json:"chip"`
+	Label    string   `json:"label"`
+	Current  float64  `json:"current"`
+	High     *float64 `json:"high"`
+	Critical *float64 `json:"critical"`
+}
+
+type FanSensor struct {
+	Chip  string `json:"chip"`
+	Label string `json:"label"`
+	RPM   int    `json:"rpm"`
+	Min   *int   `json:"min"`
+}
+
+type PowerSensor struct {
+	Source   string  `json:"source"`
+	Chip     string  `json:"chip"`
+	Label    string  `json:"label"`
+	Watts    float64 `json:"watts"`
+	EnergyUJ uint64  `json:"energy_uj,omitempty"`
+}
+
+const (
+	sysClassHwmon   = "/sys/class/hwmon"
+	sysClassThermal = "/sys/class/thermal"
+	sysClassRAPL    = "/sys/class/powercap"
+)
+
+// raplSampler tracks RAPL energy counters to derive average power draw
+var raplSampler = newCounterSampler(readRAPLEnergy)
+
+// GetSensors returns temperatures, fan speeds and power readings
+func GetSensors(c *gin.Context) {
+	c.JSON(http.StatusOK, getSensorStats())
+}
+
+func getSensorStats() SensorStats {
+	stats := SensorStats{
+		Temperatures: []TemperatureSensor{},
+		Fans:         []FanSensor{},
+		Power:        []PowerSensor{},
+	}
+
+	readHwmonSensors(&stats)
+	readThermalZones(&stats)
+	readRAPLPower(&stats)
+
+	return stats
+}
+
+// readHwmonSensors reads /sys/class/hwmon/hwmon*/{temp,fan,power}N_* attributes.
+// Values are in millidegrees Celsius, RPM and microwatts respectively.
+func readHwmonSensors(stats *SensorStats) {
+	chips, err := filepath.Glob(filepath.Join(sysClassHwmon, "hwmon*"))
+	if err != nil {
+		return
+	}
+
+	for _, dir := range chips {
+		chip := readSysString(filepath.Join(dir, "name"))
+
+		for _, input := range sortedGlob(filepath.Join(dir, "temp*_input")) {
+			prefix := strings.TrimSuffix(input, "_input")
+			value, ok := readSysInt(input)
+			if !ok {
+				continue
+			}
+
+			stats.Temperatures = append(stats.Temperatures, TemperatureSensor{
+				Source:   "hwmon",
+				Chip:     chip,
+				Label:    sensorLabel(prefix),
+				Current:  float64(value) / 1000.0,
+				High:     readMilliValue(prefix + "_max"),
+				Critical: readMilliValue(prefix + "_crit"),
+			})
+		}
+
+		for _, input := range sortedGlob(filepath.Join(dir, "fan*_input")) {
+			prefix := strings.TrimSuffix(input, "_input")
+			rpm, ok := readSysInt(input)
+			if !ok {
+				continue
+			}
+
+			fan := FanSensor{
+				Chip:  chip,
+				Label: sensorLabel(prefix),
+				RPM:   int(rpm),
+			}
+			if fanMin, ok := readSysInt(prefix + "_min"); ok {
+				v := int(fanMin)
+				fan.Min = &v
+			}
+			stats.Fans = append(stats.Fans, fan)
+		}
+
+		for _, prefix := range powerSensorPrefixes(dir) {
+			value, ok := readSysInt(prefix + "_input")
+			if !ok {
+				value, ok = readSysInt(prefix + "_average")
+			}
+			if !ok {
+				continue
+			}
+
+			stats.Power = append(stats.Power, PowerSensor{
+				Source: "hwmon",
+				Chip:   chip,
+				Label:  sensorLabel(prefix),
+				Watts:  float64(value) / 1e6,
+			})
+		}
+	}
+}
+
+// readThermalZones reads ACPI/SoC thermal zones and their critical trip point
+func readThermalZones(stats *SensorStats) {
+	zones, err := filepath.Glob(filepath.Join(sysClassThermal, "thermal_zone*"))
+	if err != nil {
+		return
+	}
+
+	for _, dir := range zones {
+		value, ok := readSysInt(filepath.Join(dir, "temp"))
+		if !ok {
+			continue
+		}
+
+		sensor := TemperatureSensor{
+			Source:  "thermal",
+			Chip:    filepath.Base(dir),
+			Label:   readSysString(filepath.Join(dir, "type")),
+			Current: float64(value) / 1000.0,
+		}
+
+		for _, tripType := range sortedGlob(filepath.Join(dir, "trip_point_*_type")) {
+			temp := readMilliValue(strings.TrimSuffix(tripType, "_type") + "_temp")
+			switch readSysString(tripType) {
+			case "critical":
+				sensor.Critical = temp
+			case "hot":
+				sensor.High = temp
+			}
+		}
+
+		stats.Temperatures = append(stats.Temperatures, sensor)
+	}
+}
+
+// readRAPLPower derives package/core/dram power from RAPL energy counters
+func readRAPLPower(stats *SensorStats) {
+	if !fileExists(sysClassRAPL) {
+		return
+	}
+
+	prev, cur, elapsed := raplSampler.sample()
+	for _, zone := range slices.Sorted(maps.Keys(cur)) {
+		dir := filepath.Join(sysClassRAPL, zone)
+
+		watts := 0.0
+		if p, ok := prev[zone]; ok && elapsed > 0 {
+			delta := cur[zone] - p
+			// energy_uj wraps around at max_energy_range_uj
+			if cur[zone] < p {
+				delta = cur[zone] + readSysUint(filepath.Join(dir, "max_energy_range_uj")) - p
+			}
+			watts = float64(delta) / 1e6 / elapsed
+		}
+
+		stats.Power = append(stats.Power, PowerSensor{
+			Source:   "rapl",
+			Chip:     zone,
+			Label:    readSysString(filepath.Join(dir, "name")),
+			Watts:    watts,
+			EnergyUJ: cur[zone],
+		})
+	}
+}
+
+func readRAPLEnergy() map[string]uint64 {
+	energy := make(map[string]uint64)
+
+	zones, err := filepath.Glob(filepath.Join(sysClassRAPL, "intel-rapl:*"))
+	if err != nil {
+		return energy
+	}
+
+	for _, dir := range zones {
+		value, err := strconv.ParseUint(readSysString(filepath.Join(dir, "energy_uj")), 10, 64)
+		if err != nil {
+			// energy_uj is root-only readable on recent kernels
+			continue
+		}
+		energy[filepath.Base(dir)] = value
+	}
+
+	return energy
+}
+
+// powerSensorPrefixes lists the powerN sensors of a chip; some only report
+// powerN_average and many have no label
+func powerSensorPrefixes(dir string) []string {
+	var prefixes []string
+	for _, pattern := range []string{"power*_input", "power*_average"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, match := range matches {
+			prefix := match[:strings.LastIndexByte(match, '_')]
+			if !slices.Contains(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	sortNatural(prefixes)
+	return prefixes
+}
+
+// sensorLabel returns the *_label attribute or falls back to the attribute name (temp1, fan2...)
+func sensorLabel(prefix string) string {
+	if label := readSysString(prefix + "_label"); label != "" {
+		return label
+	}
+	return filepath.Base(prefix)
+}
+
+func readSysInt(path string) (int64, bool) {
+	value, err := strconv.ParseInt(readSysString(path), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// readMilliValue reads an attribute in thousandths (e.g. millidegrees) if it exists
+func readMilliValue(path string) *float64 {
+	value, ok := readSysInt(path)
+	if !ok {
+		return nil
+	}
+	v := float64(value) / 1000.0
+	return &v
+}
+
+// sortedGlob returns glob matches in natural order so that temp10 sorts after temp9
+func sortedGlob(pattern string) []string {
+	matches, _ := filepath.Glob(pattern)
+	sortNatural(matches)
+	return matches
+}
+
+func sortNatural(paths []string) {
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) < len(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+}
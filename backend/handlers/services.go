@@ -1,51 +1,124 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ListServices returns a list of system services
+// ListServices returns systemd units (services, timers, sockets and mounts)
 func ListServices(c *gin.Context) {
-	cmd := exec.Command("bash", "-c", "systemctl list-units --type=service")
-	output, err := cmd.CombinedOutput()
+	types := systemdUnitTypes
+	if t := c.Query("type"); t != "" {
+		types = strings.Split(t, ",")
+	}
+	state := c.Query("state")
+
+	names, err := listUnitNames(types)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	units, err := showUnits(names)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// stateでactive/sub/load/enabled状態のいずれかに一致するものに絞り込む
+	filtered := []SystemdUnit{}
+	for _, unit := range units {
+		if state != "" && unit.ActiveState != state && unit.SubState != state &&
+			unit.LoadState != state && unit.UnitFileState != state {
+			continue
+		}
+		filtered = append(filtered, unit)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"services": string(output),
+		"units": filtered,
+		"total": len(filtered),
 	})
 }
 
-// GetServiceStatus returns the status of a specific service
+// GetServiceStatus returns the status of a specific unit
 func GetServiceStatus(c *gin.Context) {
 	service := c.Param("service")
-	cmd := exec.Command("bash", "-c", "systemctl status "+service)
-	output, err := cmd.CombinedOutput()
-	
-	// エラーチェックを追加
+	if !validUnitName(service) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unit name"})
+		return
+	}
+
+	unit, err := showUnit(service)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-			"status": string(output),
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	
-	c.JSON(http.StatusOK, gin.H{
-		"status": string(output),
-	})
+
+	if unit.LoadState == "not-found" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unit not found", "unit": unit})
+		return
+	}
+
+	response := gin.H{
+		"unit": unit,
+	}
+	// ?logs=N includes the unit's most recent journal entries
+	if n, err := strconv.Atoi(c.Query("logs")); err == nil && n > 0 {
+		logs, err := recentUnitLogs(unit.Name, min(n, maxJournalLimit))
+		if err != nil {
+			response["logs_error"] = err.Error()
+		} else {
+			response["logs"] = logs
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ServiceActionResult is the outcome of a control action on a single unit
+type ServiceActionResult struct {
+	Unit      string       `json:"unit"`
+	Action    string       `json:"action"`
+	Success   bool         `json:"success"`
+	JobResult string       `json:"job_result"`
+	Output    string       `json:"output"`
+	Error     string       `json:"error,omitempty"`
+	State     *SystemdUnit `json:"state,omitempty"`
+}
+
+// serviceActions maps API actions to systemctl arguments
+var serviceActions = map[string][]string{
+	"start":        {"start"},
+	"stop":         {"stop"},
+	"restart":      {"restart"},
+	"reload":       {"reload"},
+	"try-restart":  {"try-restart"},
+	"enable":       {"enable"},
+	"disable":      {"disable"},
+	"enable-now":   {"enable", "--now"},
+	"disable-now":  {"disable", "--now"},
+	"mask":         {"mask"},
+	"unmask":       {"unmask"},
+	"kill":         {"kill"},
+	"reset-failed": {"reset-failed"},
 }
 
-// ControlService starts, stops or restarts a service
+var signalPattern = regexp.MustCompile(`^(SIG)?[A-Z0-9+-]+$`)
+
+// ControlService runs a lifecycle action on one or more units
 func ControlService(c *gin.Context) {
 	var request struct {
-		Service string `json:"service"`
-		Action  string `json:"action"` // start, stop, restart
+		Service string   `json:"service"`
+		Units   []string `json:"units"`
+		Action  string   `json:"action"`
+		Signal  string   `json:"signal"` // kill only
 	}
 
 	if err := c.BindJSON(&request); err != nil {
@@ -53,24 +126,104 @@ func ControlService(c *gin.Context) {
 		return
 	}
 
+	// daemon-reloadはユニットを指定しない
+	if request.Action == "daemon-reload" {
+		output, err := daemonReload()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":  err.Error(),
+				"output": output,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Daemon reloaded",
+			"output":  output,
+		})
+		return
+	}
+
 	// アクションの検証
-	if request.Action != "start" && request.Action != "stop" && request.Action != "restart" {
+	baseArgs, ok := serviceActions[request.Action]
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action"})
 		return
 	}
 
-	cmd := exec.Command("bash", "-c", "systemctl "+request.Action+" "+request.Service)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":  err.Error(),
-			"output": string(output),
-		})
+	units := request.Units
+	if request.Service != "" {
+		units = append(units, request.Service)
+	}
+	if len(units) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one unit is required"})
 		return
 	}
+	for _, unit := range units {
+		if !validUnitName(unit) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unit name: " + unit})
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Service " + request.Action + " command executed",
-		"output":  string(output),
+	args := append([]string{"--no-ask-password"}, baseArgs...)
+	if request.Action == "kill" {
+		signal := request.Signal
+		if signal == "" {
+			signal = "SIGTERM"
+		}
+		if !signalPattern.MatchString(signal) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid signal"})
+			return
+		}
+		args = append(args, "--signal="+signal)
+	}
+
+	results := []ServiceActionResult{}
+	failed := 0
+	for _, unit := range units {
+		result := controlUnit(unit, request.Action, args)
+		if !result.Success {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	status := http.StatusOK
+	if failed == len(results) {
+		status = http.StatusInternalServerError
+	}
+
+	c.JSON(status, gin.H{
+		"message": fmt.Sprintf("Service %s executed on %d unit(s), %d failed", request.Action, len(results), failed),
+		"results": results,
 	})
 }
+
+// controlUnit runs systemctl for one unit and reports the resulting unit state.
+// systemctl waits for the queued job, so a non-zero exit means the job failed.
+func controlUnit(unit, action string, args []string) ServiceActionResult {
+	cmdArgs := append(append([]string{}, args...), "--", unit)
+	output, err := exec.Command("systemctl", cmdArgs...).CombinedOutput()
+
+	result := ServiceActionResult{
+		Unit:      unit,
+		Action:    action,
+		Success:   err == nil,
+		JobResult: "done",
+		Output:    strings.TrimSpace(string(output)),
+	}
+	if err != nil {
+		result.JobResult = "failed"
+		result.Error = err.Error()
+	}
+
+	if state, err := showUnit(unit); err == nil {
+		result.State = &state
+		// The unit's Result property explains failures (exit-code, timeout, signal...)
+		if !result.Success && state.Result != "" && state.Result != "success" {
+			result.JobResult = state.Result
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SocketInfo struct {
+	Protocol      string `json:"protocol"`
+	LocalAddress  string `json:"local_address"`
+	LocalPort     int    `json:"local_port"`
+	RemoteAddress string `json:"remote_address"`
+	RemotePort    int    `json:"remote_port"`
+	Path          string `json:"path,omitempty"`
+	State         string `json:"state"`
+	Listening     bool   `json:"listening"`
+	Inode         uint64 `json:"inode"`
+	PID           int    `json:"pid"`
+	Process       string `json:"process"`
+	User          string `json:"user"`
+	Container     string `json:"container"`
+}
+
+// socketOwner is the process holding a socket inode open
+type socketOwner struct {
+	PID       int
+	Process   string
+	UID       string
+	Container string
+}
+
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+var socketProtocols = []string{"tcp", "tcp6", "udp", "udp6", "unix"}
+
+// containerIDPattern matches docker/containerd/podman scopes in /proc/<pid>/cgroup
+var containerIDPattern = regexp.MustCompile(`(?:docker|containerd|libpod|cri-containerd|crio)[-/]([0-9a-f]{64})`)
+
+// ListSockets returns listening sockets and connections with their owning processes.
+// Only sockets in the network namespace of this server are visible.
+func ListSockets(c *gin.Context) {
+	protocols := socketProtocols
+	if p := c.Query("protocol"); p != "" {
+		protocols = strings.Split(p, ",")
+	}
+
+	state := strings.ToUpper(c.Query("state"))
+	port, _ := strconv.Atoi(c.Query("port"))
+	pid, _ := strconv.Atoi(c.Query("pid"))
+	process := c.Query("process")
+	listening := c.Query("listening")
+
+	sockets, err := getSockets(protocols)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filtered := []SocketInfo{}
+	for _, s := range sockets {
+		if state != "" && s.State != state {
+			continue
+		}
+		if port != 0 && s.LocalPort != port && s.RemotePort != port {
+			continue
+		}
+		if pid != 0 && s.PID != pid {
+			continue
+		}
+		if process != "" && !strings.Contains(s.Process, process) {
+			continue
+		}
+		if listening != "" && s.Listening != (listening == "true") {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sockets": filtered,
+		"total":   len(filtered),
+	})
+}
+
+// KillSocketOwner kills the process listening on a port, reusing the KillProcess flow
+func KillSocketOwner(c *gin.Context) {
+	var req struct {
+		Protocol string `json:"protocol"`
+		Port     int    `json:"port"`
+		Signal   string `json:"signal"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Port == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Port is required"})
+		return
+	}
+
+	protocols := []string{"tcp", "tcp6", "udp", "udp6"}
+	if req.Protocol != "" {
+		// "tcp6"でもIPv4側を含めて探す
+		base := strings.TrimSuffix(req.Protocol, "6")
+		protocols = []string{base, base + "6"}
+	}
+
+	sockets, err := getSockets(protocols)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pid := 0
+	for _, s := range sockets {
+		if s.Listening && s.LocalPort == req.Port && s.PID != 0 {
+			pid = s.PID
+			break
+		}
+	}
+	if pid == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("No process found listening on port %d", req.Port)})
+		return
+	}
+
+	signal := req.Signal
+	if signal == "" {
+		signal = "TERM"
+	}
+
+	if err := killProcess(pid, signal); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to kill process: %s", err.Error()),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Process %d killed with signal %s", pid, signal),
+		"pid":     pid,
+	})
+}
+
+func getSockets(protocols []string) ([]SocketInfo, error) {
+	owners := socketOwners()
+	users := make(map[string]string)
+
+	sockets := []SocketInfo{}
+	for _, proto := range protocols {
+		var entries []SocketInfo
+		var err error
+
+		switch proto {
+		case "tcp", "tcp6", "udp", "udp6":
+			entries, err = parseInetSockets(proto)
+		case "unix":
+			entries, err = parseUnixSockets()
+		default:
+			return nil, fmt.Errorf("unknown protocol: %s", proto)
+		}
+		if err != nil {
+			// tcp6/udp6 are missing when IPv6 is disabled
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for i := range entries {
+			s := &entries[i]
+			uid := s.User
+			if owner, ok := owners[s.Inode]; ok {
+				s.PID = owner.PID
+				s.Process = owner.Process
+				s.Container = owner.Container
+				if owner.UID != "" {
+					uid = owner.UID
+				}
+			}
+			s.User = lookupUsername(users, uid)
+		}
+
+		sockets = append(sockets, entries...)
+	}
+
+	return sockets, nil
+}
+
+// parseInetSockets parses /proc/net/{tcp,tcp6,udp,udp6}.
+// The User field is temporarily set to the socket's UID.
+func parseInetSockets(proto string) ([]SocketInfo, error) {
+	data, err := os.ReadFile(filepath.Join("/proc/net", proto))
+	if err != nil {
+		return nil, err
+	}
+
+	udp := strings.HasPrefix(proto, "udp")
+	sockets := []SocketInfo{}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 10 {
+			continue
+		}
+
+		localIP, localPort := parseProcNetAddr(fields[1])
+		remoteIP, remotePort := parseProcNetAddr(fields[2])
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
+
+		state := tcpStates[fields[3]]
+		listening := state == "LISTEN"
+		if udp {
+			// UDP has no LISTEN state; an unconnected socket (CLOSE) is receiving on its port
+			if state == "CLOSE" {
+				state = "UNCONN"
+				listening = true
+			}
+		}
+
+		sockets = append(sockets, SocketInfo{
+			Protocol:      proto,
+			LocalAddress:  localIP,
+			LocalPort:     localPort,
+			RemoteAddress: remoteIP,
+			RemotePort:    remotePort,
+			State:         state,
+			Listening:     listening,
+			Inode:         inode,
+			User:          fields[7],
+		})
+	}
+
+	return sockets, nil
+}
+
+// parseProcNetAddr decodes "0100007F:1F90" where the address is stored as
+// 32-bit words in host byte order and the port in network byte order
+func parseProcNetAddr(s string) (string, int) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return "", 0
+	}
+
+	port, _ := strconv.ParseUint(parts[1], 16, 16)
+
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || len(raw)%4 != 0 {
+		return "", int(port)
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.NativeEndian.Uint32(raw[i:]))
+	}
+
+	return ip.String(), int(port)
+}
+
+// parseUnixSockets parses /proc/net/unix
+func parseUnixSockets() ([]SocketInfo, error) {
+	data, err := os.ReadFile("/proc/net/unix")
+	if err != nil {
+		return nil, err
+	}
+
+	const acceptCon = 0x10000 // __SO_ACCEPTCON
+
+	sockets := []SocketInfo{}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 7 {
+			continue
+		}
+
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		inode, _ := strconv.ParseUint(fields[6], 10, 64)
+
+		path := ""
+		if len(fields) > 7 {
+			path = fields[7]
+		}
+
+		state := "UNCONNECTED"
+		switch {
+		case flags&acceptCon != 0:
+			state = "LISTEN"
+		case fields[5] == "03":
+			state = "CONNECTED"
+		case fields[5] == "02":
+			state = "CONNECTING"
+		case fields[5] == "04":
+			state = "DISCONNECTING"
+		}
+
+		sockets = append(sockets, SocketInfo{
+			Protocol:  "unix",
+			Path:      path,
+			State:     state,
+			Listening: state == "LISTEN",
+			Inode:     inode,
+		})
+	}
+
+	return sockets, nil
+}
+
+// socketOwners maps socket inodes to processes by scanning /proc/<pid>/fd
+func socketOwners() map[uint64]socketOwner {
+	owners := make(map[uint64]socketOwner)
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var owner *socketOwner
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			// The first process wins for sockets shared across fork()
+			if _, ok := owners[inode]; ok {
+				continue
+			}
+
+			if owner == nil {
+				owner = &socketOwner{
+					PID:       pid,
+					Process:   readSysString(filepath.Join("/proc", proc.Name(), "comm")),
+					UID:       processUID(pid),
+					Container: processContainerID(pid),
+				}
+			}
+			owners[inode] = *owner
+		}
+	}
+
+	return owners
+}
+
+// processUID returns the real UID from /proc/<pid>/status
+func processUID(pid int) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Uid:") {
+			fields := strings.Fields(line)
+			if len(fields) > 1 {
+				return fields[1]
+			}
+		}
+	}
+	return ""
+}
+
+// processContainerID returns the short container ID if the process runs in a container
+func processContainerID(pid int) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	return containerIDFromCgroup(string(data))
+}
+
+func containerIDFromCgroup(cgroup string) string {
+	matches := containerIDPattern.FindStringSubmatch(cgroup)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1][:12]
+}
+
+// lookupUsername resolves a UID to a user name, caching results in users
+func lookupUsername(users map[string]string, uid string) string {
+	if uid == "" {
+		return ""
+	}
+	if name, ok := users[uid]; ok {
+		return name
+	}
+
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	users[uid] = name
+	return name
+}
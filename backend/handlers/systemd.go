@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SystemdUnit struct {
+	Name           string     `json:"name"`
+	Type           string     `json:"type"`
+	Description    string     `json:"description"`
+	LoadState      string     `json:"load_state"`
+	ActiveState    string     `json:"active_state"`
+	SubState       string     `json:"sub_state"`
+	UnitFileState  string     `json:"unit_file_state"`
+	MainPID        int        `json:"main_pid"`
+	MemoryCurrent  *uint64    `json:"memory_current"`
+	CPUUsageNSec   *uint64    `json:"cpu_usage_nsec"`
+	Restarts       int        `json:"restarts"`
+	Result         string     `json:"result"`
+	FragmentPath   string     `json:"fragment_path"`
+	StateChangedAt *time.Time `json:"state_changed_at"`
+	CreatedByWebOS bool       `json:"created_by_webos"`
+}
+
+// systemdUnitProperties are the properties requested from `systemctl show`
+var systemdUnitProperties = []string{
+	"Id", "Description", "LoadState", "ActiveState", "SubState", "UnitFileState",
+	"MainPID", "MemoryCurrent", "CPUUsageNSec", "NRestarts", "Result",
+	"FragmentPath", "StateChangeTimestamp",
+}
+
+var systemdUnitTypes = []string{"service", "timer", "socket", "mount"}
+
+// unitNamePattern allows the characters systemd permits in unit names
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.@\\-]+$`)
+
+// systemdUnsetValue is what systemd reports for unavailable accounting values
+const systemdUnsetValue = "18446744073709551615"
+
+func validUnitName(name string) bool {
+	return len(name) <= 256 && unitNamePattern.MatchString(name) && !strings.HasPrefix(name, "-")
+}
+
+// listUnitNames returns the names of all loaded units and installed unit files of the given types
+func listUnitNames(types []string) ([]string, error) {
+	typeArg := "--type=" + strings.Join(types, ",")
+
+	output, err := exec.Command("systemctl", "list-units", "--all", typeArg, "--no-legend", "--plain", "--no-pager").Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl list-units failed: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		names = append(names, fields[0])
+	}
+
+	// Disabled units that are not loaded only show up in list-unit-files
+	output, err = exec.Command("systemctl", "list-unit-files", typeArg, "--no-legend", "--no-pager").Output()
+	if err == nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			// Template units (foo@.service) cannot be shown without an instance
+			if len(fields) == 0 || seen[fields[0]] || strings.HasSuffix(strings.SplitN(fields[0], ".", 2)[0], "@") {
+				continue
+			}
+			seen[fields[0]] = true
+			names = append(names, fields[0])
+		}
+	}
+
+	return names, nil
+}
+
+// showUnits returns the properties of the given units using `systemctl show`
+func showUnits(names []string) ([]SystemdUnit, error) {
+	units := []SystemdUnit{}
+
+	results, err := systemctlShow(names, systemdUnitProperties)
+	if err != nil {
+		return nil, err
+	}
+	for _, props := range results {
+		units = append(units, unitFromProperties(props))
+	}
+
+	return units, nil
+}
+
+// systemctlShow returns the requested properties of each unit, one map per unit
+func systemctlShow(names []string, properties []string) ([]map[string]string, error) {
+	var results []map[string]string
+
+	// Keep the argument list reasonably short on systems with thousands of units
+	const batchSize = 200
+	for start := 0; start < len(names); start += batchSize {
+		end := start + batchSize
+		if end > len(names) {
+			end = len(names)
+		}
+
+		args := []string{"show", "--timestamp=unix", "-p", strings.Join(properties, ",")}
+		args = append(args, "--")
+		args = append(args, names[start:end]...)
+
+		output, err := exec.Command("systemctl", args...).Output()
+		if err != nil && len(output) == 0 {
+			return nil, fmt.Errorf("systemctl show failed: %v", err)
+		}
+
+		results = append(results, parseSystemctlShow(output)...)
+	}
+
+	return results, nil
+}
+
+// showUnit returns the properties of a single unit
+func showUnit(name string) (SystemdUnit, error) {
+	units, err := showUnits([]string{name})
+	if err != nil {
+		return SystemdUnit{}, err
+	}
+	if len(units) == 0 {
+		return SystemdUnit{}, fmt.Errorf("unit %s not found", name)
+	}
+	return units[0], nil
+}
+
+// Directories systemd loads units from, highest priority first
+var (
+	systemUnitDirs = []string{
+		"/etc/systemd/system", "/run/systemd/system", "/usr/local/lib/systemd/system",
+		"/usr/lib/systemd/system", "/lib/systemd/system",
+	}
+	userUnitDirs = []string{
+		"/etc/systemd/user", "/run/systemd/user", "/usr/local/lib/systemd/user",
+		"/usr/lib/systemd/user", "/lib/systemd/user",
+	}
+)
+
+// unitExists reports whether a unit of this name is already known, so that a
+// generated unit in the admin directory never silently overrides a vendor
+// one. The unit directories are checked as well as the manager selected by
+// systemctlArgs, which also knows generated and transient units.
+func unitExists(systemctlArgs []string, unit string, dirs []string) bool {
+	for _, dir := range dirs {
+		if fileExists(filepath.Join(dir, unit)) {
+			return true
+		}
+	}
+
+	args := append(append([]string{}, systemctlArgs...), "show", "-p", "LoadState,FragmentPath", "--", unit)
+	output, err := exec.Command("systemctl", args...).Output()
+	if err != nil {
+		return false
+	}
+	for _, props := range parseSystemctlShow(output) {
+		if props["FragmentPath"] != "" || (props["LoadState"] != "" && props["LoadState"] != "not-found") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSystemctlShow splits `systemctl show` output into one property map per unit.
+// Units are separated by blank lines.
+func parseSystemctlShow(output []byte) []map[string]string {
+	var result []map[string]string
+	current := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(current) > 0 {
+				result = append(result, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			current[parts[0]] = parts[1]
+		}
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+
+	return result
+}
+
+func unitFromProperties(props map[string]string) SystemdUnit {
+	name := props["Id"]
+	unitType := ""
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		unitType = name[idx+1:]
+	}
+
+	mainPID, _ := strconv.Atoi(props["MainPID"])
+	restarts, _ := strconv.Atoi(props["NRestarts"])
+
+	return SystemdUnit{
+		Name:           name,
+		Type:           unitType,
+		Description:    props["Description"],
+		LoadState:      props["LoadState"],
+		ActiveState:    props["ActiveState"],
+		SubState:       props["SubState"],
+		UnitFileState:  props["UnitFileState"],
+		MainPID:        mainPID,
+		MemoryCurrent:  parseSystemdUint(props["MemoryCurrent"]),
+		CPUUsageNSec:   parseSystemdUint(props["CPUUsageNSec"]),
+		Restarts:       restarts,
+		Result:         props["Result"],
+		FragmentPath:   props["FragmentPath"],
+		StateChangedAt: parseSystemdTimestamp(props["StateChangeTimestamp"]),
+		CreatedByWebOS: isWebOSManagedUnit(props["FragmentPath"]),
+	}
+}
+
+// parseSystemdUint returns nil for "[not set]" and UINT64_MAX, which systemd
+// uses when accounting is disabled
+func parseSystemdUint(value string) *uint64 {
+	if value == "" || value == systemdUnsetValue {
+		return nil
+	}
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// parseSystemdTimestamp parses timestamps printed with --timestamp=unix ("@1700000000")
+func parseSystemdTimestamp(value string) *time.Time {
+	if !strings.HasPrefix(value, "@") {
+		return nil
+	}
+	secs, err := strconv.ParseInt(strings.TrimPrefix(value, "@"), 10, 64)
+	if err != nil || secs == 0 {
+		return nil
+	}
+	t := time.Unix(secs, 0)
+	return &t
+}
+
+// systemdTimespanUnits are the suffixes systemd uses when formatting durations
+var systemdTimespanUnits = map[string]time.Duration{
+	"y":     31557600 * time.Second,
+	"month": 2629800 * time.Second,
+	"w":     7 * 24 * time.Hour,
+	"d":     24 * time.Hour,
+	"h":     time.Hour,
+	"min":   time.Minute,
+	"s":     time.Second,
+	"ms":    time.Millisecond,
+	"us":    time.Microsecond,
+	"µs":    time.Microsecond,
+}
+
+var timespanPartPattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)([a-zµ]+)$`)
+
+// parseSystemdTimespan parses durations as systemd prints them ("2min 3.512s", "1d 4h", "800ms")
+func parseSystemdTimespan(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "infinity" {
+		return 0, false
+	}
+	if value == "0" {
+		return 0, true
+	}
+
+	var total time.Duration
+	for _, part := range strings.Fields(value) {
+		m := timespanPartPattern.FindStringSubmatch(part)
+		if m == nil {
+			return 0, false
+		}
+		unit, ok := systemdTimespanUnits[m[2]]
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return 0, false
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return total, true
+}
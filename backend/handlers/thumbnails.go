@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	thumbnailCacheMaxBytes = 512 << 20
+	thumbnailSweepInterval = time.Minute
+	// Images are decoded in full, so huge ones are refused (decompression bombs)
+	maxThumbnailSourcePixels = 100_000_000
+	thumbnailToolTimeout     = 30 * time.Second
+	thumbnailQuality         = 80
+)
+
+// Requested sizes are rounded up to one of these so the cache holds few variants
+var thumbnailSizes = []int{128, 256, 512, 1024}
+
+// thumbnailSlots bounds concurrent generation; an image grid asks for many at once
+var thumbnailSlots = make(chan struct{}, runtime.NumCPU())
+
+var thumbnailSweep struct {
+	sync.Mutex
+	last time.Time
+}
+
+var errNoThumbnail = errors.New("no thumbnail can be made for this file")
+
+// GetThumbnail returns a JPEG of ?path= that fits in ?size= pixels (default
+// 256). JPEG, PNG and GIF are handled natively; other images, PDFs and videos
+// need ImageMagick, pdftoppm or ffmpeg on the server. Thumbnails are cached
+// until the file changes.
+func GetThumbnail(c *gin.Context) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return
+	}
+
+	path, err := jail.resolve(c.Query("path"), true)
+	if err != nil {
+		respondFileError(c, err, "Invalid path")
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		respondFileError(c, err, "File not found")
+		return
+	}
+	if !info.Mode().IsRegular() || onPseudoFilesystem(path) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": errNoThumbnail.Error()})
+		return
+	}
+	if err := jail.canRead(path, false); err != nil {
+		respondFileError(c, err, "Failed to read file")
+		return
+	}
+
+	size := thumbnailSize(c.Query("size"))
+	name := thumbnailName(path, info, size)
+	etag := `"` + strings.TrimSuffix(name, ".jpg") + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, err := thumbnail(c.Request.Context(), jail, path, name, size)
+	if err != nil {
+		if errors.Is(err, errNoThumbnail) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+		respondFileError(c, err, "Failed to create thumbnail")
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
+func thumbnailSize(requested string) int {
+	n, err := strconv.Atoi(requested)
+	if err != nil || n <= 0 {
+		return 256
+	}
+	for _, size := range thumbnailSizes {
+		if n <= size {
+			return size
+		}
+	}
+	return thumbnailSizes[len(thumbnailSizes)-1]
+}
+
+func thumbnailCacheDir() string {
+	return filepath.Join(webOSStateDir(), "thumbnails")
+}
+
+// thumbnailName is "<path hash>-<state hash>-<size>.jpg". The state hash
+// changes with the file's inode, size and mtime, so an edited file gets a new
+// thumbnail and the old ones are recognisable by their path hash.
+func thumbnailName(path string, info os.FileInfo, size int) string {
+	pathSum := sha256.Sum256([]byte(path))
+	stateSum := sha256.Sum256([]byte(fileETag(info)))
+	return fmt.Sprintf("%s-%s-%d.jpg", hex.EncodeToString(pathSum[:8]), hex.EncodeToString(stateSum[:8]), size)
+}
+
+// thumbnail returns a cached thumbnail or generates and stores one
+func thumbnail(ctx context.Context, jail *fileJail, path, name string, size int) ([]byte, error) {
+	cached := filepath.Join(thumbnailCacheDir(), name)
+	if data, err := os.ReadFile(cached); err == nil {
+		// 最近使ったものを残すため、更新時刻をアクセス時刻として使う
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+		return data, nil
+	}
+
+	select {
+	case thumbnailSlots <- struct{}{}:
+		defer func() { <-thumbnailSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// 待っている間に同じサムネイルが作られていることがある
+	if data, err := os.ReadFile(cached); err == nil {
+		return data, nil
+	}
+
+	data, err := generateThumbnail(ctx, jail, path, size)
+	if err != nil {
+		return nil, err
+	}
+	storeThumbnail(name, data)
+	return data, nil
+}
+
+// storeThumbnail caches data, drops thumbnails of earlier versions of the
+// same file and keeps the cache within its size limit
+func storeThumbnail(name string, data []byte) {
+	dir := thumbnailCacheDir()
+	if os.MkdirAll(dir, 0700) != nil {
+		return
+	}
+	pathKey, rest, _ := strings.Cut(name, "-")
+	stateKey, _, _ := strings.Cut(rest, "-")
+	stale, _ := filepath.Glob(filepath.Join(dir, pathKey+"-*.jpg"))
+	for _, old := range stale {
+		if !strings.HasPrefix(filepath.Base(old), pathKey+"-"+stateKey+"-") {
+			os.Remove(old)
+		}
+	}
+	if writeFileAtomic(filepath.Join(dir, name), data, 0600) != nil {
+		return
+	}
+
+	thumbnailSweep.Lock()
+	defer thumbnailSweep.Unlock()
+	if time.Since(thumbnailSweep.last) < thumbnailSweepInterval {
+		return
+	}
+	thumbnailSweep.last = time.Now()
+	sweepThumbnailCache(dir)
+}
+
+// sweepThumbnailCache removes the least recently used thumbnails until the
+// cache is below 90% of its limit
+func sweepThumbnailCache(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			files = append(files, info)
+			total += info.Size()
+		}
+	}
+	if total <= thumbnailCacheMaxBytes {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if total <= thumbnailCacheMaxBytes*9/10 {
+			break
+		}
+		if os.Remove(filepath.Join(dir, info.Name())) == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+// generateThumbnail picks a decoder by the file's content
+func generateThumbnail(ctx context.Context, jail *fileJail, path string, size int) ([]byte, error) {
+	file, err := jail.openFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	detected, err := mimetype.DetectReader(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, thumbnailToolTimeout)
+	defer cancel()
+
+	switch kind := detected.String(); {
+	case kind == "image/jpeg" || kind == "image/png" || kind == "image/gif":
+		return decodeThumbnail(file, kind, size)
+	case kind == "image/svg+xml":
+		// SVGはImageMagick経由だと外部リソースを読みに行くことがある
+		return nil, errNoThumbnail
+	case strings.HasPrefix(kind, "image/"):
+		tool := "magick"
+		if _, err := lookTool(tool); err != nil {
+			tool = "convert"
+		}
+		return runThumbnailTool(ctx, jail, file, tool, "/dev/fd/3[0]", "-auto-orient", "-thumbnail", fmt.Sprintf("%dx%d>", size, size), "-quality", strconv.Itoa(thumbnailQuality), "jpg:-")
+	case kind == "application/pdf":
+		return runThumbnailTool(ctx, jail, file, "pdftoppm", "-f", "1", "-l", "1", "-scale-to", strconv.Itoa(size), "-jpeg", "-singlefile", "/dev/fd/3")
+	case strings.HasPrefix(kind, "video/"):
+		scale := fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease", size, size)
+		// 先頭は黒いフレームが多いので1秒目を使い、短い動画は先頭に戻す
+		for _, offset := range []string{"1", "0"} {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			data, err := runThumbnailTool(ctx, jail, file, "ffmpeg", "-v", "error", "-protocol_whitelist", "file,pipe", "-ss", offset, "-i", "/dev/fd/3", "-frames:v", "1", "-vf", scale, "-f", "image2pipe", "-c:v", "mjpeg", "pipe:1")
+			if err != nil || len(data) > 0 {
+				return data, err
+			}
+		}
+		return nil, errNoThumbnail
+	}
+	return nil, errNoThumbnail
+}
+
+// runThumbnailTool runs an external converter on the already opened file
+// (passed as /dev/fd/3, so the path cannot be swapped) and returns its output
+func runThumbnailTool(ctx context.Context, jail *fileJail, file *os.File, tool string, args ...string) ([]byte, error) {
+	bin, err := lookTool(tool)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not installed", errNoThumbnail, tool)
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: toolCredential(jail, file)}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s failed: %s", errNoThumbnail, tool, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// toolCredential chooses who the converters run as. They parse content other
+// users may have written and have known bugs, so when the server runs as root
+// they never run as root: they get the rights of the user's account, or of the
+// file's owner for accounts that are not checked, and nobody's for files root
+// owns. Files an ffmpeg playlist refers to are read with these rights too.
+// /dev/fd/3 reopens the file, so files only root can read get no preview.
+func toolCredential(jail *fileJail, file *os.File) *syscall.Credential {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	if cred := accountCredential(jail); cred != nil {
+		return cred
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(file.Fd()), &st); err != nil {
+		return nobodyCredential(nil)
+	}
+	if st.Uid != 0 {
+		return &syscall.Credential{Uid: st.Uid, Gid: st.Gid}
+	}
+	return nobodyCredential([]uint32{st.Gid})
+}
+
+// accountCredential returns the rights of the user's Unix account when the
+// server runs as root and access is checked against that account
+func accountCredential(jail *fileJail) *syscall.Credential {
+	a := jail.account
+	if a == nil || os.Geteuid() != 0 {
+		return nil
+	}
+	groups := make([]uint32, 0, len(a.gids))
+	for gid := range a.gids {
+		groups = append(groups, gid)
+	}
+	return &syscall.Credential{Uid: a.uid, Gid: a.gid, Groups: groups}
+}
+
+func nobodyCredential(groups []uint32) *syscall.Credential {
+	cred := &syscall.Credential{Uid: 65534, Gid: 65534, Groups: groups}
+	if u, err := user.Lookup("nobody"); err == nil {
+		if uid, err := strconv.ParseUint(u.Uid, 10, 32); err == nil {
+			cred.Uid = uint32(uid)
+		}
+		if gid, err := strconv.ParseUint(u.Gid, 10, 32); err == nil {
+			cred.Gid = uint32(gid)
+		}
+	}
+	return cred
+}
+
+// decodeThumbnail scales an image decoded by the standard library and applies
+// the EXIF orientation of JPEGs
+func decodeThumbnail(file *os.File, kind string, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoThumbnail, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("%w: the image is too large", errNoThumbnail)
+	}
+
+	orientation := 1
+	if kind == "image/jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		orientation = readJPEGExif(file).orientation
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoThumbnail, err)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, orient(scaleDown(img, size), orientation), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// scaleDown fits img into a size×size box. Each pixel averages a 4×4 grid
+// of samples, and transparent areas are composited on white.
+func scaleDown(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(1, h*size/w)
+		} else {
+			dw, dh = max(1, w*size/h), size
+		}
+	}
+
+	const samples = 4
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var r, g, bl uint32
+			for sy := 0; sy < samples; sy++ {
+				py := b.Min.Y + (y*samples+sy)*h/(dh*samples)
+				for sx := 0; sx < samples; sx++ {
+					px := b.Min.X + (x*samples+sx)*w/(dw*samples)
+					cr, cg, cb, ca := img.At(px, py).RGBA()
+					white := 0xffff - ca
+					r += (cr + white) >> 8
+					g += (cg + white) >> 8
+					bl += (cb + white) >> 8
+				}
+			}
+			const n = samples * samples
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 0xff})
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (2-8) so the thumbnail shows upright
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SystemdTimer struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	ActiveState    string     `json:"active_state"`
+	UnitFileState  string     `json:"unit_file_state"`
+	Unit           string     `json:"unit"`
+	OnCalendar     []string   `json:"on_calendar"`
+	Monotonic      []string   `json:"monotonic"`
+	Persistent     bool       `json:"persistent"`
+	NextRun        *time.Time `json:"next_run"`
+	LastRun        *time.Time `json:"last_run"`
+	FragmentPath   string     `json:"fragment_path"`
+	CreatedByWebOS bool       `json:"created_by_webos"`
+}
+
+// TimerSpec describes a timer created from the console. It either triggers an
+// existing unit or runs Command through a generated oneshot service.
+type TimerSpec struct {
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	OnCalendar         []string `json:"on_calendar"`
+	Persistent         bool     `json:"persistent"`
+	RandomizedDelaySec int      `json:"randomized_delay_sec"`
+	Unit               string   `json:"unit"`
+	Command            string   `json:"command"`
+	Shell              bool     `json:"shell"`
+	User               string   `json:"user"`
+	WorkingDirectory   string   `json:"working_directory"`
+}
+
+type ManagedTimer struct {
+	Spec  TimerSpec     `json:"spec"`
+	Timer string        `json:"timer"`
+	Path  string        `json:"path"`
+	State *SystemdTimer `json:"state"`
+}
+
+type CalendarValidation struct {
+	Expression string      `json:"expression"`
+	Valid      bool        `json:"valid"`
+	Normalized string      `json:"normalized"`
+	NextRuns   []time.Time `json:"next_runs"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// webOSTimerSpecLine carries the TimerSpec in both the timer and its generated service
+const webOSTimerSpecLine = "# X-WebOS-Timer: "
+
+var systemdTimerProperties = []string{
+	"Id", "Description", "ActiveState", "UnitFileState", "Unit", "TimersCalendar",
+	"TimersMonotonic", "Persistent", "NextElapseUSecRealtime", "NextElapseUSecMonotonic",
+	"LastTriggerUSec", "FragmentPath",
+}
+
+// timerEntryPattern matches one "{ OnCalendar=... ; next_elapse=... }" entry of TimersCalendar/TimersMonotonic
+var timerEntryPattern = regexp.MustCompile(`\{ (\w+)=(.*?) ; next_elapse=`)
+
+// ListTimers returns all systemd timers with their schedule and next/last run
+func ListTimers(c *gin.Context) {
+	names, err := listUnitNames([]string{"timer"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	timers, err := showTimers(names)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timers": timers,
+		"total":  len(timers),
+	})
+}
+
+// GetTimer returns the spec of a timer created from the console
+func GetTimer(c *gin.Context) {
+	spec, ok := lookupManagedTimer(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, managedTimerInfo(spec))
+}
+
+// CreateTimer writes a timer (and its service when a command is given), then enables and starts it
+func CreateTimer(c *gin.Context) {
+	var spec TimerSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validations, err := normalizeTimerSpec(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "calendar": validations})
+		return
+	}
+
+	if unitExists([]string{"--no-ask-password"}, spec.Name+".timer", systemUnitDirs) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A timer with this name already exists"})
+		return
+	}
+	if spec.Command != "" && unitExists([]string{"--no-ask-password"}, spec.Name+".service", systemUnitDirs) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A service with this name already exists"})
+		return
+	}
+
+	if err := writeTimerUnits(spec); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write timer: " + err.Error()})
+		return
+	}
+
+	output, err := exec.Command("systemctl", "--no-ask-password", "enable", "--now", "--", spec.Name+".timer").CombinedOutput()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":  "Timer created but failed to start: " + err.Error(),
+			"output": string(output),
+			"timer":  managedTimerInfo(spec),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Timer created and started",
+		"timer":   managedTimerInfo(spec),
+	})
+}
+
+// UpdateTimer rewrites a timer created from the console and restarts it
+func UpdateTimer(c *gin.Context) {
+	existing, ok := lookupManagedTimer(c)
+	if !ok {
+		return
+	}
+
+	var spec TimerSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	spec.Name = existing.Name
+
+	if validations, err := normalizeTimerSpec(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "calendar": validations})
+		return
+	}
+
+	// 既存ユニットを起動する形に変わった場合は生成したサービスを削除する
+	if existing.Command != "" && spec.Command == "" {
+		os.Remove(timerServicePath(spec.Name))
+	} else if existing.Command == "" && spec.Command != "" && unitExists([]string{"--no-ask-password"}, spec.Name+".service", systemUnitDirs) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A service with this name already exists"})
+		return
+	}
+
+	if err := writeTimerUnits(spec); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write timer: " + err.Error()})
+		return
+	}
+
+	// Restarting the timer recalculates the next elapse from the new schedule
+	output, err := exec.Command("systemctl", "--no-ask-password", "restart", "--", spec.Name+".timer").CombinedOutput()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":  "Timer updated but failed to restart: " + err.Error(),
+			"output": string(output),
+			"timer":  managedTimerInfo(spec),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Timer updated",
+		"timer":   managedTimerInfo(spec),
+	})
+}
+
+// DeleteTimer stops and removes a timer created from the console together with its generated service
+func DeleteTimer(c *gin.Context) {
+	spec, ok := lookupManagedTimer(c)
+	if !ok {
+		return
+	}
+
+	exec.Command("systemctl", "--no-ask-password", "disable", "--now", "--", spec.Name+".timer").Run()
+
+	if err := os.Remove(timerPath(spec.Name)); err != nil && !os.IsNotExist(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove timer: " + err.Error()})
+		return
+	}
+	if spec.Command != "" && isWebOSManagedUnit(timerServicePath(spec.Name)) {
+		os.Remove(timerServicePath(spec.Name))
+	}
+
+	daemonReload()
+	exec.Command("systemctl", "--no-ask-password", "reset-failed", "--", spec.Name+".timer", spec.Name+".service").Run()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Timer removed",
+		"path":    timerPath(spec.Name),
+	})
+}
+
+// ValidateCalendar checks OnCalendar expressions and returns their next elapse times
+func ValidateCalendar(c *gin.Context) {
+	var request struct {
+		Expressions []string `json:"expressions"`
+		Iterations  int      `json:"iterations"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Iterations <= 0 || request.Iterations > 100 {
+		request.Iterations = 5
+	}
+
+	results := []CalendarValidation{}
+	valid := true
+	for _, expression := range request.Expressions {
+		result := validateCalendar(expression, request.Iterations)
+		valid = valid && result.Valid
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":   valid,
+		"results": results,
+	})
+}
+
+func showTimers(names []string) ([]SystemdTimer, error) {
+	timers := []SystemdTimer{}
+
+	results, err := systemctlShow(names, systemdTimerProperties)
+	if err != nil {
+		return nil, err
+	}
+	for _, props := range results {
+		timers = append(timers, timerFromProperties(props))
+	}
+
+	return timers, nil
+}
+
+func timerFromProperties(props map[string]string) SystemdTimer {
+	timer := SystemdTimer{
+		Name:           props["Id"],
+		Description:    props["Description"],
+		ActiveState:    props["ActiveState"],
+		UnitFileState:  props["UnitFileState"],
+		Unit:           props["Unit"],
+		OnCalendar:     []string{},
+		Monotonic:      []string{},
+		Persistent:     props["Persistent"] == "yes",
+		NextRun:        parseSystemdTimestamp(props["NextElapseUSecRealtime"]),
+		LastRun:        parseSystemdTimestamp(props["LastTriggerUSec"]),
+		FragmentPath:   props["FragmentPath"],
+		CreatedByWebOS: isWebOSManagedUnit(props["FragmentPath"]),
+	}
+
+	for _, m := range timerEntryPattern.FindAllStringSubmatch(props["TimersCalendar"], -1) {
+		timer.OnCalendar = append(timer.OnCalendar, m[2])
+	}
+	for _, m := range timerEntryPattern.FindAllStringSubmatch(props["TimersMonotonic"], -1) {
+		timer.Monotonic = append(timer.Monotonic, m[1]+"="+m[2])
+	}
+
+	// Monotonic timers (OnBootSec=, OnUnitActiveSec=...) elapse relative to the
+	// monotonic clock, so convert using the current uptime
+	if next, ok := parseSystemdTimespan(props["NextElapseUSecMonotonic"]); ok && next > 0 {
+		if uptime, ok := readUptime(); ok && next > uptime {
+			t := time.Now().Add(next - uptime).Truncate(time.Second)
+			if timer.NextRun == nil || t.Before(*timer.NextRun) {
+				timer.NextRun = &t
+			}
+		}
+	}
+
+	return timer
+}
+
+// validateCalendar runs `systemd-analyze calendar` on a single expression
+func validateCalendar(expression string, iterations int) CalendarValidation {
+	result := CalendarValidation{Expression: expression, NextRuns: []time.Time{}}
+
+	if strings.TrimSpace(expression) == "" || strings.ContainsAny(expression, "\r\n") {
+		result.Error = "expression must be a single non-empty line"
+		return result
+	}
+
+	output, err := exec.Command("systemd-analyze", "calendar", "--iterations="+strconv.Itoa(iterations), "--", expression).CombinedOutput()
+	if err != nil {
+		result.Error = strings.TrimSpace(string(output))
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Valid = true
+	result.Normalized, result.NextRuns = parseCalendarOutput(string(output))
+	return result
+}
+
+// parseCalendarOutput parses the output of `systemd-analyze calendar`:
+//
+//	  Original form: daily
+//	Normalized form: *-*-* 00:00:00
+//	    Next elapse: Sat 2024-01-06 00:00:00 JST
+//	       (in UTC): Fri 2024-01-05 15:00:00 UTC
+//	       From now: 10h left
+//	       Iter. #2: Sun 2024-01-07 00:00:00 JST
+//	       (in UTC): Sat 2024-01-06 15:00:00 UTC
+func parseCalendarOutput(output string) (string, []time.Time) {
+	normalized := ""
+	runs := []time.Time{}
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+
+		switch {
+		case key == "Normalized form":
+			normalized = value
+		case key == "Next elapse" || strings.HasPrefix(key, "Iter. #"):
+			if t, ok := parseCalendarTime(value); ok {
+				runs = append(runs, t)
+			}
+		case key == "(in UTC)" && len(runs) > 0:
+			// The UTC line is unambiguous, unlike local zone abbreviations
+			if t, ok := parseCalendarTime(value); ok {
+				runs[len(runs)-1] = t
+			}
+		}
+	}
+
+	return normalized, runs
+}
+
+// parseCalendarTime parses "Sat 2024-01-06 00:00:00 UTC"
+func parseCalendarTime(value string) (time.Time, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return time.Time{}, false
+	}
+
+	location := time.Local
+	if len(fields) > 3 && fields[3] == "UTC" {
+		location = time.UTC
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", fields[1]+" "+fields[2], location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// normalizeTimerSpec validates spec and returns the calendar validation results
+func normalizeTimerSpec(spec *TimerSpec) ([]CalendarValidation, error) {
+	spec.Name = strings.TrimSuffix(spec.Name, ".timer")
+	if !customServiceNamePattern.MatchString(spec.Name) {
+		return nil, fmt.Errorf("invalid timer name")
+	}
+	if len(spec.OnCalendar) == 0 {
+		return nil, fmt.Errorf("at least one OnCalendar expression is required")
+	}
+	if spec.RandomizedDelaySec < 0 {
+		return nil, fmt.Errorf("randomized_delay_sec must not be negative")
+	}
+	if strings.ContainsAny(spec.Description, "\r\n") {
+		return nil, fmt.Errorf("description must be a single line")
+	}
+	if strings.ContainsAny(spec.WorkingDirectory, "\r\n") {
+		return nil, fmt.Errorf("working directory must be a single line")
+	}
+
+	validations := []CalendarValidation{}
+	var invalid []string
+	for _, expression := range spec.OnCalendar {
+		result := validateCalendar(expression, 1)
+		if !result.Valid {
+			invalid = append(invalid, expression)
+		}
+		validations = append(validations, result)
+	}
+	if len(invalid) > 0 {
+		return validations, fmt.Errorf("invalid OnCalendar expression: %s", strings.Join(invalid, ", "))
+	}
+
+	if (spec.Unit == "") == (strings.TrimSpace(spec.Command) == "") {
+		return validations, fmt.Errorf("either unit or command is required")
+	}
+	if spec.Unit != "" {
+		if !validUnitName(spec.Unit) || strings.HasSuffix(spec.Unit, ".timer") {
+			return validations, fmt.Errorf("invalid unit name: %s", spec.Unit)
+		}
+		return validations, nil
+	}
+
+	if strings.ContainsAny(spec.Command, "\r\n") {
+		return validations, fmt.Errorf("command must be a single line")
+	}
+	if spec.User != "" {
+		if _, err := user.Lookup(spec.User); err != nil {
+			return validations, fmt.Errorf("unknown user: %s", spec.User)
+		}
+	}
+	if spec.WorkingDirectory != "" && !filepath.IsAbs(spec.WorkingDirectory) {
+		return validations, fmt.Errorf("working directory must be an absolute path")
+	}
+	return validations, nil
+}
+
+// lookupManagedTimer finds a timer created from the console by the :name parameter
+func lookupManagedTimer(c *gin.Context) (TimerSpec, bool) {
+	var spec TimerSpec
+
+	name := strings.TrimSuffix(c.Param("name"), ".timer")
+	if !customServiceNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timer name"})
+		return spec, false
+	}
+
+	if !readWebOSUnitSpec(timerPath(name), webOSTimerSpecLine, &spec) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Timer not found or not managed by Web OS"})
+		return spec, false
+	}
+	return spec, true
+}
+
+func managedTimerInfo(spec TimerSpec) ManagedTimer {
+	info := ManagedTimer{
+		Spec:  spec,
+		Timer: spec.Name + ".timer",
+		Path:  timerPath(spec.Name),
+	}
+	if timers, err := showTimers([]string{info.Timer}); err == nil && len(timers) > 0 {
+		info.State = &timers[0]
+	}
+	return info
+}
+
+func timerPath(name string) string {
+	return filepath.Join(systemdAdminDir, name+".timer")
+}
+
+func timerServicePath(name string) string {
+	return filepath.Join(systemdAdminDir, name+".service")
+}
+
+// writeTimerUnits writes the timer and, for command timers, its oneshot service
+func writeTimerUnits(spec TimerSpec) error {
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	header := webOSUnitMarker + "\n" + webOSTimerSpecLine + string(specJSON) + "\n\n"
+
+	description := spec.Description
+	if description == "" {
+		description = spec.Name
+	}
+
+	if spec.Command != "" {
+		var b strings.Builder
+		b.WriteString(header)
+		fmt.Fprintln(&b, "[Unit]")
+		fmt.Fprintf(&b, "Description=%s\n", systemdEscapeSpecifiers(description))
+		fmt.Fprintln(&b, "X-WebOS-Managed=yes")
+		fmt.Fprintln(&b, "")
+		fmt.Fprintln(&b, "[Service]")
+		fmt.Fprintln(&b, "Type=oneshot")
+		if spec.Shell {
+			fmt.Fprintf(&b, "ExecStart=/bin/bash -c %s\n", systemdExecQuote(spec.Command))
+		} else {
+			fmt.Fprintf(&b, "ExecStart=%s\n", systemdEscapeSpecifiers(spec.Command))
+		}
+		if spec.WorkingDirectory != "" {
+			fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdEscapeSpecifiers(spec.WorkingDirectory))
+		}
+		if spec.User != "" {
+			fmt.Fprintf(&b, "User=%s\n", spec.User)
+		}
+
+		if err := writeFileAtomic(timerServicePath(spec.Name), []byte(b.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	fmt.Fprintln(&b, "[Unit]")
+	fmt.Fprintf(&b, "Description=%s\n", systemdEscapeSpecifiers(description))
+	fmt.Fprintln(&b, "X-WebOS-Managed=yes")
+	fmt.Fprintln(&b, "")
+	fmt.Fprintln(&b, "[Timer]")
+	for _, expression := range spec.OnCalendar {
+		fmt.Fprintf(&b, "OnCalendar=%s\n", expression)
+	}
+	if spec.Persistent {
+		fmt.Fprintln(&b, "Persistent=true")
+	}
+	if spec.RandomizedDelaySec > 0 {
+		fmt.Fprintf(&b, "RandomizedDelaySec=%d\n", spec.RandomizedDelaySec)
+	}
+	if spec.Unit != "" {
+		fmt.Fprintf(&b, "Unit=%s\n", spec.Unit)
+	}
+	fmt.Fprintln(&b, "")
+	fmt.Fprintln(&b, "[Install]")
+	fmt.Fprintln(&b, "WantedBy=timers.target")
+
+	if err := writeFileAtomic(timerPath(spec.Name), []byte(b.String()), 0644); err != nil {
+		return err
+	}
+
+	_, err = daemonReload()
+	return err
+}
+
+// readUptime returns the time since boot from /proc/uptime
+func readUptime() (time.Duration, bool) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
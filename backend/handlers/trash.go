@@ -0,0 +1,895 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/unix"
+)
+
+// TrashItem is an entry in one of the user's trash directories
+type TrashItem struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	OriginalPath string    `json:"original_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	Size         int64     `json:"size"`
+	IsDir        bool      `json:"isDir"`
+	TrashDir     string    `json:"trash_dir"`
+}
+
+type TrashRestoreResult struct {
+	ID     string `json:"id"`
+	Path   string `json:"path,omitempty"`
+	Status string `json:"status"` // restored, conflict, failed
+	Error  string `json:"error,omitempty"`
+}
+
+const trashDateFormat = "2006-01-02T15:04:05"
+
+var (
+	errTrashUnavailable = errors.New("no trash is available on this filesystem; delete the item permanently instead")
+	errTrashTooLarge    = errors.New("item is larger than the trash size limit; delete it permanently instead")
+	errContainsTrash    = errors.New("item contains a trash directory; delete it permanently instead")
+	errTrashItemMissing = errors.New("trash item not found")
+)
+
+// trashDir is one freedesktop.org trash with files/ and info/ subdirectories:
+// the home trash, or $topdir/.Trash/$uid or $topdir/.Trash-$uid for items on
+// other filesystems. Original paths in the latter are stored relative to topdir.
+type trashDir struct {
+	path   string
+	topdir string // empty for the home trash
+}
+
+// userTrash locates the trash directories of the account that owns a user's
+// deletions: the Unix account when permissions are enforced, otherwise the
+// account the server runs as
+type userTrash struct {
+	jail *fileJail
+	uid  int
+	// home is the home directory with symlinks resolved plus the trash path
+	// below it, which the user controls and is never resolved
+	home   trashDir
+	mounts []string
+}
+
+func newUserTrash(jail *fileJail) (*userTrash, error) {
+	t := &userTrash{jail: jail}
+
+	base, rel := "", ".local/share/Trash"
+	if jail.account != nil {
+		account, err := user.LookupId(strconv.FormatUint(uint64(jail.account.uid), 10))
+		if err != nil {
+			return nil, err
+		}
+		t.uid = int(jail.account.uid)
+		base = account.HomeDir
+	} else {
+		t.uid = os.Geteuid()
+		if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+			base, rel = dataHome, "Trash"
+		} else {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			base = home
+		}
+	}
+	base, err := evalSymlinksPartial(base)
+	if err != nil {
+		return nil, err
+	}
+	t.home = trashDir{path: filepath.Join(base, rel)}
+
+	mounts, err := readMountInfo()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		t.mounts = append(t.mounts, m.Mountpoint)
+	}
+	return t, nil
+}
+
+// mountPoint returns the mount point containing path. Bind mounts count as
+// separate filesystems because rename does not cross them either.
+func (t *userTrash) mountPoint(path string) string {
+	top := "/"
+	for _, mp := range t.mounts {
+		if isWithinPath(mp, path) && len(mp) > len(top) {
+			top = mp
+		}
+	}
+	return top
+}
+
+// dirFor opens the trash that receives path, creating it when needed
+func (t *userTrash) dirFor(real string) (*openTrash, error) {
+	top := t.mountPoint(real)
+	if top == t.mountPoint(t.home.path) {
+		o, err := t.create(t.home)
+		if err != nil {
+			return nil, errTrashUnavailable
+		}
+		return o, nil
+	}
+
+	// An administrator may provide a sticky $topdir/.Trash shared by all users
+	uid := strconv.Itoa(t.uid)
+	if isStickyDir(filepath.Join(top, ".Trash")) {
+		if o, err := t.create(trashDir{path: filepath.Join(top, ".Trash", uid), topdir: top}); err == nil {
+			return o, nil
+		}
+	}
+	o, err := t.create(trashDir{path: filepath.Join(top, ".Trash-"+uid), topdir: top})
+	if err != nil {
+		return nil, errTrashUnavailable
+	}
+	return o, nil
+}
+
+// openTrash is a trash directory opened without following symlinks. The user
+// owns everything inside it, so items are only reached relative to these
+// descriptors: a files/ or info/ swapped for a symlink to another directory
+// would otherwise have the server delete or move files there.
+type openTrash struct {
+	trashDir
+	root, filesDir, infoDir *os.File
+	// owner of the info files written for the user, -1 to keep the server's
+	uid, gid int
+}
+
+// open opens td and checks that it and its files/ and info/ are real
+// directories of the owner, so another user cannot plant a symlink to
+// collect deletions and the owner cannot point them elsewhere
+func (t *userTrash) open(td trashDir) (*openTrash, error) {
+	root, err := openDirNoFollow(td.path)
+	if err != nil {
+		return nil, err
+	}
+	o := &openTrash{trashDir: td, root: root}
+	o.uid, o.gid = t.jail.accountOwner()
+	if o.filesDir, err = openDirAt(root, "files", false, 0, -1, -1); err == nil {
+		o.infoDir, err = openDirAt(root, "info", false, 0, -1, -1)
+	}
+	if err != nil || !t.owns(o.root) || !t.owns(o.filesDir) || !t.owns(o.infoDir) {
+		o.Close()
+		return nil, errTrashUnavailable
+	}
+	return o, nil
+}
+
+// create makes td and its files/ and info/ without following symlinks and
+// opens it. Only the home directory was resolved, so a symlink the user puts
+// anywhere below it is refused rather than followed.
+func (t *userTrash) create(td trashDir) (*openTrash, error) {
+	root, err := openDirNoFollow("/")
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	uid, gid := t.jail.accountOwner()
+	for _, sub := range []string{"files", "info"} {
+		created, err := mkdirAllAt(root, filepath.Join(td.path, sub), 0700, uid, gid)
+		if err != nil {
+			return nil, err
+		}
+		created.Close()
+	}
+	return t.open(td)
+}
+
+func (o *openTrash) Close() {
+	for _, f := range []*os.File{o.root, o.filesDir, o.infoDir} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+func (t *userTrash) owns(dir *os.File) bool {
+	var st unix.Stat_t
+	return unix.Fstat(int(dir.Fd()), &st) == nil && int(st.Uid) == t.uid
+}
+
+func isStickyDir(path string) bool {
+	var st syscall.Stat_t
+	return syscall.Lstat(path, &st) == nil && st.Mode&syscall.S_IFMT == syscall.S_IFDIR && st.Mode&syscall.S_ISVTX != 0
+}
+
+// usable reports whether td exists and passes the checks of open
+func (t *userTrash) usable(td trashDir) bool {
+	o, err := t.open(td)
+	if err != nil {
+		return false
+	}
+	o.Close()
+	return true
+}
+
+// dirs returns the existing trash directories: the home trash and those at
+// the top of every mounted filesystem
+func (t *userTrash) dirs() []trashDir {
+	var dirs []trashDir
+	if t.usable(t.home) {
+		dirs = append(dirs, t.home)
+	}
+
+	uid := strconv.Itoa(t.uid)
+	seen := map[string]bool{t.home.path: true}
+	for _, top := range t.mounts {
+		candidates := []string{filepath.Join(top, ".Trash-"+uid)}
+		if isStickyDir(filepath.Join(top, ".Trash")) {
+			candidates = append(candidates, filepath.Join(top, ".Trash", uid))
+		}
+		for _, path := range candidates {
+			td := trashDir{path: path, topdir: top}
+			if !seen[path] && t.usable(td) {
+				seen[path] = true
+				dirs = append(dirs, td)
+			}
+		}
+	}
+	return dirs
+}
+
+// contains reports whether real is inside one of the trash directories
+func (t *userTrash) contains(real string) bool {
+	for _, td := range t.dirs() {
+		if isWithinPath(td.path, real) {
+			return true
+		}
+	}
+	return false
+}
+
+// move puts real into the trash of its filesystem
+func (t *userTrash) move(real string, info fs.FileInfo) (TrashItem, error) {
+	o, err := t.dirFor(real)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	defer o.Close()
+	if isWithinPath(real, o.path) {
+		return TrashItem{}, errContainsTrash
+	}
+
+	size := info.Size()
+	if info.IsDir() {
+		size = diskUsage(real)
+	}
+	if size > t.jail.trashMaxBytes {
+		return TrashItem{}, errTrashTooLarge
+	}
+
+	// 移動元の親ディレクトリもリンクを辿らずに開き、差し替えで別の場所の
+	// ファイルをゴミ箱へ移させない
+	parent, err := openDirNoFollow(filepath.Dir(real))
+	if err != nil {
+		return TrashItem{}, err
+	}
+	defer parent.Close()
+
+	original := real
+	if o.topdir != "" {
+		original, _ = filepath.Rel(o.topdir, real)
+	}
+	deleted := time.Now()
+	name, err := o.reserve(filepath.Base(real), original, deleted)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if err := unix.Renameat(int(parent.Fd()), filepath.Base(real), int(o.filesDir.Fd()), name); err != nil {
+		o.removeInfo(name)
+		if errors.Is(err, syscall.EXDEV) {
+			return TrashItem{}, errTrashUnavailable
+		}
+		return TrashItem{}, &os.LinkError{Op: "rename", Old: real, New: o.file(name), Err: err}
+	}
+	if info.IsDir() {
+		o.cacheSizes(map[string]int64{name: size})
+	}
+
+	t.expire()
+	return TrashItem{
+		ID:           trashItemID(o.trashDir, name),
+		Name:         filepath.Base(real),
+		OriginalPath: real,
+		DeletedAt:    deleted.Truncate(time.Second),
+		Size:         size,
+		IsDir:        info.IsDir(),
+		TrashDir:     o.path,
+	}, nil
+}
+
+func (td trashDir) file(name string) string {
+	return filepath.Join(td.path, "files", name)
+}
+
+func (td trashDir) info(name string) string {
+	return filepath.Join(td.path, "info", name+".trashinfo")
+}
+
+// entry returns a path to an item that resolves through the opened files/
+// directory, for reading code that needs a path
+func (o *openTrash) entry(name string) string {
+	return "/proc/self/fd/" + strconv.Itoa(int(o.filesDir.Fd())) + "/" + name
+}
+
+func (o *openTrash) lstat(name string) (*unix.Stat_t, error) {
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(o.filesDir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (o *openTrash) statInfo(name string) (*unix.Stat_t, error) {
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(o.infoDir.Fd()), name+".trashinfo", &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (o *openTrash) removeInfo(name string) error {
+	return unix.Unlinkat(int(o.infoDir.Fd()), name+".trashinfo", 0)
+}
+
+// reserve writes the info file under a free name. Creating it exclusively
+// claims the name, so concurrent deletions of equally named files do not clash.
+func (o *openTrash) reserve(base, original string, deleted time.Time) (string, error) {
+	stem, ext := base, ""
+	if strings.LastIndex(base, ".") > 0 {
+		ext = filepath.Ext(base)
+		stem = strings.TrimSuffix(base, ext)
+	}
+
+	content := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: original}).EscapedPath(), deleted.Format(trashDateFormat))
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+		}
+		fd, err := unix.Openat(int(o.infoDir.Fd()), name+".trashinfo",
+			unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+		if errors.Is(err, unix.EEXIST) {
+			continue
+		}
+		if err != nil {
+			return "", &os.PathError{Op: "open", Path: o.info(name), Err: err}
+		}
+		file := os.NewFile(uintptr(fd), o.info(name))
+		// 情報ファイルのない残骸が files/ にある場合は別の名前にする
+		if _, err := o.lstat(name); err == nil {
+			file.Close()
+			o.removeInfo(name)
+			continue
+		}
+		if o.uid >= 0 {
+			err = file.Chown(o.uid, o.gid)
+		}
+		if err == nil {
+			_, err = file.WriteString(content)
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			o.removeInfo(name)
+			return "", err
+		}
+		return name, nil
+	}
+}
+
+// readInfo parses an item's info file and returns the absolute original path
+func (o *openTrash) readInfo(name string) (string, time.Time, error) {
+	file, err := openAt(o.infoDir, name+".trashinfo")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer file.Close()
+
+	var original string
+	var deleted time.Time
+	inGroup := false
+	scanner := bufio.NewScanner(io.LimitReader(file, 64<<10))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inGroup = line == "[Trash Info]"
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !inGroup || !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			original, err = url.PathUnescape(value)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+		case "DeletionDate":
+			deleted, _ = time.ParseInLocation(trashDateFormat, value, time.Local)
+		}
+	}
+	if original == "" {
+		return "", time.Time{}, errors.New("invalid trash info file")
+	}
+	if !filepath.IsAbs(original) {
+		original = filepath.Join(o.topdir, original)
+	}
+	return filepath.Clean(original), deleted, scanner.Err()
+}
+
+// items lists a trash directory. Info files whose item is gone are removed.
+func (o *openTrash) items() []TrashItem {
+	// 読み取り位置を共有しないよう、info/ を開き直して一覧する
+	list, err := openDirAt(o.infoDir, ".", false, 0, -1, -1)
+	if err != nil {
+		return nil
+	}
+	names, err := list.Readdirnames(-1)
+	list.Close()
+	if err != nil {
+		return nil
+	}
+
+	cached := o.readSizes()
+	sizes := map[string]int64{}
+	changed := false
+	var items []TrashItem
+	for _, entry := range names {
+		name, ok := strings.CutSuffix(entry, ".trashinfo")
+		if !ok {
+			continue
+		}
+		infoStat, err := o.statInfo(name)
+		if err != nil || infoStat.Mode&unix.S_IFMT != unix.S_IFREG {
+			continue
+		}
+		st, err := o.lstat(name)
+		if errors.Is(err, unix.ENOENT) {
+			o.removeInfo(name)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		original, deleted, err := o.readInfo(name)
+		if err != nil {
+			continue
+		}
+
+		isDir := st.Mode&unix.S_IFMT == unix.S_IFDIR
+		size := st.Size
+		if isDir {
+			// directorysizes is only valid while the info file is unchanged
+			if c, ok := cached[name]; ok && c.mtime == infoStat.Mtim.Sec {
+				size = c.size
+			} else {
+				size = diskUsage(o.entry(name))
+				changed = true
+			}
+			sizes[name] = size
+		}
+
+		items = append(items, TrashItem{
+			ID:           trashItemID(o.trashDir, name),
+			Name:         filepath.Base(original),
+			OriginalPath: original,
+			DeletedAt:    deleted,
+			Size:         size,
+			IsDir:        isDir,
+			TrashDir:     o.path,
+		})
+	}
+	if changed || len(sizes) != len(cached) {
+		o.writeSizes(sizes)
+	}
+	return items
+}
+
+type trashSize struct {
+	size  int64
+	mtime int64
+}
+
+// readSizes reads the directorysizes cache: "size mtime name" per line, where
+// mtime is that of the item's info file and name is percent-encoded
+func (o *openTrash) readSizes() map[string]trashSize {
+	sizes := map[string]trashSize{}
+	file, err := openAt(o.root, "directorysizes")
+	if err != nil {
+		return sizes
+	}
+	data, err := io.ReadAll(io.LimitReader(file, 16<<20))
+	file.Close()
+	if err != nil {
+		return sizes
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err1 := strconv.ParseInt(fields[0], 10, 64)
+		mtime, err2 := strconv.ParseInt(fields[1], 10, 64)
+		name, err3 := url.PathUnescape(fields[2])
+		if err1 == nil && err2 == nil && err3 == nil {
+			sizes[name] = trashSize{size, mtime}
+		}
+	}
+	return sizes
+}
+
+// writeSizes replaces the directorysizes cache with the given directory sizes
+func (o *openTrash) writeSizes(sizes map[string]int64) {
+	var b strings.Builder
+	for name, size := range sizes {
+		st, err := o.statInfo(name)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%d %d %s\n", size, st.Mtim.Sec, url.PathEscape(name))
+	}
+	// サーバーがrootで動作している場合も所有者をゴミ箱の持ち主に揃える
+	var st unix.Stat_t
+	if unix.Fstat(int(o.root.Fd()), &st) == nil {
+		writeFileAt(o.root, "directorysizes", []byte(b.String()), 0600, int(st.Uid), int(st.Gid))
+	}
+}
+
+// cacheSizes adds entries to the directorysizes cache
+func (o *openTrash) cacheSizes(add map[string]int64) {
+	sizes := map[string]int64{}
+	for name, c := range o.readSizes() {
+		sizes[name] = c.size
+	}
+	for name, size := range add {
+		sizes[name] = size
+	}
+	o.writeSizes(sizes)
+}
+
+// diskUsage sums the sizes of everything below path without following symlinks
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// all lists the items of every trash directory whose original path lies in
+// the user's jail; a trash shared by server users is not exposed across jails
+func (t *userTrash) all() []TrashItem {
+	var items []TrashItem
+	for _, td := range t.dirs() {
+		o, err := t.open(td)
+		if err != nil {
+			continue
+		}
+		for _, item := range o.items() {
+			if t.jail.check(item.OriginalPath) == nil {
+				items = append(items, item)
+			}
+		}
+		o.Close()
+	}
+	return items
+}
+
+// expire purges items older than the age limit, then the oldest items until
+// all trash directories together fit the size limit
+func (t *userTrash) expire() {
+	items := t.all()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.Before(items[j].DeletedAt)
+	})
+
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	cutoff := time.Now().Add(-t.jail.trashMaxAge)
+	for _, item := range items {
+		if !item.DeletedAt.Before(cutoff) && total <= t.jail.trashMaxBytes {
+			break
+		}
+		if o, name, err := t.lookup(item.ID); err == nil {
+			if o.purge(name) == nil {
+				total -= item.Size
+			}
+			o.Close()
+		}
+	}
+}
+
+func trashItemID(td trashDir, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(td.file(name)))
+}
+
+// lookup decodes an item ID, checks that it names an item in one of the
+// user's trash directories and opens that trash; the caller closes it
+func (t *userTrash) lookup(id string) (*openTrash, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return nil, "", errTrashItemMissing
+	}
+	path := string(decoded)
+	name := filepath.Base(path)
+	files := filepath.Dir(path)
+	if filepath.Clean(path) != path || filepath.Base(files) != "files" || name == "." || name == ".." {
+		return nil, "", errTrashItemMissing
+	}
+
+	for _, td := range t.dirs() {
+		if td.path != filepath.Dir(files) {
+			continue
+		}
+		o, err := t.open(td)
+		if err != nil {
+			return nil, "", errTrashItemMissing
+		}
+		original, _, err := o.readInfo(name)
+		if err != nil {
+			o.Close()
+			return nil, "", errTrashItemMissing
+		}
+		if err := t.jail.check(original); err != nil {
+			o.Close()
+			return nil, "", err
+		}
+		return o, name, nil
+	}
+	return nil, "", errTrashItemMissing
+}
+
+// purge deletes an item for good; the info file goes last so the name stays
+// claimed until the item is gone
+func (o *openTrash) purge(name string) error {
+	if err := removeAllAt(o.filesDir, name); err != nil {
+		return err
+	}
+	if err := o.removeInfo(name); err != nil {
+		return &os.PathError{Op: "remove", Path: o.info(name), Err: err}
+	}
+	return nil
+}
+
+// restore moves an item back to its original location. With the "rename"
+// policy an occupied location gets a free name, otherwise it is a conflict.
+func (t *userTrash) restore(o *openTrash, name, conflict string) TrashRestoreResult {
+	result := TrashRestoreResult{ID: trashItemID(o.trashDir, name)}
+	fail := func(err error) TrashRestoreResult {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	original, _, err := o.readInfo(name)
+	if err != nil {
+		return fail(err)
+	}
+	target, err := t.jail.resolve(original, false)
+	if err != nil {
+		return fail(err)
+	}
+	st, err := o.lstat(name)
+	if err != nil {
+		return fail(&os.PathError{Op: "lstat", Path: o.file(name), Err: err})
+	}
+	if _, err := os.Lstat(target); err == nil {
+		if conflict != "rename" {
+			result.Path = target
+			result.Status = "conflict"
+			return result
+		}
+		target = availableName(target, st.Mode&unix.S_IFMT == unix.S_IFDIR)
+	}
+
+	// 元のディレクトリが削除されている場合は作り直す
+	existing := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if err := t.jail.checkPermission(existing, 2|1, ""); err != nil {
+		return fail(err)
+	}
+	rel, err := filepath.Rel(existing, filepath.Dir(target))
+	if err != nil {
+		return fail(err)
+	}
+	base, err := openDirNoFollow(existing)
+	if err != nil {
+		return fail(err)
+	}
+	uid, gid := t.jail.accountOwner()
+	parent, err := mkdirAllAt(base, rel, 0755, uid, gid)
+	base.Close()
+	if err != nil {
+		return fail(err)
+	}
+	defer parent.Close()
+	if err := unix.Renameat(int(o.filesDir.Fd()), name, int(parent.Fd()), filepath.Base(target)); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return fail(errors.New("the original location is now on another filesystem"))
+		}
+		return fail(&os.LinkError{Op: "rename", Old: o.file(name), New: target, Err: err})
+	}
+	o.removeInfo(name)
+
+	result.Path = target
+	result.Status = "restored"
+	return result
+}
+
+func userTrashFor(c *gin.Context) (*userTrash, bool) {
+	jail, ok := fileJailFor(c)
+	if !ok {
+		return nil, false
+	}
+	trash, err := newUserTrash(jail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to locate the trash: " + err.Error()})
+		return nil, false
+	}
+	return trash, true
+}
+
+// ListTrash returns the items in the user's trash, newest first. Expired
+// items are purged first.
+func ListTrash(c *gin.Context) {
+	trash, ok := userTrashFor(c)
+	if !ok {
+		return
+	}
+	trash.expire()
+
+	items := append([]TrashItem{}, trash.all()...)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":        items,
+		"total_bytes":  total,
+		"max_bytes":    trash.jail.trashMaxBytes,
+		"max_age_days": int(trash.jail.trashMaxAge / (24 * time.Hour)),
+	})
+}
+
+// RestoreTrash moves {"ids"} back to where they were deleted from.
+// {"conflict": "rename"} restores next to an existing file instead of
+// reporting a conflict.
+func RestoreTrash(c *gin.Context) {
+	var request struct {
+		IDs      []string `json:"ids"`
+		Conflict string   `json:"conflict"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if len(request.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No items selected"})
+		return
+	}
+	if request.Conflict != "" && request.Conflict != "skip" && request.Conflict != "rename" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown conflict policy: " + request.Conflict})
+		return
+	}
+
+	trash, ok := userTrashFor(c)
+	if !ok {
+		return
+	}
+
+	results := make([]TrashRestoreResult, 0, len(request.IDs))
+	for _, id := range request.IDs {
+		o, name, err := trash.lookup(id)
+		if err != nil {
+			results = append(results, TrashRestoreResult{ID: id, Status: "failed", Error: err.Error()})
+			continue
+		}
+		results = append(results, trash.restore(o, name, request.Conflict))
+		o.Close()
+	}
+	c.JSON(http.StatusOK, gin.H{"items": results})
+}
+
+// PurgeTrashItem permanently deletes one item from the trash
+func PurgeTrashItem(c *gin.Context) {
+	trash, ok := userTrashFor(c)
+	if !ok {
+		return
+	}
+	o, name, err := trash.lookup(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, errTrashItemMissing) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			respondFileError(c, err, "Trash item not found")
+		}
+		return
+	}
+	defer o.Close()
+	if err := o.purge(name); err != nil {
+		respondFileError(c, err, "Failed to delete")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Permanently deleted"})
+}
+
+// EmptyTrash permanently deletes everything in the user's trash
+func EmptyTrash(c *gin.Context) {
+	trash, ok := userTrashFor(c)
+	if !ok {
+		return
+	}
+
+	purged := 0
+	var bytes int64
+	failed := []string{}
+	for _, item := range trash.all() {
+		o, name, err := trash.lookup(item.ID)
+		if err == nil {
+			err = o.purge(name)
+			o.Close()
+		}
+		if err != nil {
+			failed = append(failed, item.OriginalPath+": "+err.Error())
+			continue
+		}
+		purged++
+		bytes += item.Size
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged, "bytes": bytes, "failed": failed})
+}
+
+// respondTrashError reports why an item could not be moved to the trash
+func respondTrashError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errTrashTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, errTrashUnavailable), errors.Is(err, errContainsTrash):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondFileError(c, err, "Failed to move to trash")
+	}
+}
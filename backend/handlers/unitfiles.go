@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UnitFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	DropIn  bool   `json:"drop_in"`
+	Vendor  bool   `json:"vendor"`
+}
+
+const systemdAdminDir = "/etc/systemd/system"
+
+var dropInNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.conf$`)
+
+// GetUnitFile returns the effective unit definition like `systemctl cat`:
+// the unit file followed by all drop-ins in the order systemd applies them
+func GetUnitFile(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	output, err := exec.Command("systemctl", "show", "-p", "FragmentPath,DropInPaths", "--", unit).Output()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get unit paths: " + err.Error()})
+		return
+	}
+
+	props := map[string]string{}
+	if parsed := parseSystemctlShow(output); len(parsed) > 0 {
+		props = parsed[0]
+	}
+
+	files := []UnitFile{}
+	if fragment := props["FragmentPath"]; fragment != "" {
+		if content, err := os.ReadFile(fragment); err == nil {
+			files = append(files, UnitFile{
+				Path:    fragment,
+				Content: string(content),
+				Vendor:  !strings.HasPrefix(fragment, systemdAdminDir+"/"),
+			})
+		}
+	}
+	for _, path := range strings.Fields(props["DropInPaths"]) {
+		if content, err := os.ReadFile(path); err == nil {
+			files = append(files, UnitFile{
+				Path:    path,
+				Content: string(content),
+				DropIn:  true,
+				Vendor:  !strings.HasPrefix(path, systemdAdminDir+"/"),
+			})
+		}
+	}
+
+	// systemctl catと同じ形式の結合済みテキスト
+	var combined strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&combined, "# %s\n%s\n", f.Path, strings.TrimRight(f.Content, "\n"))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unit":      unit,
+		"files":     files,
+		"effective": combined.String(),
+	})
+}
+
+// ListUnitOverrides returns the drop-ins under /etc/systemd/system/<unit>.d/
+func ListUnitOverrides(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	dir := dropInDir(unit)
+	overrides := []UnitFile{}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read drop-in directory"})
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		overrides = append(overrides, UnitFile{
+			Path:    path,
+			Content: string(content),
+			DropIn:  true,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unit":      unit,
+		"directory": dir,
+		"overrides": overrides,
+	})
+}
+
+// VerifyUnitOverride validates a drop-in with systemd-analyze verify without saving it
+func VerifyUnitOverride(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name    string `json:"name"`
+		Content string `json:"content"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" {
+		request.Name = "override.conf"
+	}
+	if !dropInNamePattern.MatchString(request.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drop-in name"})
+		return
+	}
+
+	output, err := verifyDropIn(unit, request.Name, request.Content)
+	c.JSON(http.StatusOK, gin.H{
+		"valid":  err == nil,
+		"output": output,
+	})
+}
+
+// SaveUnitOverride verifies and atomically writes a drop-in, then reloads systemd
+func SaveUnitOverride(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name    string `json:"name"`
+		Content string `json:"content"`
+		Restart bool   `json:"restart"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" {
+		request.Name = "override.conf"
+	}
+	if !dropInNamePattern.MatchString(request.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drop-in name"})
+		return
+	}
+
+	if output, err := verifyDropIn(unit, request.Name, request.Content); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  "Unit verification failed",
+			"output": output,
+		})
+		return
+	}
+
+	dir := dropInDir(unit)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create drop-in directory: " + err.Error()})
+		return
+	}
+
+	path := filepath.Join(dir, request.Name)
+	if err := writeFileAtomic(path, []byte(request.Content), 0644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write drop-in: " + err.Error()})
+		return
+	}
+
+	if output, err := daemonReload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "daemon-reload failed: " + err.Error(), "output": output})
+		return
+	}
+
+	response := gin.H{
+		"message": "Override saved",
+		"path":    path,
+	}
+	if request.Restart {
+		result := controlUnit(unit, "try-restart", []string{"--no-ask-password", "try-restart"})
+		response["restart"] = result
+	}
+	if state, err := showUnit(unit); err == nil {
+		response["state"] = state
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteUnitOverride removes a single drop-in and reloads systemd
+func DeleteUnitOverride(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	name := c.Query("name")
+	if !dropInNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drop-in name"})
+		return
+	}
+
+	path := filepath.Join(dropInDir(unit), name)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drop-in not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove drop-in: " + err.Error()})
+		return
+	}
+
+	// 空になったディレクトリは削除する
+	os.Remove(dropInDir(unit))
+
+	if output, err := daemonReload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "daemon-reload failed: " + err.Error(), "output": output})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Override removed",
+		"path":    path,
+	})
+}
+
+// RevertUnit drops all local overrides and returns the unit to its vendor version
+func RevertUnit(c *gin.Context) {
+	unit, ok := unitParam(c)
+	if !ok {
+		return
+	}
+
+	output, err := exec.Command("systemctl", "--no-ask-password", "revert", "--", unit).CombinedOutput()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":  "Failed to revert unit: " + err.Error(),
+			"output": string(output),
+		})
+		return
+	}
+
+	// systemctl revert reloads the manager itself
+	response := gin.H{
+		"message": "Unit reverted to vendor version",
+		"output":  string(output),
+	}
+	if state, err := showUnit(unit); err == nil {
+		response["state"] = state
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// verifyDropIn copies the unit with the proposed drop-in into a scratch directory
+// and runs systemd-analyze verify on it
+func verifyDropIn(unit, name, content string) (string, error) {
+	fragment, err := exec.Command("systemctl", "show", "-p", "FragmentPath", "--value", "--", unit).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate unit: %v", err)
+	}
+	fragmentPath := strings.TrimSpace(string(fragment))
+	if fragmentPath == "" {
+		return "unit file not found", fmt.Errorf("unit %s has no unit file", unit)
+	}
+
+	unitContent, err := os.ReadFile(fragmentPath)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "unit-verify-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	unitPath := filepath.Join(tmpDir, unit)
+	if err := os.WriteFile(unitPath, unitContent, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(unitPath+".d", 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(unitPath+".d", name), []byte(content), 0644); err != nil {
+		return "", err
+	}
+
+	output, err := exec.Command("systemd-analyze", "verify", unitPath).CombinedOutput()
+	return strings.ReplaceAll(string(output), tmpDir+"/", ""), err
+}
+
+func daemonReload() (string, error) {
+	output, err := exec.Command("systemctl", "--no-ask-password", "daemon-reload").CombinedOutput()
+	return string(output), err
+}
+
+func dropInDir(unit string) string {
+	return filepath.Join(systemdAdminDir, unit+".d")
+}
+
+// unitParam reads and validates the :service path parameter
+func unitParam(c *gin.Context) (string, bool) {
+	unit := c.Param("service")
+	if !validUnitName(unit) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unit name"})
+		return "", false
+	}
+	// Drop-in directories need the full name including the type suffix
+	if !strings.Contains(unit, ".") {
+		unit += ".service"
+	}
+	return unit, true
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
@@ -24,7 +24,8 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Range", "If-Range", "If-None-Match"},
+		ExposeHeaders:    []string{"Content-Disposition", "Content-Range", "Accept-Ranges", "ETag"},
 		AllowCredentials: true,
 	}))
 
@@ -43,13 +44,70 @@ func main() {
 		authorized.GET("/services", handlers.ListServices)
 		authorized.GET("/services/:service", handlers.GetServiceStatus)
 		authorized.POST("/services/control", handlers.ControlService)
+		authorized.GET("/services/custom", handlers.ListCustomServices)
+		authorized.POST("/services/custom", handlers.CreateCustomService)
+		authorized.GET("/services/custom/:name", handlers.GetCustomService)
+		authorized.PUT("/services/custom/:name", handlers.UpdateCustomService)
+		authorized.DELETE("/services/custom/:name", handlers.DeleteCustomService)
+		authorized.GET("/services/:service/logs", handlers.QueryJournal)
+		authorized.GET("/services/:service/dependencies", handlers.GetUnitDependencies)
+		authorized.GET("/services/:service/unit", handlers.GetUnitFile)
+		authorized.GET("/services/:service/overrides", handlers.ListUnitOverrides)
+		authorized.PUT("/services/:service/overrides", handlers.SaveUnitOverride)
+		authorized.DELETE("/services/:service/overrides", handlers.DeleteUnitOverride)
+		authorized.POST("/services/:service/verify", handlers.VerifyUnitOverride)
+		authorized.POST("/services/:service/revert", handlers.RevertUnit)
+		
+		// タイマー・cron関連
+		authorized.GET("/timers", handlers.ListTimers)
+		authorized.POST("/timers", handlers.CreateTimer)
+		authorized.POST("/timers/validate", handlers.ValidateCalendar)
+		authorized.GET("/timers/:name", handlers.GetTimer)
+		authorized.PUT("/timers/:name", handlers.UpdateTimer)
+		authorized.DELETE("/timers/:name", handlers.DeleteTimer)
+		authorized.GET("/cron", handlers.ListCronJobs)
+		
+		// ブート解析
+		authorized.GET("/boot/time", handlers.GetBootTime)
+		authorized.GET("/boot/blame", handlers.GetBootBlame)
+		authorized.GET("/boot/critical-chain", handlers.GetCriticalChain)
+		
+		// ジャーナル関連
+		authorized.GET("/journal", handlers.QueryJournal)
+		authorized.GET("/journal/boots", handlers.ListJournalBoots)
 		
 		// ファイル関連
 		authorized.GET("/files", handlers.GetFileList)
 		authorized.GET("/files/content", handlers.GetFileContent)
 		authorized.POST("/files/content", handlers.SaveFileContent)
+		authorized.GET("/files/preview", handlers.GetFilePreview)
+		authorized.GET("/files/versions", handlers.ListFileVersions)
+		authorized.GET("/files/versions/diff", handlers.DiffFileVersion)
+		authorized.POST("/files/versions/restore", handlers.RestoreFileVersion)
 		authorized.POST("/files/directory", handlers.CreateDirectory)
 		authorized.DELETE("/files", handlers.DeleteFile)
+		authorized.POST("/files/upload", handlers.UploadFiles)
+		authorized.GET("/files/uploads", handlers.ListUploads)
+		authorized.POST("/files/uploads", handlers.CreateUpload)
+		authorized.GET("/files/uploads/:id", handlers.GetUpload)
+		authorized.PUT("/files/uploads/:id", handlers.UploadChunk)
+		authorized.DELETE("/files/uploads/:id", handlers.CancelUpload)
+		authorized.GET("/files/trash", handlers.ListTrash)
+		authorized.POST("/files/trash/restore", handlers.RestoreTrash)
+		authorized.DELETE("/files/trash", handlers.EmptyTrash)
+		authorized.DELETE("/files/trash/:id", handlers.PurgeTrashItem)
+		authorized.POST("/files/copy", handlers.CopyFiles)
+		authorized.POST("/files/move", handlers.MoveFiles)
+		authorized.POST("/files/rename", handlers.RenameFile)
+		authorized.POST("/files/chmod", handlers.ChangeMode)
+		authorized.POST("/files/chown", handlers.ChangeOwner)
+		authorized.GET("/files/acl", handlers.GetFileACL)
+		authorized.POST("/files/acl", handlers.SetFileACL)
+		authorized.POST("/files/archive", handlers.CreateArchive)
+		authorized.POST("/files/extract", handlers.ExtractArchive)
+		authorized.GET("/files/jobs", handlers.ListFileJobs)
+		authorized.GET("/files/jobs/:id", handlers.GetFileJob)
+		authorized.DELETE("/files/jobs/:id", handlers.CancelFileJob)
 		
 		// Docker関連
 		authorized.GET("/docker/containers", handlers.ListContainers)
@@ -110,6 +168,11 @@ func main() {
 		authorized.POST("/resources/kill", handlers.KillProcess)
 		authorized.POST("/resources/priority", handlers.SetProcessPriority)
 		authorized.GET("/resources/info", handlers.GetDetailedSystemInfo)
+		authorized.GET("/resources/breakdown", handlers.GetResourceBreakdown)
+		authorized.GET("/resources/sensors", handlers.GetSensors)
+		authorized.GET("/resources/network", handlers.GetNetworkDetails)
+		authorized.GET("/resources/ports", handlers.ListSockets)
+		authorized.POST("/resources/ports/kill", handlers.KillSocketOwner)
 		
 		// APT Package管理関連
 		authorized.GET("/packages", handlers.ListInstalledPackages)
@@ -138,7 +201,13 @@ func main() {
 		terminalGroup.GET("/terminal", handlers.HandleTerminalSession)
 		terminalGroup.GET("/docker/containers/:id/logs/stream", handlers.StreamContainerLogs)
 		terminalGroup.GET("/cuda/gpu-stats/stream", handlers.StreamGPUStats)
-		// terminalGroup.GET("/resources/stream", handlers.StreamSystemResources) // TODO: Implement
+		terminalGroup.GET("/resources/stream", handlers.StreamSystemResources)
+		terminalGroup.GET("/journal/stream", handlers.StreamJournal)
+		// ダウンロードはブラウザのリンクから直接開けるようにクエリでトークンを渡す
+		terminalGroup.GET("/files/download", handlers.DownloadFile)
+		terminalGroup.GET("/files/search", handlers.SearchFiles)
+		terminalGroup.GET("/files/watch", handlers.WatchFiles)
+		terminalGroup.GET("/files/thumbnail", handlers.GetThumbnail)
 	}
 
 	// 静的ファイルの提供 (Reactビルド後のファイル)